@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 
 	"code.google.com/p/goauth2/oauth"
 	"github.com/bugsnag/bugsnag-go"
@@ -20,8 +22,22 @@ type AsanaService struct {
 	token oauth.Token
 }
 
+// AsanaParams selects an Asana workspace and options of task import
 type AsanaParams struct {
 	AccountID int64 `json:"account_id"`
+	// Clients are imported from project teams with "teams"
+	Clients string `json:"clients,omitempty"`
+	// Sections are imported as task name prefix ("prefix"),
+	// as todolists ("todolists") or not at all (empty)
+	Sections string `json:"sections,omitempty"`
+	// Subtasks are imported as tasks named with their parent
+	Subtasks bool `json:"subtasks,omitempty"`
+	// Assignees are set as task users through users connection
+	Assignees bool `json:"assignees,omitempty"`
+	// CustomField is GID of a number field copied as task estimate,
+	// CustomFieldUnit is "hours" (default) or "minutes"
+	CustomField     string `json:"custom_field,omitempty"`
+	CustomFieldUnit string `json:"custom_field_unit,omitempty"`
 }
 
 func (s *AsanaService) Name() string {
@@ -46,6 +62,19 @@ func (s *AsanaService) setParams(b []byte) error {
 	if s.AsanaParams == nil || s.AccountID == 0 {
 		return errors.New("account_id must be present")
 	}
+	if s.AsanaParams.Clients != "" && s.AsanaParams.Clients != asanaTeamsAsClients {
+		return fmt.Errorf("clients must be %q", asanaTeamsAsClients)
+	}
+	switch s.Sections {
+	case "", asanaSectionsAsPrefix, asanaSectionsAsTodoLists:
+	default:
+		return fmt.Errorf("sections must be %q or %q", asanaSectionsAsPrefix, asanaSectionsAsTodoLists)
+	}
+	switch s.CustomFieldUnit {
+	case "", "hours", "minutes":
+	default:
+		return errors.New("custom_field_unit must be hours or minutes")
+	}
 	return nil
 }
 
@@ -54,8 +83,13 @@ func (s *AsanaService) setAuthData(b []byte) error {
 }
 
 func (s *AsanaService) client() *asana.Client {
-	t := &oauth.Transport{Token: &s.token}
-	return asana.NewClient(t.Client())
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	c := asana.NewClient(t.Client())
+	c.BaseURL, _ = url.Parse(asanaAPIURL)
+	return c
 }
 
 // Map Asana accounts to local accounts
@@ -114,18 +148,27 @@ func (s *AsanaService) Users() ([]*User, error) {
 	return users, nil
 }
 
-// Map Asana projects to projects
-func (s *AsanaService) Projects() ([]*Project, error) {
-	opt := &asana.Filter{
-		Workspace: s.AccountID,
-		Limit:     asanaPerPageLimit,
+// listProjects returns projects of the workspace with their teams,
+// method is reported on errors
+func (s *AsanaService) listProjects(method string) ([]asanaProject, error) {
+	var projects []asanaProject
+	params := url.Values{
+		"workspace":  {strconv.FormatInt(s.AccountID, 10)},
+		"opt_fields": {"name,archived,color,start_on,due_on,team.name"},
 	}
-	foreignObjects, err := s.client().ListProjects(context.Background(), opt)
+	err := s.getPages("projects", params, func(b json.RawMessage) error {
+		var page []asanaProject
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		projects = append(projects, page...)
+		return nil
+	})
 	if err != nil {
 		bugsnag.Notify(err, bugsnag.MetaData{
 			"asana_service": {
-				"method":           "Projects()",
-				"remote_method":    "ListProjects()",
+				"method":           method,
+				"remote_method":    "listProjects()",
 				"filter_workspace": s.AccountID,
 				"asana_account_id": s.AccountID,
 				"workspace_id":     s.WorkspaceID(),
@@ -133,12 +176,51 @@ func (s *AsanaService) Projects() ([]*Project, error) {
 		})
 		return nil, err
 	}
+	return projects, nil
+}
+
+// Map Asana teams of projects to clients when enabled
+func (s *AsanaService) Clients() ([]*Client, error) {
+	if s.AsanaParams == nil || s.AsanaParams.Clients != asanaTeamsAsClients {
+		return s.emptyService.Clients()
+	}
+	foreignProjects, err := s.listProjects("Clients()")
+	if err != nil {
+		return nil, err
+	}
+	var clients []*Client
+	seen := map[string]bool{}
+	for _, project := range foreignProjects {
+		if project.Team == nil || seen[project.Team.GID] {
+			continue
+		}
+		seen[project.Team.GID] = true
+		clients = append(clients, &Client{
+			ForeignID: project.Team.GID,
+			Name:      project.Team.Name,
+		})
+	}
+	return clients, nil
+}
+
+// Map Asana projects to projects
+func (s *AsanaService) Projects() ([]*Project, error) {
+	foreignObjects, err := s.listProjects("Projects()")
+	if err != nil {
+		return nil, err
+	}
 	var projects []*Project
 	for _, object := range foreignObjects {
 		project := Project{
 			ForeignID: object.GID,
 			Name:      object.Name,
 			Active:    !object.Archived,
+			Color:     asanaColors[object.Color],
+			StartDate: object.StartOn,
+			DueDate:   object.DueOn,
+		}
+		if object.Team != nil && s.AsanaParams.Clients == asanaTeamsAsClients {
+			project.foreignClientID = object.Team.GID
 		}
 		projects = append(projects, &project)
 	}
@@ -147,16 +229,55 @@ func (s *AsanaService) Projects() ([]*Project, error) {
 
 // Map Asana tasks to tasks
 func (s *AsanaService) Tasks() ([]*Task, error) {
-	opt := &asana.Filter{
-		Workspace: s.AccountID,
-		Limit:     asanaPerPageLimit,
+	foreignProjects, err := s.listProjects("Tasks()")
+	if err != nil {
+		return nil, err
 	}
-	foreignProjects, err := s.client().ListProjects(context.Background(), opt)
+
+	var tasks []*Task
+	for _, project := range foreignProjects {
+		foreignObjects, err := s.listTasks("projects/" + project.GID + "/tasks")
+		if err != nil {
+			s.notifyTasksError(err, "Tasks()", "listTasks()", project.GID)
+			return nil, err
+		}
+		for _, object := range foreignObjects {
+			mapped, err := s.tasksFor(object, project.GID, "")
+			if err != nil {
+				s.notifyTasksError(err, "Tasks()", "listTasks()", project.GID)
+				return nil, err
+			}
+			tasks = append(tasks, mapped...)
+		}
+	}
+	return tasks, nil
+}
+
+// Map Asana tags of the workspace to tags
+func (s *AsanaService) Tags() ([]*Tag, error) {
+	var tags []*Tag
+	params := url.Values{
+		"workspace":  {strconv.FormatInt(s.AccountID, 10)},
+		"opt_fields": {"name"},
+	}
+	err := s.getPages("tags", params, func(b json.RawMessage) error {
+		var page []asanaReference
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		for _, object := range page {
+			tags = append(tags, &Tag{
+				ForeignID: object.GID,
+				Name:      object.Name,
+			})
+		}
+		return nil
+	})
 	if err != nil {
 		bugsnag.Notify(err, bugsnag.MetaData{
 			"asana_service": {
-				"method":           "Tasks()",
-				"remote_method":    "ListProjects()",
+				"method":           "Tags()",
+				"remote_method":    "tags",
 				"filter_workspace": s.AccountID,
 				"asana_account_id": s.AccountID,
 				"workspace_id":     s.WorkspaceID(),
@@ -164,36 +285,87 @@ func (s *AsanaService) Tasks() ([]*Task, error) {
 		})
 		return nil, err
 	}
+	return tags, nil
+}
 
-	var tasks []*Task
-	for _, project := range foreignProjects {
-		// list task only accept project filter
-		opt := &asana.Filter{
-			Project: numberStrToInt64(project.GID),
-			Limit:   asanaPerPageLimit,
+// Map Asana teams of the organization to groups
+func (s *AsanaService) Groups() ([]*Group, error) {
+	var groups []*Group
+	params := url.Values{"opt_fields": {"name"}}
+	path := fmt.Sprintf("organizations/%d/teams", s.AccountID)
+	err := s.getPages(path, params, func(b json.RawMessage) error {
+		var page []asanaReference
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
 		}
-		foreignObjects, err := s.client().ListTasks(context.Background(), opt)
-		if err != nil {
-			bugsnag.Notify(err, bugsnag.MetaData{
-				"asana_service": {
-					"method":           "Tasks()",
-					"remote_method":    "ListTasks()",
-					"filter_project":   project.GID,
-					"asana_account_id": s.AccountID,
-					"workspace_id":     s.WorkspaceID(),
-				},
+		for _, object := range page {
+			groups = append(groups, &Group{
+				ForeignID: object.GID,
+				Name:      object.Name,
 			})
-			return nil, err
 		}
-		for _, object := range foreignObjects {
-			task := Task{
-				ForeignID:        object.GID,
-				Name:             object.Name,
-				Active:           !object.Completed,
-				foreignProjectID: project.GID,
+		return nil
+	})
+	for i := 0; err == nil && i < len(groups); i++ {
+		path = "teams/" + groups[i].ForeignID + "/users"
+		err = s.getPages(path, url.Values{"opt_fields": {"gid"}}, func(b json.RawMessage) error {
+			var page []asanaReference
+			if err := json.Unmarshal(b, &page); err != nil {
+				return err
+			}
+			for _, object := range page {
+				groups[i].ForeignUserIDs = append(groups[i].ForeignUserIDs, object.GID)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		bugsnag.Notify(err, bugsnag.MetaData{
+			"asana_service": {
+				"method":           "Groups()",
+				"remote_method":    path,
+				"asana_account_id": s.AccountID,
+				"workspace_id":     s.WorkspaceID(),
+			},
+		})
+		return nil, err
+	}
+	return groups, nil
+}
+
+// Map members of Asana projects to project members
+func (s *AsanaService) ProjectMembers() (map[string][]string, error) {
+	members := make(map[string][]string)
+	params := url.Values{
+		"workspace":  {strconv.FormatInt(s.AccountID, 10)},
+		"opt_fields": {"members.gid"},
+	}
+	err := s.getPages("projects", params, func(b json.RawMessage) error {
+		var page []struct {
+			GID     string           `json:"gid"`
+			Members []asanaReference `json:"members"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		for _, object := range page {
+			for _, member := range object.Members {
+				members[object.GID] = append(members[object.GID], member.GID)
 			}
-			tasks = append(tasks, &task)
 		}
+		return nil
+	})
+	if err != nil {
+		bugsnag.Notify(err, bugsnag.MetaData{
+			"asana_service": {
+				"method":           "ProjectMembers()",
+				"remote_method":    "projects",
+				"filter_workspace": s.AccountID,
+				"asana_account_id": s.AccountID,
+				"workspace_id":     s.WorkspaceID(),
+			},
+		})
+		return nil, err
 	}
-	return tasks, nil
+	return members, nil
 }
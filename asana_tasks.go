@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"code.google.com/p/goauth2/oauth"
+	"github.com/bugsnag/bugsnag-go"
+	"github.com/range-labs/go-asana/asana"
+)
+
+// Clients and sections option values of Asana pipes
+const (
+	asanaTeamsAsClients = "teams"
+
+	asanaSectionsAsPrefix    = "prefix"
+	asanaSectionsAsTodoLists = "todolists"
+)
+
+// asanaAPIURL is replaced in tests
+var asanaAPIURL = "https://app.asana.com/api/1.0/"
+
+// asanaColors maps Asana color names to hex colors
+var asanaColors = map[string]string{
+	"dark-pink":       "#e362e3",
+	"dark-green":      "#5da283",
+	"dark-blue":       "#4573d2",
+	"dark-red":        "#e8384f",
+	"dark-teal":       "#4ecbc4",
+	"dark-brown":      "#8d84e8",
+	"dark-orange":     "#fd612c",
+	"dark-purple":     "#aa62e3",
+	"dark-warm-gray":  "#8da3a6",
+	"light-pink":      "#f9aaef",
+	"light-green":     "#b4dd80",
+	"light-blue":      "#9ee7e3",
+	"light-red":       "#f9aaaf",
+	"light-teal":      "#a4cfff",
+	"light-brown":     "#e6c79c",
+	"light-orange":    "#fd9a00",
+	"light-purple":    "#b36bd4",
+	"light-warm-gray": "#c7c4c4",
+}
+
+// asanaTaskFields are requested for tasks and subtasks,
+// go-asana tasks lack memberships and custom fields
+const asanaTaskFields = "name,completed,assignee.gid,num_subtasks,memberships.project.gid,memberships.section.name,custom_fields.gid,custom_fields.number_value"
+
+type (
+	asanaPage struct {
+		Data     json.RawMessage `json:"data"`
+		NextPage *asana.NextPage `json:"next_page"`
+	}
+
+	asanaReference struct {
+		GID  string `json:"gid"`
+		Name string `json:"name"`
+	}
+
+	asanaProject struct {
+		GID      string          `json:"gid"`
+		Name     string          `json:"name"`
+		Archived bool            `json:"archived"`
+		Color    string          `json:"color"`
+		StartOn  string          `json:"start_on"`
+		DueOn    string          `json:"due_on"`
+		Team     *asanaReference `json:"team"`
+	}
+
+	asanaSection struct {
+		GID  string `json:"gid"`
+		Name string `json:"name"`
+	}
+
+	asanaTask struct {
+		GID         string `json:"gid"`
+		Name        string `json:"name"`
+		Completed   bool   `json:"completed"`
+		NumSubtasks int    `json:"num_subtasks"`
+		Assignee    *struct {
+			GID string `json:"gid"`
+		} `json:"assignee"`
+		Memberships []struct {
+			Project struct {
+				GID string `json:"gid"`
+			} `json:"project"`
+			Section *asanaSection `json:"section"`
+		} `json:"memberships"`
+		CustomFields []struct {
+			GID         string   `json:"gid"`
+			NumberValue *float64 `json:"number_value"`
+		} `json:"custom_fields"`
+	}
+)
+
+// getPages requests all pages of path, handle decodes data of one page
+func (s *AsanaService) getPages(path string, params url.Values, handle func(b json.RawMessage) error) error {
+	params.Set("limit", fmt.Sprint(asanaPerPageLimit))
+	uri := asanaAPIURL + path + "?" + params.Encode()
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	for uri != "" {
+		resp, err := t.Client().Get(uri)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("asana: %s failed with status code %d", path, resp.StatusCode)
+		}
+		var page asanaPage
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		if err := handle(page.Data); err != nil {
+			return err
+		}
+		uri = ""
+		if page.NextPage != nil {
+			uri = page.NextPage.URI
+		}
+	}
+	return nil
+}
+
+func (s *AsanaService) listTasks(path string) ([]asanaTask, error) {
+	var tasks []asanaTask
+	params := url.Values{"opt_fields": {asanaTaskFields}}
+	err := s.getPages(path, params, func(b json.RawMessage) error {
+		var page []asanaTask
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		tasks = append(tasks, page...)
+		return nil
+	})
+	return tasks, err
+}
+
+func (s *AsanaService) listSections(projectGID string) ([]asanaSection, error) {
+	var sections []asanaSection
+	params := url.Values{"opt_fields": {"name"}}
+	err := s.getPages("projects/"+projectGID+"/sections", params, func(b json.RawMessage) error {
+		var page []asanaSection
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		sections = append(sections, page...)
+		return nil
+	})
+	return sections, err
+}
+
+// tasksFor maps the task and, when enabled, its subtasks,
+// subtask names get name of the parent as context
+func (s *AsanaService) tasksFor(object asanaTask, projectGID, parentName string) ([]*Task, error) {
+	name := object.Name
+	if parentName != "" {
+		name = parentName + " / " + name
+	} else if s.Sections == asanaSectionsAsPrefix {
+		for _, membership := range object.Memberships {
+			if membership.Project.GID == projectGID && membership.Section != nil && membership.Section.Name != "" {
+				name = fmt.Sprintf("[%s] %s", membership.Section.Name, name)
+			}
+		}
+	}
+	task := &Task{
+		ForeignID:        object.GID,
+		Name:             name,
+		Active:           !object.Completed,
+		EstimatedSeconds: s.estimateOf(object),
+		foreignProjectID: projectGID,
+	}
+	if s.Assignees && object.Assignee != nil {
+		task.foreignUserID = object.Assignee.GID
+	}
+	tasks := []*Task{task}
+	if !s.Subtasks || object.NumSubtasks == 0 {
+		return tasks, nil
+	}
+	subtasks, err := s.listTasks("tasks/" + object.GID + "/subtasks")
+	if err != nil {
+		return nil, err
+	}
+	for _, subtask := range subtasks {
+		mapped, err := s.tasksFor(subtask, projectGID, name)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, mapped...)
+	}
+	return tasks, nil
+}
+
+// estimateOf returns value of the chosen number custom field in seconds
+func (s *AsanaService) estimateOf(object asanaTask) int {
+	if s.CustomField == "" {
+		return 0
+	}
+	unit := 3600.0
+	if s.CustomFieldUnit == "minutes" {
+		unit = 60
+	}
+	for _, field := range object.CustomFields {
+		if field.GID == s.CustomField && field.NumberValue != nil {
+			return int(math.Round(*field.NumberValue * unit))
+		}
+	}
+	return 0
+}
+
+func (s *AsanaService) notifyTasksError(err error, method, remoteMethod, projectGID string) {
+	bugsnag.Notify(err, bugsnag.MetaData{
+		"asana_service": {
+			"method":           method,
+			"remote_method":    remoteMethod,
+			"filter_project":   projectGID,
+			"asana_account_id": s.AccountID,
+			"workspace_id":     s.WorkspaceID(),
+		},
+	})
+}
+
+// Map Asana sections to todolists when sections are imported as todolists
+func (s *AsanaService) TodoLists() ([]*Task, error) {
+	if s.Sections != asanaSectionsAsTodoLists {
+		return []*Task{}, nil
+	}
+	foreignProjects, err := s.listProjects("TodoLists()")
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	for _, project := range foreignProjects {
+		sections, err := s.listSections(project.GID)
+		if err != nil {
+			s.notifyTasksError(err, "TodoLists()", "listSections()", project.GID)
+			return nil, err
+		}
+		for _, section := range sections {
+			if strings.TrimSpace(section.Name) == "" {
+				continue
+			}
+			tasks = append(tasks, &Task{
+				ForeignID:        section.GID,
+				Name:             section.Name,
+				Active:           !project.Archived,
+				foreignProjectID: project.GID,
+			})
+		}
+	}
+	return tasks, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newAsanaTestServer(t *testing.T) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path + "?" + r.URL.Query().Get("offset") {
+		case "/projects?":
+			w.Write([]byte(`{"data":[
+				{"gid":"1","name":"Website","archived":false,"team":{"gid":"3","name":"Marketing"},"members":[{"gid":"7"},{"gid":"8"}]},
+				{"gid":"2","name":"Ads","archived":true,"team":{"gid":"3","name":"Marketing"}}
+			]}`))
+		case "/projects/2/sections?", "/projects/2/tasks?":
+			w.Write([]byte(`{"data":[]}`))
+		case "/projects/1/sections?":
+			w.Write([]byte(`{"data":[{"gid":"5","name":"Design"},{"gid":"6","name":"Launch"}]}`))
+		case "/projects/1/tasks?":
+			w.Write([]byte(`{"data":[
+				{"gid":"10","name":"Mockups","completed":false,"num_subtasks":1,"assignee":{"gid":"7"},
+				 "memberships":[{"project":{"gid":"1"},"section":{"gid":"5","name":"Design"}}],
+				 "custom_fields":[{"gid":"99","number_value":1.5},{"gid":"98","number_value":4}]}
+			],"next_page":{"offset":"abc","uri":"` + ts.URL + `/projects/1/tasks?offset=abc"}}`))
+		case "/projects/1/tasks?abc":
+			w.Write([]byte(`{"data":[{"gid":"11","name":"Domain","completed":true,
+				"memberships":[{"project":{"gid":"1"},"section":{"gid":"6","name":"Launch"}}]}],"next_page":null}`))
+		case "/tasks/10/subtasks?":
+			w.Write([]byte(`{"data":[{"gid":"12","name":"Logo","completed":false,"assignee":{"gid":"8"}}]}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return ts
+}
+
+func TestAsanaTaskOptions(t *testing.T) {
+	ts := newAsanaTestServer(t)
+	defer ts.Close()
+	defer func(apiURL string) { asanaAPIURL = apiURL }(asanaAPIURL)
+	asanaAPIURL = ts.URL + "/"
+
+	s := getService("asana", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setParams([]byte(`{"account_id":2,"sections":"columns"}`)); err == nil {
+		t.Error("expected unknown sections option to be rejected")
+	}
+	if err := s.setParams([]byte(`{"account_id":2,"sections":"prefix","subtasks":true,"assignees":true,"custom_field":"99"}`)); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := s.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Task{
+		{ForeignID: "10", Name: "[Design] Mockups", Active: true, EstimatedSeconds: 5400, foreignProjectID: "1", foreignUserID: "7"},
+		{ForeignID: "12", Name: "[Design] Mockups / Logo", Active: true, foreignProjectID: "1", foreignUserID: "8"},
+		{ForeignID: "11", Name: "[Launch] Domain", Active: false, foreignProjectID: "1"},
+	}
+	if len(tasks) != len(want) {
+		t.Fatalf("expected %d tasks, got %+v", len(want), tasks)
+	}
+	for i := range want {
+		if *tasks[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], *tasks[i])
+		}
+	}
+	todoLists, err := s.TodoLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todoLists) != 0 {
+		t.Errorf("expected no todolists with sections as prefix, got %+v", todoLists)
+	}
+
+	if err := s.setParams([]byte(`{"account_id":2,"sections":"todolists"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if todoLists, err = s.TodoLists(); err != nil {
+		t.Fatal(err)
+	}
+	if len(todoLists) != 2 || todoLists[0].Name != "Design" || todoLists[1].foreignProjectID != "1" {
+		t.Errorf("expected sections as todolists, got %+v", todoLists)
+	}
+}
+
+func TestAsanaTeamsAsClients(t *testing.T) {
+	ts := newAsanaTestServer(t)
+	defer ts.Close()
+	defer func(apiURL string) { asanaAPIURL = apiURL }(asanaAPIURL)
+	asanaAPIURL = ts.URL + "/"
+
+	s := getService("asana", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setParams([]byte(`{"account_id":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Clients(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected clients to be disabled by default, got %v", err)
+	}
+
+	if err := s.setParams([]byte(`{"account_id":2,"clients":"teams"}`)); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := s.Clients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].ForeignID != "3" || clients[0].Name != "Marketing" {
+		t.Errorf("expected teams as clients, got %+v", clients)
+	}
+	projects, err := s.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects[0].foreignClientID != "3" || projects[1].foreignClientID != "3" {
+		t.Errorf("expected projects to link their team, got %+v", projects)
+	}
+}
@@ -85,3 +85,11 @@ func TestAsanaTask(t *testing.T) {
 		t.Error("should get more than 10 tasks, please create at least 11 tasks and assign them to a project to test pagination")
 	}
 }
+
+func TestAsanaConnectionKeys(t *testing.T) {
+	s := getService("asana", 57)
+	if err := s.setParams([]byte(`{"account_id":1207845612345678}`)); err != nil {
+		t.Fatal(err)
+	}
+	testConnectionKeys(t, s)
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"code.google.com/p/goauth2/oauth"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"github.com/tambet/oauthplain"
+	"net/url"
+	"strings"
 )
 
 type Authorization struct {
@@ -15,36 +16,6 @@ type Authorization struct {
 	Data           []byte
 }
 
-const (
-	selectAuthorizationSQL = `SELECT
-		workspace_id, service, workspace_token, data
-		FROM authorizations
-		WHERE workspace_id = $1
-		AND service = $2
-		LIMIT 1
-  `
-	insertAuthorizationSQL = `WITH existing_auth AS (
-		UPDATE authorizations SET data = $4, workspace_token = $3
-		WHERE workspace_id = $1 AND service = $2
-		RETURNING service
-	),
-	inserted_auth AS (
-		INSERT INTO
-		authorizations(workspace_id, service, workspace_token, data)
-		SELECT $1, $2, $3, $4
-		WHERE NOT EXISTS (SELECT 1 FROM existing_auth)
-		RETURNING service
-	)
-	SELECT * FROM inserted_auth
-	UNION
-	SELECT * FROM existing_auth
-  `
-	deleteAuthorizationSQL = `DELETE FROM authorizations
-		WHERE workspace_id = $1
-		AND service = $2
-	`
-)
-
 func NewAuthorization(workspaceID int, serviceID string) *Authorization {
 	return &Authorization{
 		WorkspaceID: workspaceID,
@@ -53,22 +24,14 @@ func NewAuthorization(workspaceID int, serviceID string) *Authorization {
 }
 
 func loadAuth(s Service) (*Authorization, error) {
-	rows, err := db.Query(selectAuthorizationSQL, s.WorkspaceID(), s.Name())
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	if !rows.Next() {
-		return nil, rows.Err()
-	}
-	var authorization Authorization
-	if err := authorization.load(rows); err != nil {
+	authorization, err := store.LoadAuthorization(s.WorkspaceID(), s.Name())
+	if err != nil || authorization == nil {
 		return nil, err
 	}
 	if err := s.setAuthData(authorization.Data); err != nil {
 		return nil, err
 	}
-	return &authorization, nil
+	return authorization, nil
 }
 
 func (a *Authorization) refresh() error {
@@ -82,7 +45,7 @@ func (a *Authorization) refresh() error {
 	if !token.Expired() {
 		return nil
 	}
-	config, res := oAuth2Configs[a.ServiceID+"_"+environment]
+	config, res := oAuth2Config(a.ServiceID, token.Extra["base_url"])
 	if !res {
 		return errors.New("service OAuth config not found")
 	}
@@ -102,48 +65,51 @@ func (a *Authorization) refresh() error {
 }
 
 func (a *Authorization) save() error {
-	_, err := db.Exec(insertAuthorizationSQL,
-		a.WorkspaceID, a.ServiceID, a.WorkspaceToken, a.Data)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (a *Authorization) load(rows *sql.Rows) error {
-	err := rows.Scan(&a.WorkspaceID, &a.ServiceID, &a.WorkspaceToken, &a.Data)
-	if err != nil {
-		return err
-	}
-	return nil
+	return store.SaveAuthorization(a)
 }
 
 func (a *Authorization) destroy(s Service) error {
-	_, err := db.Exec(deleteAuthorizationSQL, s.WorkspaceID(), s.Name())
-	return err
+	return store.DeleteAuthorization(s.WorkspaceID(), s.Name())
 }
 
 func loadAuthorizations(workspaceID int) (map[string]bool, error) {
-	authorizations := make(map[string]bool)
-	rows, err := db.Query(`
-    SELECT service FROM authorizations
-    WHERE workspace_id = $1`, workspaceID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var service string
-		if err := rows.Scan(&service); err != nil {
-			return nil, err
+	return store.LoadAuthorizedServices(workspaceID)
+}
+
+// instanceServices support authorizing self-managed instances, see oAuth2Config
+var instanceServices = map[string]bool{"gitlab": true}
+
+// oAuth2Config returns the OAuth 2.0 config of the service. Configs of
+// self-managed instances are keyed by their host, e.g. gitlab_production@gitlab.example.com
+func oAuth2Config(serviceID, baseURL string) (*oauth.Config, bool) {
+	key := serviceID + "_" + environment
+	if baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, false
 		}
-		authorizations[service] = true
+		key += "@" + u.Host
 	}
-	return authorizations, nil
+	config, ok := oAuth2Configs[key]
+	return config, ok
+}
+
+// normalizeBaseURL validates the URL of a self-managed instance
+// and removes the trailing slash
+func normalizeBaseURL(baseURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", errors.New("invalid base_url")
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
 }
 
 func oAuth2URL(service string) string {
-	config, ok := oAuth2Configs[service+"_"+environment]
+	return oAuth2InstanceURL(service, "")
+}
+
+func oAuth2InstanceURL(service, baseURL string) string {
+	config, ok := oAuth2Config(service, baseURL)
 	if !ok {
 		return ""
 	}
@@ -186,12 +152,38 @@ func oAuth1Exchange(serviceID string, payload map[string]interface{}) ([]byte, e
 	return b, nil
 }
 
+// apiKeyAuth is the authorization data of services which use
+// an API key and a token given by the user instead of OAuth
+type apiKeyAuth struct {
+	APIKey string `json:"api_key"`
+	Token  string `json:"token"`
+}
+
+func apiKeyExchange(payload map[string]interface{}) ([]byte, error) {
+	apiKey, _ := payload["api_key"].(string)
+	if apiKey == "" {
+		return nil, errors.New("missing api_key")
+	}
+	token, _ := payload["token"].(string)
+	if token == "" {
+		return nil, errors.New("missing token")
+	}
+	return json.Marshal(apiKeyAuth{APIKey: apiKey, Token: token})
+}
+
 func oAuth2Exchange(serviceID string, payload map[string]interface{}) ([]byte, error) {
 	code := payload["code"].(string)
 	if code == "" {
 		return nil, errors.New("missing code")
 	}
-	config, res := oAuth2Configs[serviceID+"_"+environment]
+	baseURL, _ := payload["base_url"].(string)
+	if baseURL != "" {
+		var err error
+		if baseURL, err = normalizeBaseURL(baseURL); err != nil {
+			return nil, err
+		}
+	}
+	config, res := oAuth2Config(serviceID, baseURL)
 	if !res {
 		return nil, errors.New("service OAuth config not found")
 	}
@@ -200,6 +192,12 @@ func oAuth2Exchange(serviceID string, payload map[string]interface{}) ([]byte, e
 	if err != nil {
 		return nil, err
 	}
+	if baseURL != "" {
+		if token.Extra == nil {
+			token.Extra = make(map[string]string)
+		}
+		token.Extra["base_url"] = baseURL
+	}
 	b, err := json.Marshal(token)
 	if err != nil {
 		return nil, err
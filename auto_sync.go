@@ -138,8 +138,7 @@ func autoSyncQueuer() {
 		time.Sleep(duration)
 
 		log.Println("-- Queuer started")
-		_, err := db.Exec(queueAutomaticPipesSQL)
-		if err != nil {
+		if err := store.QueueAutomaticPipes(); err != nil {
 			if !strings.Contains(err.Error(), `duplicate key value violates unique constraint`) {
 				bugsnag.Notify(err)
 			}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -17,10 +18,15 @@ type BasecampService struct {
 	*BasecampParams
 	token         oauth.Token
 	modifiedSince *time.Time
+	// product of the selected account, see usesBasecamp3
+	product string
 }
 
 type BasecampParams struct {
 	AccountID int `json:"account_id"`
+	// Clients are imported from client companies of Basecamp 3
+	// and 4 projects with "companies"
+	Clients string `json:"clients,omitempty"`
 }
 
 func (s *BasecampService) Name() string {
@@ -45,6 +51,9 @@ func (s *BasecampService) setParams(b []byte) error {
 	if s.BasecampParams == nil || s.AccountID == 0 {
 		return errors.New("account_id must be present")
 	}
+	if s.BasecampParams.Clients != "" && s.BasecampParams.Clients != basecampCompaniesAsClients {
+		return fmt.Errorf("clients must be %q", basecampCompaniesAsClients)
+	}
 	return nil
 }
 
@@ -63,16 +72,35 @@ func (s *BasecampService) client() *basecamp.Client {
 	}
 }
 
-// Map basecamp accounts to local accounts
+// basecampStatusError matches errors of go-basecamp which end with the status code
+var basecampStatusError = regexp.MustCompile(`failed with status code (\d{3})$`)
+
+// basecamp2APIError converts status errors of go-basecamp, so they are retried
+func basecamp2APIError(err error) error {
+	if err == nil {
+		return nil
+	}
+	match := basecampStatusError.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	statusCode, _ := strconv.Atoi(match[1])
+	return &ServiceStatusError{StatusCode: statusCode, Status: err.Error()}
+}
+
+// Map Basecamp 2, 3 and 4 accounts to local accounts
 func (s *BasecampService) Accounts() ([]*Account, error) {
-	foreignObjects, err := s.client().GetAccounts()
+	authorization, err := s.authorization()
 	if err != nil {
 		return nil, err
 	}
 	var accounts []*Account
-	for _, object := range foreignObjects {
+	for _, object := range authorization.Accounts {
+		if object.Product != basecamp2Product && object.Product != basecamp3Product {
+			continue
+		}
 		account := Account{
-			ID:   int64(object.Id),
+			ID:   int64(object.ID),
 			Name: object.Name,
 		}
 		accounts = append(accounts, &account)
@@ -82,7 +110,18 @@ func (s *BasecampService) Accounts() ([]*Account, error) {
 
 // Map basecamp people to local users
 func (s *BasecampService) Users() ([]*User, error) {
-	foreignObjects, err := s.client().GetPeople(s.AccountID)
+	basecamp3, err := s.usesBasecamp3()
+	if err != nil {
+		return nil, err
+	}
+	if basecamp3 {
+		return s.usersFromBasecamp3()
+	}
+	var foreignObjects []*basecamp.Person
+	err = withServiceRetry(s, true, func() (err error) {
+		foreignObjects, err = s.client().GetPeople(s.AccountID)
+		return basecamp2APIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -98,9 +137,36 @@ func (s *BasecampService) Users() ([]*User, error) {
 	return users, nil
 }
 
+// Map client companies of basecamp projects to clients when enabled,
+// Basecamp 2 projects have no client companies
+func (s *BasecampService) Clients() ([]*Client, error) {
+	if s.BasecampParams == nil || s.BasecampParams.Clients != basecampCompaniesAsClients {
+		return s.emptyService.Clients()
+	}
+	basecamp3, err := s.usesBasecamp3()
+	if err != nil {
+		return nil, err
+	}
+	if !basecamp3 {
+		return s.emptyService.Clients()
+	}
+	return s.clientsFromBasecamp3()
+}
+
 // Map basecamp projects to projects
 func (s *BasecampService) Projects() ([]*Project, error) {
-	foreignObjects, err := s.client().GetProjects(s.AccountID)
+	basecamp3, err := s.usesBasecamp3()
+	if err != nil {
+		return nil, err
+	}
+	if basecamp3 {
+		return s.projectsFromBasecamp3()
+	}
+	var foreignObjects []*basecamp.Project
+	err = withServiceRetry(s, true, func() (err error) {
+		foreignObjects, err = s.client().GetProjects(s.AccountID)
+		return basecamp2APIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -119,10 +185,34 @@ func (s *BasecampService) Projects() ([]*Project, error) {
 	return projects, nil
 }
 
+// Map people of basecamp projects to project members,
+// Basecamp 2 projects are not supported
+func (s *BasecampService) ProjectMembers() (map[string][]string, error) {
+	basecamp3, err := s.usesBasecamp3()
+	if err != nil {
+		return nil, err
+	}
+	if !basecamp3 {
+		return s.emptyService.ProjectMembers()
+	}
+	return s.projectMembersFromBasecamp3()
+}
+
 // Map basecamp todos to tasks
 func (s *BasecampService) Tasks() ([]*Task, error) {
+	basecamp3, err := s.usesBasecamp3()
+	if err != nil {
+		return nil, err
+	}
+	if basecamp3 {
+		return s.tasksFromBasecamp3()
+	}
 	c := s.client()
-	foreignObjects, err := c.GetAllTodoLists(s.AccountID)
+	var foreignObjects []*basecamp.TodoList
+	err = withServiceRetry(s, true, func() (err error) {
+		foreignObjects, err = c.GetAllTodoLists(s.AccountID)
+		return basecamp2APIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -134,7 +224,11 @@ func (s *BasecampService) Tasks() ([]*Task, error) {
 		//if object.UpdatedAt.Before(*s.modifiedSince) {
 		//	continue
 		//}
-		todoList, err := c.GetTodoList(s.AccountID, object.ProjectId, object.Id)
+		var todoList *basecamp.TodoList
+		err := withServiceRetry(s, true, func() (err error) {
+			todoList, err = c.GetTodoList(s.AccountID, object.ProjectId, object.Id)
+			return basecamp2APIError(err)
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -171,7 +265,18 @@ func (s *BasecampService) Tasks() ([]*Task, error) {
 
 // Map basecamp todolists to tasks
 func (s *BasecampService) TodoLists() ([]*Task, error) {
-	foreignObjects, err := s.client().GetAllTodoLists(s.AccountID)
+	basecamp3, err := s.usesBasecamp3()
+	if err != nil {
+		return nil, err
+	}
+	if basecamp3 {
+		return s.todoListsFromBasecamp3()
+	}
+	var foreignObjects []*basecamp.TodoList
+	err = withServiceRetry(s, true, func() (err error) {
+		foreignObjects, err = s.client().GetAllTodoLists(s.AccountID)
+		return basecamp2APIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+// Basecamp 3 and 4 share the API and are listed as "bc3" product
+// in launchpad authorization, Basecamp 2 accounts are "bcx"
+const (
+	basecamp2Product = "bcx"
+	basecamp3Product = "bc3"
+)
+
+// basecampCompaniesAsClients is clients option value of Basecamp pipes
+const basecampCompaniesAsClients = "companies"
+
+// basecampLaunchpadURL and basecamp3APIURL are replaced in tests
+var (
+	basecampLaunchpadURL = "https://launchpad.37signals.com/authorization.json"
+	basecamp3APIURL      = "https://3.basecampapi.com"
+)
+
+var basecampNextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+type (
+	basecampAuthorization struct {
+		Accounts []basecampAccount `json:"accounts"`
+	}
+
+	basecampAccount struct {
+		ID      int    `json:"id"`
+		Name    string `json:"name"`
+		Product string `json:"product"`
+	}
+
+	basecamp3Person struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email_address"`
+	}
+
+	basecamp3Company struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	basecamp3Project struct {
+		ID            int               `json:"id"`
+		Name          string            `json:"name"`
+		Status        string            `json:"status"`
+		UpdatedAt     time.Time         `json:"updated_at"`
+		ClientCompany *basecamp3Company `json:"client_company"`
+		Dock          []struct {
+			Name    string `json:"name"`
+			URL     string `json:"url"`
+			Enabled bool   `json:"enabled"`
+		} `json:"dock"`
+	}
+
+	basecamp3Todoset struct {
+		TodolistsURL string `json:"todolists_url"`
+	}
+
+	basecamp3Todolist struct {
+		ID        int       `json:"id"`
+		Name      string    `json:"name"`
+		Completed bool      `json:"completed"`
+		UpdatedAt time.Time `json:"updated_at"`
+		TodosURL  string    `json:"todos_url"`
+	}
+
+	basecamp3Todo struct {
+		ID        int               `json:"id"`
+		Content   string            `json:"content"`
+		Completed bool              `json:"completed"`
+		Assignees []basecamp3Person `json:"assignees"`
+	}
+)
+
+// authorization returns accounts of the token from launchpad
+func (s *BasecampService) authorization() (*basecampAuthorization, error) {
+	var authorization basecampAuthorization
+	if _, err := s.get3(basecampLaunchpadURL, &authorization); err != nil {
+		return nil, err
+	}
+	return &authorization, nil
+}
+
+// usesBasecamp3 tells whether the selected account uses Basecamp 3 or 4 API,
+// accounts are looked up once as pipes of Basecamp 2 accounts don't store the product
+func (s *BasecampService) usesBasecamp3() (bool, error) {
+	if s.product == "" {
+		authorization, err := s.authorization()
+		if err != nil {
+			return false, err
+		}
+		s.product = basecamp2Product
+		for _, account := range authorization.Accounts {
+			if account.ID == s.AccountID {
+				s.product = account.Product
+			}
+		}
+	}
+	return s.product == basecamp3Product, nil
+}
+
+// get3 requests url and decodes the response into v,
+// it returns URL of the next page from Link header
+func (s *BasecampService) get3(url string, v interface{}) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "Toggl Pipes (support@toggl.com)")
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	resp, err := t.Client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s failed with status code %d", url, resp.StatusCode)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return "", err
+	}
+	if match := basecampNextLink.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+		return match[1], nil
+	}
+	return "", nil
+}
+
+// getPages3 requests all pages starting from url, handle decodes one page
+func (s *BasecampService) getPages3(url string, handle func(b json.RawMessage) error) error {
+	for url != "" {
+		var page json.RawMessage
+		var err error
+		if url, err = s.get3(url, &page); err != nil {
+			return err
+		}
+		if err := handle(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BasecampService) url3(path string) string {
+	return fmt.Sprintf("%s/%d/%s", basecamp3APIURL, s.AccountID, path)
+}
+
+func (s *BasecampService) usersFromBasecamp3() ([]*User, error) {
+	var users []*User
+	err := s.getPages3(s.url3("people.json"), func(b json.RawMessage) error {
+		var people []basecamp3Person
+		if err := json.Unmarshal(b, &people); err != nil {
+			return err
+		}
+		for _, object := range people {
+			users = append(users, &User{
+				ForeignID: strconv.Itoa(object.ID),
+				Name:      object.Name,
+				Email:     object.Email,
+			})
+		}
+		return nil
+	})
+	return users, err
+}
+
+// projects3 returns active and archived projects
+func (s *BasecampService) projects3() ([]basecamp3Project, error) {
+	var projects []basecamp3Project
+	for _, path := range []string{"projects.json", "projects.json?status=archived"} {
+		err := s.getPages3(s.url3(path), func(b json.RawMessage) error {
+			var page []basecamp3Project
+			if err := json.Unmarshal(b, &page); err != nil {
+				return err
+			}
+			projects = append(projects, page...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return projects, nil
+}
+
+// todolists3 returns todolists of the project's todoset,
+// projects with todos disabled have none
+func (s *BasecampService) todolists3(project basecamp3Project) ([]basecamp3Todolist, error) {
+	var todolists []basecamp3Todolist
+	for _, tool := range project.Dock {
+		if tool.Name != "todoset" || !tool.Enabled {
+			continue
+		}
+		var todoset basecamp3Todoset
+		if _, err := s.get3(tool.URL, &todoset); err != nil {
+			return nil, err
+		}
+		err := s.getPages3(todoset.TodolistsURL, func(b json.RawMessage) error {
+			var page []basecamp3Todolist
+			if err := json.Unmarshal(b, &page); err != nil {
+				return err
+			}
+			todolists = append(todolists, page...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return todolists, nil
+}
+
+// templates3 returns project templates of the account
+func (s *BasecampService) templates3() ([]basecamp3Project, error) {
+	var templates []basecamp3Project
+	err := s.getPages3(s.url3("templates.json"), func(b json.RawMessage) error {
+		var page []basecamp3Project
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		templates = append(templates, page...)
+		return nil
+	})
+	return templates, err
+}
+
+func (s *BasecampService) todos3(todolist basecamp3Todolist) ([]basecamp3Todo, error) {
+	var todos []basecamp3Todo
+	for _, url := range []string{todolist.TodosURL, todolist.TodosURL + "?completed=true"} {
+		err := s.getPages3(url, func(b json.RawMessage) error {
+			var page []basecamp3Todo
+			if err := json.Unmarshal(b, &page); err != nil {
+				return err
+			}
+			todos = append(todos, page...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return todos, nil
+}
+
+func (s *BasecampService) modifiedBefore(updatedAt time.Time) bool {
+	return s.modifiedSince != nil && updatedAt.Before(*s.modifiedSince)
+}
+
+func (s *BasecampService) clientsFromBasecamp3() ([]*Client, error) {
+	projects, err := s.projects3()
+	if err != nil {
+		return nil, err
+	}
+	var clients []*Client
+	seen := map[int]bool{}
+	for _, project := range projects {
+		if project.ClientCompany == nil || seen[project.ClientCompany.ID] {
+			continue
+		}
+		seen[project.ClientCompany.ID] = true
+		clients = append(clients, &Client{
+			ForeignID: strconv.Itoa(project.ClientCompany.ID),
+			Name:      project.ClientCompany.Name,
+		})
+	}
+	return clients, nil
+}
+
+func (s *BasecampService) projectsFromBasecamp3() ([]*Project, error) {
+	foreignObjects, err := s.projects3()
+	if err != nil {
+		return nil, err
+	}
+	var projects []*Project
+	for _, object := range foreignObjects {
+		if s.modifiedBefore(object.UpdatedAt) {
+			continue
+		}
+		project := Project{
+			Active:    object.Status == "active",
+			ForeignID: strconv.Itoa(object.ID),
+			Name:      object.Name,
+		}
+		if object.ClientCompany != nil && s.BasecampParams.Clients == basecampCompaniesAsClients {
+			project.foreignClientID = strconv.Itoa(object.ClientCompany.ID)
+		}
+		projects = append(projects, &project)
+	}
+	templates, err := s.templates3()
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range templates {
+		if s.modifiedBefore(object.UpdatedAt) {
+			continue
+		}
+		projects = append(projects, &Project{
+			Active:    object.Status == "active",
+			ForeignID: strconv.Itoa(object.ID),
+			Name:      object.Name,
+			Template:  true,
+		})
+	}
+	return projects, nil
+}
+
+func (s *BasecampService) projectMembersFromBasecamp3() (map[string][]string, error) {
+	projects, err := s.projects3()
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string][]string)
+	for _, project := range projects {
+		projectID := strconv.Itoa(project.ID)
+		err := s.getPages3(s.url3(fmt.Sprintf("projects/%d/people.json", project.ID)), func(b json.RawMessage) error {
+			var people []basecamp3Person
+			if err := json.Unmarshal(b, &people); err != nil {
+				return err
+			}
+			for _, person := range people {
+				members[projectID] = append(members[projectID], strconv.Itoa(person.ID))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return members, nil
+}
+
+func (s *BasecampService) todoListsFromBasecamp3() ([]*Task, error) {
+	projects, err := s.projects3()
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	for _, project := range projects {
+		todolists, err := s.todolists3(project)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range todolists {
+			if s.modifiedBefore(object.UpdatedAt) {
+				continue
+			}
+			tasks = append(tasks, &Task{
+				ForeignID:        strconv.Itoa(object.ID),
+				Name:             object.Name,
+				Active:           !object.Completed,
+				foreignProjectID: strconv.Itoa(project.ID),
+			})
+		}
+	}
+	return tasks, nil
+}
+
+func (s *BasecampService) tasksFromBasecamp3() ([]*Task, error) {
+	projects, err := s.projects3()
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	for _, project := range projects {
+		todolists, err := s.todolists3(project)
+		if err != nil {
+			return nil, err
+		}
+		for _, todolist := range todolists {
+			todos, err := s.todos3(todolist)
+			if err != nil {
+				return nil, err
+			}
+			for _, todo := range todos {
+				task := &Task{
+					ForeignID:        strconv.Itoa(todo.ID),
+					Name:             fmt.Sprintf("[%s] %s", todolist.Name, todo.Content),
+					Active:           !todo.Completed,
+					foreignProjectID: strconv.Itoa(project.ID),
+				}
+				// Toggl tasks have one user, the first assignee is used
+				if len(todo.Assignees) > 0 {
+					task.foreignUserID = strconv.Itoa(todo.Assignees[0].ID)
+				}
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	return tasks, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newBasecamp3TestServer(t *testing.T) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.RequestURI() {
+		case "/authorization.json":
+			w.Write([]byte(`{"accounts":[
+				{"id":100,"name":"Classic","product":"bcx"},
+				{"id":200,"name":"Studio","product":"bc3"},
+				{"id":300,"name":"Campfire","product":"campfire"}
+			]}`))
+		case "/200/projects.json":
+			w.Write([]byte(`[{"id":1,"name":"Website","status":"active","updated_at":"2026-10-01T10:00:00Z","client_company":{"id":8,"name":"ACME"},"dock":[
+				{"name":"message_board","url":"` + ts.URL + `/200/buckets/1/message_boards/9.json","enabled":true},
+				{"name":"todoset","url":"` + ts.URL + `/200/buckets/1/todosets/2.json","enabled":true}
+			]}]`))
+		case "/200/projects.json?status=archived":
+			w.Write([]byte(`[{"id":5,"name":"Old site","status":"archived","updated_at":"2026-01-01T10:00:00Z","dock":[]}]`))
+		case "/200/templates.json":
+			w.Write([]byte(`[{"id":7,"name":"Client project","status":"active","updated_at":"2026-09-01T10:00:00Z"}]`))
+		case "/200/buckets/1/todosets/2.json":
+			w.Write([]byte(`{"todolists_url":"` + ts.URL + `/200/buckets/1/todosets/2/todolists.json"}`))
+		case "/200/buckets/1/todosets/2/todolists.json":
+			w.Write([]byte(`[{"id":3,"name":"Launch","completed":false,"updated_at":"2026-10-01T10:00:00Z","todos_url":"` + ts.URL + `/200/buckets/1/todolists/3/todos.json"}]`))
+		case "/200/buckets/1/todolists/3/todos.json":
+			w.Header().Set("Link", `<`+ts.URL+`/200/buckets/1/todolists/3/todos.json?page=2>; rel="next"`)
+			w.Write([]byte(`[{"id":11,"content":"Copy","completed":false}]`))
+		case "/200/buckets/1/todolists/3/todos.json?page=2":
+			w.Write([]byte(`[{"id":12,"content":"Images","completed":false}]`))
+		case "/200/buckets/1/todolists/3/todos.json?completed=true":
+			w.Write([]byte(`[{"id":13,"content":"Domain","completed":true}]`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return ts
+}
+
+func TestBasecamp3Service(t *testing.T) {
+	ts := newBasecamp3TestServer(t)
+	defer ts.Close()
+	defer func(launchpadURL, apiURL string) {
+		basecampLaunchpadURL, basecamp3APIURL = launchpadURL, apiURL
+	}(basecampLaunchpadURL, basecamp3APIURL)
+	basecampLaunchpadURL, basecamp3APIURL = ts.URL+"/authorization.json", ts.URL
+
+	s := getService("basecamp", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := s.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 || accounts[0].ID != 100 || accounts[1].ID != 200 {
+		t.Fatalf("expected Basecamp 2 and 3 accounts, got %+v", accounts)
+	}
+	if err := s.setParams([]byte(`{"account_id":200,"clients":"companies"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if key := s.keyFor(projectsPipeID); key != "basecamp:account:200:projects" {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	projects, err := s.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 3 || !projects[0].Active || projects[1].Active || !projects[2].Template {
+		t.Fatalf("expected archived project to be inactive and template last, got %+v", projects)
+	}
+	if projects[0].foreignClientID != "8" || projects[1].foreignClientID != "" {
+		t.Errorf("expected client company to be linked, got %+v", projects)
+	}
+	clients, err := s.Clients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].ForeignID != "8" || clients[0].Name != "ACME" {
+		t.Errorf("expected client companies as clients, got %+v", clients)
+	}
+
+	todoLists, err := s.TodoLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todoLists) != 1 || todoLists[0].Name != "Launch" || todoLists[0].foreignProjectID != "1" {
+		t.Errorf("unexpected todolists %+v", todoLists)
+	}
+
+	tasks, err := s.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := map[string]bool{}
+	for _, task := range tasks {
+		active[task.Name] = task.Active
+	}
+	want := map[string]bool{"[Launch] Copy": true, "[Launch] Images": true, "[Launch] Domain": false}
+	if len(active) != len(want) {
+		t.Fatalf("expected todos of all pages, got %v", active)
+	}
+	for name, isActive := range want {
+		if active[name] != isActive {
+			t.Errorf("expected todo %s active=%v", name, isActive)
+		}
+	}
+}
+
+func TestBasecampConnectionKeys(t *testing.T) {
+	s := getService("basecamp", 58)
+	if err := s.setParams([]byte(`{"account_id":9999999999}`)); err != nil {
+		t.Fatal(err)
+	}
+	testConnectionKeys(t, s)
+}
+
+func TestBasecamp2APIError(t *testing.T) {
+	err := basecamp2APIError(errors.New("https://basecamp.com/1/api/v1/people.json failed with status code 503"))
+	var statusErr *ServiceStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 503 {
+		t.Errorf("expected status error with 503, got %#v", err)
+	}
+	if err := basecamp2APIError(errors.New("connection reset")); errors.As(err, &statusErr) {
+		t.Errorf("expected other errors to be kept, got %#v", err)
+	}
+}
@@ -1,44 +1,22 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
+	"sort"
 	"strconv"
 	"strings"
 )
 
-const (
-	selectConnectionSQL = `SELECT key, data
-    FROM connections WHERE
-    workspace_id = $1
-    AND key = $2
-    LIMIT 1
-  `
-	insertConnectionSQL = `
-    WITH existing_connection AS (
-      UPDATE connections SET data = $3
-      WHERE workspace_id = $1 AND key = $2
-      RETURNING key
-    ),
-    inserted_connection AS (
-      INSERT INTO connections(workspace_id, key, data)
-      SELECT $1, $2, $3
-      WHERE NOT EXISTS (SELECT 1 FROM existing_connection)
-      RETURNING key
-    )
-    SELECT * FROM inserted_connection
-    UNION
-    SELECT * FROM existing_connection
-  `
-)
-
 type (
+	// Connection maps foreign IDs to Toggl IDs of one pipe.
+	// Callers modify Data directly, save writes only the mappings
+	// that changed since the connection was loaded.
 	Connection struct {
 		workspaceID int
 		serviceID   string
 		pipeID      string
 		key         string
 		Data        map[string]int
+		saved       map[string]int
 	}
 	ReversedConnection struct {
 		Data map[int]string
@@ -50,6 +28,7 @@ func NewConnection(s Service, pipeID string) *Connection {
 		workspaceID: s.WorkspaceID(),
 		key:         s.keyFor(pipeID),
 		Data:        make(map[string]int),
+		saved:       make(map[string]int),
 	}
 }
 
@@ -67,52 +46,215 @@ func (c *ReversedConnection) getKeys() []int {
 }
 
 func loadConnection(s Service, pipeID string) (*Connection, error) {
-	rows, err := db.Query(selectConnectionSQL, s.WorkspaceID(), s.keyFor(pipeID))
+	connection := NewConnection(s, pipeID)
+	data, err := store.LoadConnection(connection.workspaceID, connection.key)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	connection := NewConnection(s, pipeID)
-	if rows.Next() {
-		if err := connection.load(rows); err != nil {
-			return nil, err
-		}
+	for foreignID, togglID := range data {
+		connection.Data[foreignID] = togglID
+		connection.saved[foreignID] = togglID
 	}
 	return connection, nil
 }
 
 func loadConnectionRev(s Service, pipeID string) (*ReversedConnection, error) {
-	connection, err := loadConnection(s, pipeID)
+	data, err := store.LoadReversedConnection(s.WorkspaceID(), s.keyFor(pipeID))
 	if err != nil {
 		return nil, err
 	}
-	reversed := &ReversedConnection{make(map[int]string)}
-	for key, value := range connection.Data {
-		reversed.Data[value] = key
-	}
-	return reversed, nil
+	return &ReversedConnection{data}, nil
 }
 
 func (c *Connection) save() error {
-	b, err := json.Marshal(c)
+	upserts := make(map[string]int)
+	for foreignID, togglID := range c.Data {
+		if saved, exists := c.saved[foreignID]; !exists || saved != togglID {
+			upserts[foreignID] = togglID
+		}
+	}
+	var deletes []string
+	for foreignID := range c.saved {
+		if _, exists := c.Data[foreignID]; !exists {
+			deletes = append(deletes, foreignID)
+		}
+	}
+	if len(upserts) == 0 && len(deletes) == 0 {
+		return nil
+	}
+	if err := store.SaveConnection(c.workspaceID, c.key, upserts, deletes); err != nil {
+		return err
+	}
+	for foreignID, togglID := range upserts {
+		c.saved[foreignID] = togglID
+	}
+	for _, foreignID := range deletes {
+		delete(c.saved, foreignID)
+	}
+	return nil
+}
+
+// ConnectionItem is one mapping of a pipe as shown to users
+type ConnectionItem struct {
+	ForeignID   string `json:"foreign_id"`
+	ForeignName string `json:"foreign_name,omitempty"`
+	TogglID     int    `json:"toggl_id,omitempty"`
+	TogglName   string `json:"toggl_name,omitempty"`
+	NeverSync   bool   `json:"never_sync,omitempty"`
+}
+
+// connectionPipeID returns the pipe whose connection keeps the mappings of pipeID
+func connectionPipeID(pipeID string) string {
+	if pipeID == "todos" {
+		return tasksPipeId
+	}
+	return pipeID
+}
+
+// togglObjectType returns the type of Toggl objects pipeID maps to
+func togglObjectType(pipeID string) string {
+	switch pipeID {
+	case usersPipeID:
+		return "users"
+	case clientsPipeID:
+		return "clients"
+	case projectsPipeID:
+		return "projects"
+	case tagsPipeID:
+		return "tags"
+	default:
+		return "tasks"
+	}
+}
+
+// neverSyncConnectionID uses a short suffix so keys of todo lists
+// with long account IDs still fit the key columns
+func neverSyncConnectionID(pipeID string) string {
+	return connectionPipeID(pipeID) + "_nosync"
+}
+
+// loadNeverSync returns foreign objects of pipeID which must not be synced
+func loadNeverSync(s Service, pipeID string) (*Connection, error) {
+	return loadConnection(s, neverSyncConnectionID(pipeID))
+}
+
+// foreignNames returns names of the foreign objects of pipeID from the latest import
+func foreignNames(s Service, pipeID string) (map[string]string, error) {
+	names := make(map[string]string)
+	switch connectionPipeID(pipeID) {
+	case usersPipeID:
+		response, err := getUsers(s)
+		if err != nil || response == nil {
+			return names, err
+		}
+		for _, user := range append(response.Users, response.Deactivated...) {
+			names[user.ForeignID] = user.Name
+		}
+	case projectsPipeID:
+		response, err := getProjects(s)
+		if err != nil || response == nil {
+			return names, err
+		}
+		for _, project := range append(response.Projects, response.Vanished...) {
+			names[project.ForeignID] = project.Name
+		}
+	case tagsPipeID:
+		response, err := getTags(s)
+		if err != nil || response == nil {
+			return names, err
+		}
+		for _, tag := range response.Tags {
+			names[tag.ForeignID] = tag.Name
+		}
+	default:
+		response, err := getTasks(s, connectionPipeID(pipeID))
+		if err != nil || response == nil {
+			return names, err
+		}
+		for _, task := range append(response.Tasks, response.Vanished...) {
+			names[task.ForeignID] = task.Name
+		}
+	}
+	return names, nil
+}
+
+// listConnections returns mappings and never synced objects of pipeID
+// with names resolved, togglNames comes from Toggl API
+func listConnections(s Service, pipeID string, togglNames map[int]string) ([]ConnectionItem, error) {
+	connection, err := loadConnection(s, connectionPipeID(pipeID))
+	if err != nil {
+		return nil, err
+	}
+	neverSync, err := loadNeverSync(s, pipeID)
+	if err != nil {
+		return nil, err
+	}
+	names, err := foreignNames(s, pipeID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]ConnectionItem, 0, len(connection.Data)+len(neverSync.Data))
+	for foreignID, togglID := range connection.Data {
+		items = append(items, ConnectionItem{
+			ForeignID:   foreignID,
+			ForeignName: names[foreignID],
+			TogglID:     togglID,
+			TogglName:   togglNames[togglID],
+		})
+	}
+	for foreignID := range neverSync.Data {
+		items = append(items, ConnectionItem{
+			ForeignID:   foreignID,
+			ForeignName: names[foreignID],
+			NeverSync:   true,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ForeignID < items[j].ForeignID })
+	return items, nil
+}
+
+// linkManually maps the foreign object to an existing Toggl object,
+// the object is synced again if it was marked as never sync
+func linkManually(s Service, pipeID, foreignID string, togglID int) error {
+	connection, err := loadConnection(s, connectionPipeID(pipeID))
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(insertConnectionSQL, c.workspaceID, c.key, b)
+	connection.Data[foreignID] = togglID
+	if err := connection.save(); err != nil {
+		return err
+	}
+	neverSync, err := loadNeverSync(s, pipeID)
 	if err != nil {
 		return err
 	}
-	return nil
+	delete(neverSync.Data, foreignID)
+	return neverSync.save()
 }
 
-func (c *Connection) load(rows *sql.Rows) error {
-	var b []byte
-	if err := rows.Scan(&c.key, &b); err != nil {
+func unlink(s Service, pipeID, foreignID string) error {
+	connection, err := loadConnection(s, connectionPipeID(pipeID))
+	if err != nil {
 		return err
 	}
-	err := json.Unmarshal(b, c)
+	delete(connection.Data, foreignID)
+	return connection.save()
+}
+
+// setNeverSync excludes the foreign object from syncing, or includes it again.
+// Excluded objects are unlinked so that their Toggl objects are left alone.
+func setNeverSync(s Service, pipeID, foreignID string, never bool) error {
+	neverSync, err := loadNeverSync(s, pipeID)
 	if err != nil {
 		return err
 	}
-	return nil
+	if !never {
+		delete(neverSync.Data, foreignID)
+		return neverSync.save()
+	}
+	neverSync.Data[foreignID] = 0
+	if err := neverSync.save(); err != nil {
+		return err
+	}
+	return unlink(s, pipeID, foreignID)
 }
@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+func TestConnectionSaveKeepsConcurrentChanges(t *testing.T) {
+	s := getService(TestServiceName, 42)
+
+	first, err := loadConnection(s, tasksPipeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadConnection(s, tasksPipeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Data["a"] = 1
+	second.Data["b"] = 2
+	if err := first.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadConnection(s, tasksPipeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Data["a"] != 1 || loaded.Data["b"] != 2 {
+		t.Fatalf("expected both mappings to be saved, got %v", loaded.Data)
+	}
+
+	delete(loaded.Data, "a")
+	if err := loaded.save(); err != nil {
+		t.Fatal(err)
+	}
+	reversed, err := loadConnectionRev(s, tasksPipeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reversed.Data) != 1 || reversed.Data[2] != "b" {
+		t.Fatalf("expected only b to remain, got %v", reversed.Data)
+	}
+}
+
+func TestNeverSyncUnlinksObject(t *testing.T) {
+	s := getService(TestServiceName, 43)
+
+	if err := linkManually(s, projectsPipeID, "p1", 11); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkManually(s, projectsPipeID, "p2", 12); err != nil {
+		t.Fatal(err)
+	}
+	if err := setNeverSync(s, projectsPipeID, "p2", true); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := listConnections(s, projectsPipeID, map[int]string{11: "Toggl p1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %v", items)
+	}
+	if items[0].ForeignID != "p1" || items[0].TogglID != 11 || items[0].TogglName != "Toggl p1" {
+		t.Errorf("unexpected mapping %+v", items[0])
+	}
+	if items[1].ForeignID != "p2" || items[1].TogglID != 0 || !items[1].NeverSync {
+		t.Errorf("expected p2 to be unlinked and never synced, got %+v", items[1])
+	}
+
+	if err := linkManually(s, projectsPipeID, "p2", 12); err != nil {
+		t.Fatal(err)
+	}
+	neverSync, err := loadNeverSync(s, projectsPipeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(neverSync.Data) != 0 {
+		t.Errorf("expected linking to sync p2 again, got %v", neverSync.Data)
+	}
+}
+
+// testConnectionKeys saves connections with the longest keys of the service,
+// they must fit the key columns when tests run against Postgres
+func testConnectionKeys(t *testing.T, s Service) {
+	connectionIDs := []string{
+		importedTimeEntriesPipeID,
+		neverSyncConnectionID(todoPipeId),
+		archivedConnectionID(todoPipeId),
+		archivedConnectionID(projectsPipeID),
+	}
+	for i, connectionID := range connectionIDs {
+		if key := s.keyFor(connectionID); len(key) > maxKeyLength {
+			t.Errorf("expected key %s to have at most %d characters, got %d", key, maxKeyLength, len(key))
+		}
+		connection := NewConnection(s, connectionID)
+		connection.Data["a"] = i + 1
+		if err := connection.save(); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := loadConnection(s, connectionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Data["a"] != i+1 {
+			t.Errorf("expected connection %s to be saved, got %v", connectionID, loaded.Data)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+-- Run after `pipes-api migrate`, tables are owned by pipes_user which runs the migrations.
+GRANT SELECT, UPDATE ON TABLE pipes TO toggl_alerts_user;
@@ -0,0 +1,6 @@
+-- Roles are cluster wide so they are not part of migrations,
+-- run this once before `pipes-api migrate`.
+CREATE ROLE pipes_user WITH LOGIN;
+
+CREATE ROLE toggl_alerts_user;
+ALTER ROLE toggl_alerts_user WITH NOSUPERUSER INHERIT NOCREATEROLE NOCREATEDB LOGIN NOREPLICATION CONNECTION LIMIT 10 PASSWORD 'md55a60e58de3bb5c79bcd17e441b45fd37' VALID UNTIL 'infinity';
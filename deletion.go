@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Deletion policies decide what happens to a Toggl object when its
+// connected foreign object is no longer returned by the service.
+const (
+	// deletionPolicyIgnore leaves the Toggl object as it is, this is the default
+	deletionPolicyIgnore = "ignore"
+	// deletionPolicyArchive makes the Toggl object inactive and keeps the link,
+	// so the object is activated again if it reappears in the service
+	deletionPolicyArchive = "archive"
+	// deletionPolicyInactive makes the Toggl object inactive and drops the link,
+	// so an object reappearing in the service becomes a new Toggl object
+	deletionPolicyInactive = "inactive"
+	// deletionPolicyDelete deletes the Toggl object and drops the link
+	deletionPolicyDelete = "delete"
+)
+
+func isValidDeletionPolicy(policy string) bool {
+	switch policy {
+	case "", deletionPolicyIgnore, deletionPolicyArchive, deletionPolicyInactive, deletionPolicyDelete:
+		return true
+	}
+	return false
+}
+
+// detectsDeletionsOf tells whether vanished objects of pipeID are looked for,
+// only objects of the pipe itself are, not the projects fetched for a tasks pipe.
+func (p *Pipe) detectsDeletionsOf(pipeID string) bool {
+	if p.DeletionPolicy == "" || p.DeletionPolicy == deletionPolicyIgnore {
+		return false
+	}
+	return p.ID == pipeID || (p.ID == "todos" && pipeID == tasksPipeId)
+}
+
+// fetchSince returns the time objects of pipeID are fetched from,
+// detecting deletions needs all objects, not only the recently modified ones
+func (p *Pipe) fetchSince(pipeID string) *time.Time {
+	if p.detectsDeletionsOf(pipeID) {
+		return &time.Time{}
+	}
+	return p.lastSync
+}
+
+func archivedConnectionID(pipeID string) string {
+	return pipeID + "_archived"
+}
+
+// vanishedForeignIDs returns foreign IDs which are connected but were not fetched,
+// objects already archived in Toggl are left out
+func vanishedForeignIDs(s Service, pipeID string, connection *Connection, fetched map[string]bool) ([]string, error) {
+	archived, err := loadConnection(s, archivedConnectionID(pipeID))
+	if err != nil {
+		return nil, err
+	}
+	var vanished []string
+	for foreignID := range connection.Data {
+		if _, isArchived := archived.Data[foreignID]; !fetched[foreignID] && !isArchived {
+			vanished = append(vanished, foreignID)
+		}
+	}
+	return vanished, nil
+}
+
+func vanishedProjects(s Service, projects []*Project, connection *Connection) ([]*Project, error) {
+	fetched := make(map[string]bool, len(projects))
+	for _, project := range projects {
+		fetched[project.ForeignID] = true
+	}
+	foreignIDs, err := vanishedForeignIDs(s, projectsPipeID, connection, fetched)
+	if err != nil || len(foreignIDs) == 0 {
+		return nil, err
+	}
+	previous := make(map[string]*Project)
+	if previousResponse, err := getProjects(s); err == nil && previousResponse != nil {
+		for _, project := range append(previousResponse.Projects, previousResponse.Vanished...) {
+			previous[project.ForeignID] = project
+		}
+	}
+	vanished := make([]*Project, 0, len(foreignIDs))
+	for _, foreignID := range foreignIDs {
+		project := &Project{ForeignID: foreignID}
+		if p, exists := previous[foreignID]; exists {
+			project = p
+		}
+		project.ID = connection.Data[foreignID]
+		project.Active = false
+		vanished = append(vanished, project)
+	}
+	return vanished, nil
+}
+
+func vanishedTasks(s Service, pipeID string, tasks []*Task, connection *Connection) ([]*Task, error) {
+	fetched := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		fetched[task.ForeignID] = true
+	}
+	foreignIDs, err := vanishedForeignIDs(s, pipeID, connection, fetched)
+	if err != nil || len(foreignIDs) == 0 {
+		return nil, err
+	}
+	previous := make(map[string]*Task)
+	if previousResponse, err := getTasks(s, pipeID); err == nil && previousResponse != nil {
+		for _, task := range append(previousResponse.Tasks, previousResponse.Vanished...) {
+			previous[task.ForeignID] = task
+		}
+	}
+	vanished := make([]*Task, 0, len(foreignIDs))
+	for _, foreignID := range foreignIDs {
+		task := &Task{ForeignID: foreignID}
+		if t, exists := previous[foreignID]; exists {
+			task = t
+		}
+		task.ID = connection.Data[foreignID]
+		task.Active = false
+		vanished = append(vanished, task)
+	}
+	return vanished, nil
+}
+
+// vanishedLink is a Toggl object whose foreign object has vanished,
+// action is the deletion policy that was applied to it
+type vanishedLink struct {
+	foreignID string
+	togglID   int
+	name      string
+	action    string
+}
+
+// settleDeletions updates links of vanished and reappeared objects
+// after the policy has been applied in Toggl and returns notifications
+func settleDeletions(s Service, pipeID, objectType string, connection *Connection, vanished []vanishedLink, fetched []string) ([]string, error) {
+	archived, err := loadConnection(s, archivedConnectionID(pipeID))
+	if err != nil {
+		return nil, err
+	}
+	for _, foreignID := range fetched {
+		delete(archived.Data, foreignID)
+	}
+
+	var notifications []string
+	serviceName := strings.Title(s.Name())
+	for _, link := range vanished {
+		switch link.action {
+		case deletionPolicyArchive:
+			if _, linked := connection.Data[link.foreignID]; !linked {
+				continue
+			}
+			archived.Data[link.foreignID] = link.togglID
+			notifications = append(notifications, fmt.Sprintf("%s '%s' was removed from %s and has been archived", objectType, link.name, serviceName))
+		case deletionPolicyInactive:
+			delete(connection.Data, link.foreignID)
+			notifications = append(notifications, fmt.Sprintf("%s '%s' was removed from %s and has been marked inactive", objectType, link.name, serviceName))
+		case deletionPolicyDelete:
+			delete(connection.Data, link.foreignID)
+			notifications = append(notifications, fmt.Sprintf("%s '%s' was removed from %s and has been deleted", objectType, link.name, serviceName))
+		}
+	}
+	if err := archived.save(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// applyProjectDeletions deletes vanished projects in Toggl when the policy says so
+// and returns the projects to post, vanished ones are posted as inactive
+func applyProjectDeletions(p *Pipe, response *ProjectsResponse) ([]*Project, []vanishedLink, error) {
+	projects := response.Projects
+	links := make([]vanishedLink, 0, len(response.Vanished))
+	for _, project := range response.Vanished {
+		link := vanishedLink{project.ForeignID, project.ID, project.Name, p.DeletionPolicy}
+		switch p.DeletionPolicy {
+		case deletionPolicyArchive, deletionPolicyInactive:
+			projects = append(projects[:len(projects):len(projects)], project)
+		case deletionPolicyDelete:
+			err := togglClient.DeleteProject(p.authorization.WorkspaceToken, p.workspaceID, project.ID)
+			if err != nil && !isTogglNotFound(err) {
+				return nil, nil, err
+			}
+		default:
+			continue
+		}
+		links = append(links, link)
+	}
+	return projects, links, nil
+}
+
+// applyTaskDeletions deletes vanished tasks in Toggl when the policy says so
+// and returns the tasks to post, vanished ones are posted as inactive.
+// Tasks can be deleted only through their project, tasks whose project
+// isn't known are marked inactive instead.
+func applyTaskDeletions(p *Pipe, response *TasksResponse) ([]*Task, []vanishedLink, error) {
+	tasks := response.Tasks
+	links := make([]vanishedLink, 0, len(response.Vanished))
+	for _, task := range response.Vanished {
+		link := vanishedLink{task.ForeignID, task.ID, task.Name, p.DeletionPolicy}
+		switch {
+		case p.DeletionPolicy == deletionPolicyDelete && task.ProjectID > 0:
+			err := togglClient.DeleteTask(p.authorization.WorkspaceToken, p.workspaceID, task.ProjectID, task.ID)
+			if err != nil && !isTogglNotFound(err) {
+				return nil, nil, err
+			}
+		case p.DeletionPolicy == deletionPolicyDelete:
+			link.action = deletionPolicyInactive
+			tasks = append(tasks[:len(tasks):len(tasks)], task)
+		case p.DeletionPolicy == deletionPolicyArchive || p.DeletionPolicy == deletionPolicyInactive:
+			tasks = append(tasks[:len(tasks):len(tasks)], task)
+		default:
+			continue
+		}
+		links = append(links, link)
+	}
+	return tasks, links, nil
+}
+
+func isVanished(links []vanishedLink, foreignID string) bool {
+	for _, link := range links {
+		if link.foreignID == foreignID {
+			return true
+		}
+	}
+	return false
+}
+
+func settleTaskDeletions(s Service, pipeID string, fetchedTasks []*Task, vanished []vanishedLink) ([]string, error) {
+	connection, err := loadConnection(s, pipeID)
+	if err != nil {
+		return nil, err
+	}
+	fetched := make([]string, 0, len(fetchedTasks))
+	for _, task := range fetchedTasks {
+		fetched = append(fetched, task.ForeignID)
+	}
+	notifications, err := settleDeletions(s, pipeID, "Task", connection, vanished, fetched)
+	if err != nil {
+		return nil, err
+	}
+	return notifications, connection.save()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVanishedProjectsAreArchived(t *testing.T) {
+	p := NewPipe(44, TestServiceName, projectsPipeID)
+	p.DeletionPolicy = deletionPolicyArchive
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := NewConnection(s, projectsPipeID)
+	connection.Data["gone"] = 77
+	if err := connection.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fetchProjects(p); err != nil {
+		t.Fatal(err)
+	}
+	response, err := getProjects(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Vanished) != 1 || response.Vanished[0].ID != 77 || response.Vanished[0].Active {
+		t.Fatalf("expected project 77 to vanish, got %+v", response.Vanished)
+	}
+
+	fake := &fakeTogglAPI{responses: map[string][]byte{
+		projectsPipeID: []byte(`{"projects":[{"id":77,"foreign_id":"gone"}]}`),
+	}}
+	defer withFakeTogglAPI(fake)()
+	if err := postProjects(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.PipeStatus.Notifications) != 1 {
+		t.Errorf("expected archival notification, got %v", p.PipeStatus.Notifications)
+	}
+
+	if err := fetchProjects(p); err != nil {
+		t.Fatal(err)
+	}
+	if response, err = getProjects(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Vanished) != 0 {
+		t.Errorf("expected archived project not to vanish again, got %+v", response.Vanished)
+	}
+}
@@ -28,7 +28,7 @@ func postTimeEntries(p *Pipe) error {
 	if projectsCon, err = loadConnectionRev(service, "projects"); err != nil {
 		return err
 	}
-	if entriesCon, err = loadConnection(service, "time_entries"); err != nil {
+	if entriesCon, err = loadConnection(service, timeEntriesConnectionID); err != nil {
 		return err
 	}
 
@@ -37,7 +37,7 @@ func postTimeEntries(p *Pipe) error {
 		p.lastSync = &currentTime
 	}
 
-	timeEntries, err := getTogglTimeEntries(
+	timeEntries, err := togglClient.GetTimeEntries(
 		p.authorization.WorkspaceToken, *p.lastSync,
 		usersCon.getKeys(), projectsCon.getKeys(),
 	)
@@ -2,28 +2,61 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/namsral/flag"
 )
 
 var (
 	port             int
+	metricsAddress   string
 	workdir          string
 	bugsnagAPIKey    string
 	environment      string
+	storageType      string
 	dbConnString     string
+	testStorageType  string
 	testDBConnString string
+	migrateOnStart   bool
+	command          string
+
+	workspaceCacheTTL         time.Duration
+	workspaceCacheNegativeTTL time.Duration
+	workspaceCacheDB          bool
+
+	togglAPITimeout         time.Duration
+	togglAPIRetries         int
+	togglAPIMaxResponseSize int64
+
+	importsKeepLast        int
+	importsMaxAge          time.Duration
+	importsCompactInterval time.Duration
 )
 
 func InitFlags() {
 	fs := flag.NewFlagSetWithEnvPrefix(os.Args[0], "PIPES_API", flag.ExitOnError)
 
 	fs.IntVar(&port, "port", 8100, "port")
+	fs.StringVar(&metricsAddress, "metrics_address", "127.0.0.1:8101", "Internal address serving metrics, empty disables")
 	fs.StringVar(&workdir, "workdir", ".", "Workdir of server")
 	fs.StringVar(&bugsnagAPIKey, "bugsnag_key", "", "Bugsnag API Key")
 	fs.StringVar(&environment, "environment", "development", "Environment")
+	fs.StringVar(&storageType, "storage", "postgres", "Storage backend, postgres or memory")
 	fs.StringVar(&dbConnString, "db_conn_string", "dbname=pipes_development user=pipes_user host=localhost sslmode=disable port=5432", "DB Connection String")
+	fs.BoolVar(&migrateOnStart, "migrate", false, "Apply pending schema migrations on startup")
+	fs.StringVar(&testStorageType, "test_storage", "memory", "Storage backend used by tests, postgres or memory")
 	fs.StringVar(&testDBConnString, "test_db_conn_string", "dbname=pipes_test user=pipes_user host=localhost sslmode=disable port=5432", "test DB Connection String")
 
+	fs.DurationVar(&workspaceCacheTTL, "workspace_cache_ttl", 5*time.Minute, "How long API token to workspace mapping is cached")
+	fs.DurationVar(&workspaceCacheNegativeTTL, "workspace_cache_negative_ttl", time.Minute, "How long invalid API tokens are cached")
+	fs.BoolVar(&workspaceCacheDB, "workspace_cache_db", false, "Back workspace cache with database")
+	fs.DurationVar(&togglAPITimeout, "toggl_api_timeout", 30*time.Second, "Timeout of Toggl API requests")
+	fs.IntVar(&togglAPIRetries, "toggl_api_retries", 2, "How many times failed Toggl API requests are retried")
+	fs.Int64Var(&togglAPIMaxResponseSize, "toggl_api_max_response_size", 50*1000*1000, "Max size of Toggl API response in bytes")
+	fs.IntVar(&importsKeepLast, "imports_keep_last", 5, "How many import snapshots are kept per key, 0 keeps all")
+	fs.DurationVar(&importsMaxAge, "imports_max_age", 30*24*time.Hour, "Import snapshots older than this are deleted, 0 disables")
+	fs.DurationVar(&importsCompactInterval, "imports_compact_interval", time.Hour, "How often old import snapshots are deleted, 0 disables")
+
 	fs.Parse(os.Args[1:])
+	command = fs.Arg(0)
 }
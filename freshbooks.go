@@ -4,17 +4,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
+	"code.google.com/p/goauth2/oauth"
 	"github.com/tambet/oauthplain"
 	"github.com/toggl/go-freshbooks"
 )
 
+// FreshbooksService uses the new Freshbooks API when authorized with OAuth 2.0,
+// authorizations made with OAuth 1.0 keep using Freshbooks Classic API
 type FreshbooksService struct {
 	emptyService
 	workspaceID int
+	*FreshbooksParams
 	accountName string
 	token       oauthplain.Token
+	// oauth2Token is set for the new API
+	oauth2Token *oauth.Token
+	// accountingID identifies the business in accounting API, see accountingAccountID
+	accountingID string
+}
+
+// FreshbooksParams selects a business of the new API, Classic has no params
+type FreshbooksParams struct {
+	AccountID int `json:"account_id"`
 }
 
 func (s *FreshbooksService) Name() string {
@@ -25,15 +39,39 @@ func (s *FreshbooksService) WorkspaceID() int {
 	return s.workspaceID
 }
 
+// keyFor keeps Classic keys without the account,
+// the new API has other IDs and is keyed by business
 func (s *FreshbooksService) keyFor(objectType string) string {
-	return fmt.Sprintf("freshbooks:%s", objectType)
+	if s.FreshbooksParams == nil {
+		return fmt.Sprintf("freshbooks:%s", objectType)
+	}
+	return fmt.Sprintf("freshbooks:account:%d:%s", s.AccountID, objectType)
 }
 
+// setParams accepts missing params as Classic pipes have none,
+// the new API methods require account_id
 func (s *FreshbooksService) setParams(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s.FreshbooksParams != nil && s.AccountID == 0 {
+		s.FreshbooksParams = nil
+	}
 	return nil
 }
 
 func (s *FreshbooksService) setAuthData(b []byte) error {
+	var token oauth.Token
+	if err := json.Unmarshal(b, &token); err != nil {
+		return err
+	}
+	if token.AccessToken != "" {
+		s.oauth2Token = &token
+		return nil
+	}
 	if err := json.Unmarshal(b, &s.token); err != nil {
 		return err
 	}
@@ -41,8 +79,25 @@ func (s *FreshbooksService) setAuthData(b []byte) error {
 	return nil
 }
 
+// Map businesses of the new API to local accounts, Classic has none
 func (s *FreshbooksService) Accounts() ([]*Account, error) {
-	return nil, nil
+	if !s.usesNewAPI() {
+		return nil, nil
+	}
+	return s.accountsFromNewAPI()
+}
+
+// classicAPIError types errors go-freshbooks returns for unexpected
+// HTTP statuses, the library reports them as the bare status line
+func classicAPIError(err error) error {
+	if err == nil {
+		return nil
+	}
+	statusCode, convErr := strconv.Atoi(strings.SplitN(err.Error(), " ", 2)[0])
+	if convErr != nil || statusCode < 100 || statusCode > 599 {
+		return err
+	}
+	return &ServiceStatusError{StatusCode: statusCode, Status: err.Error()}
 }
 
 func (s *FreshbooksService) Api() *freshbooks.Api {
@@ -50,7 +105,14 @@ func (s *FreshbooksService) Api() *freshbooks.Api {
 }
 
 func (s *FreshbooksService) Users() ([]*User, error) {
-	foreignObjects, err := s.Api().Users()
+	if s.usesNewAPI() {
+		return s.usersFromNewAPI()
+	}
+	var foreignObjects []freshbooks.User
+	err := withServiceRetry(s, true, func() (err error) {
+		foreignObjects, err = s.Api().Users()
+		return classicAPIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +129,14 @@ func (s *FreshbooksService) Users() ([]*User, error) {
 }
 
 func (s *FreshbooksService) Clients() ([]*Client, error) {
-	foreignObjects, err := s.Api().Clients()
+	if s.usesNewAPI() {
+		return s.clientsFromNewAPI()
+	}
+	var foreignObjects []freshbooks.Client
+	err := withServiceRetry(s, true, func() (err error) {
+		foreignObjects, err = s.Api().Clients()
+		return classicAPIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +152,14 @@ func (s *FreshbooksService) Clients() ([]*Client, error) {
 }
 
 func (s *FreshbooksService) Projects() ([]*Project, error) {
-	foreignObjects, err := s.Api().Projects()
+	if s.usesNewAPI() {
+		return s.projectsFromNewAPI()
+	}
+	var foreignObjects []freshbooks.Project
+	err := withServiceRetry(s, true, func() (err error) {
+		foreignObjects, err = s.Api().Projects()
+		return classicAPIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -102,11 +178,22 @@ func (s *FreshbooksService) Projects() ([]*Project, error) {
 }
 
 func (s *FreshbooksService) Tasks() ([]*Task, error) {
-	foreignProjects, err := s.Api().Projects()
+	if s.usesNewAPI() {
+		return s.tasksFromNewAPI()
+	}
+	var foreignProjects []freshbooks.Project
+	err := withServiceRetry(s, true, func() (err error) {
+		foreignProjects, err = s.Api().Projects()
+		return classicAPIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
-	foreignTasks, err := s.Api().Tasks()
+	var foreignTasks []freshbooks.Task
+	err = withServiceRetry(s, true, func() (err error) {
+		foreignTasks, err = s.Api().Tasks()
+		return classicAPIError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -132,6 +219,9 @@ func (s *FreshbooksService) Tasks() ([]*Task, error) {
 }
 
 func (s *FreshbooksService) ExportTimeEntry(t *TimeEntry) (int, error) {
+	if s.usesNewAPI() {
+		return s.exportTimeEntryToNewAPI(t)
+	}
 	start, err := time.Parse(time.RFC3339, t.Start)
 	if err != nil {
 		return 0, err
@@ -148,5 +238,10 @@ func (s *FreshbooksService) ExportTimeEntry(t *TimeEntry) (int, error) {
 	if entry.TaskId == 0 {
 		return 0, fmt.Errorf("task not provided for time entry '%s'", entry.Notes)
 	}
-	return s.Api().SaveTimeEntry(entry)
+	var entryID int
+	err = withServiceRetry(s, false, func() (err error) {
+		entryID, err = s.Api().SaveTimeEntry(entry)
+		return classicAPIError(err)
+	})
+	return entryID, err
 }
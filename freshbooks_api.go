@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+// freshbooksAPIURL is replaced in tests
+var freshbooksAPIURL = "https://api.freshbooks.com"
+
+const freshbooksPerPage = 100
+
+// freshbooksMigratedObjects are relinked when a workspace moves from
+// Freshbooks Classic to the new API, projects before their tasks
+var freshbooksMigratedObjects = []string{usersPipeID, clientsPipeID, projectsPipeID, tasksPipeId}
+
+// freshbooksMigrationID keeps object types of a business which were migrated
+const freshbooksMigrationID = "classic_migration"
+
+type (
+	freshbooksIdentity struct {
+		BusinessMemberships []struct {
+			Business freshbooksBusiness `json:"business"`
+		} `json:"business_memberships"`
+	}
+
+	freshbooksBusiness struct {
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+		AccountID string `json:"account_id"`
+	}
+
+	freshbooksPages struct {
+		Page  int `json:"page"`
+		Pages int `json:"pages"`
+	}
+
+	freshbooksTeamMember struct {
+		IdentityID int    `json:"identity_id"`
+		FirstName  string `json:"first_name"`
+		LastName   string `json:"last_name"`
+		Email      string `json:"email"`
+		Active     bool   `json:"active"`
+	}
+
+	freshbooksClient struct {
+		ID           int    `json:"id"`
+		Organization string `json:"organization"`
+		FirstName    string `json:"fname"`
+		LastName     string `json:"lname"`
+		VisState     int    `json:"vis_state"`
+	}
+
+	freshbooksProject struct {
+		ID       int    `json:"id"`
+		Title    string `json:"title"`
+		Active   bool   `json:"active"`
+		Complete bool   `json:"complete"`
+		Billable bool   `json:"billable"`
+		ClientID int    `json:"client_id"`
+		DueDate  string `json:"due_date"`
+		// Budget is in hours, Rate is the hourly rate of the project
+		Budget   int         `json:"budget"`
+		Rate     json.Number `json:"rate"`
+		Services []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"services"`
+	}
+
+	freshbooksTimeEntry struct {
+		ID         int    `json:"id,omitempty"`
+		IsLogged   bool   `json:"is_logged"`
+		Duration   int    `json:"duration"`
+		Note       string `json:"note"`
+		StartedAt  string `json:"started_at"`
+		ProjectID  int    `json:"project_id"`
+		ServiceID  int    `json:"service_id"`
+		IdentityID int    `json:"identity_id,omitempty"`
+	}
+)
+
+func (s *FreshbooksService) usesNewAPI() bool {
+	return s.oauth2Token != nil
+}
+
+func (s *FreshbooksService) do(method, path string, body interface{}, v interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, freshbooksAPIURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	t := &oauth.Transport{
+		Token:     s.oauth2Token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	resp, err := t.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("freshbooks: %s %s failed with status code %d: %s", method, path, resp.StatusCode, b)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// getPages requests all pages of path, handle decodes a page
+// and returns the page counts as response shapes differ by API
+func (s *FreshbooksService) getPages(path string, handle func(b json.RawMessage) (freshbooksPages, error)) error {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	for page := 1; ; page++ {
+		var b json.RawMessage
+		if err := s.do("GET", fmt.Sprintf("%s%spage=%d&per_page=%d", path, separator, page, freshbooksPerPage), nil, &b); err != nil {
+			return err
+		}
+		pages, err := handle(b)
+		if err != nil {
+			return err
+		}
+		if page >= pages.Pages {
+			return nil
+		}
+	}
+}
+
+func (s *FreshbooksService) identity() (*freshbooksIdentity, error) {
+	var response struct {
+		Response freshbooksIdentity `json:"response"`
+	}
+	if err := s.do("GET", "/auth/api/v1/users/me", nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Response, nil
+}
+
+func (s *FreshbooksService) businessID() (int, error) {
+	if s.FreshbooksParams == nil {
+		return 0, errors.New("account_id must be present")
+	}
+	return s.AccountID, nil
+}
+
+// accountingAccountID returns the ID accounting API uses for the selected business
+func (s *FreshbooksService) accountingAccountID() (string, error) {
+	businessID, err := s.businessID()
+	if err != nil {
+		return "", err
+	}
+	if s.accountingID != "" {
+		return s.accountingID, nil
+	}
+	identity, err := s.identity()
+	if err != nil {
+		return "", err
+	}
+	for _, membership := range identity.BusinessMemberships {
+		if membership.Business.ID == int64(businessID) {
+			s.accountingID = membership.Business.AccountID
+			return s.accountingID, nil
+		}
+	}
+	return "", fmt.Errorf("freshbooks business %d not found", businessID)
+}
+
+func (s *FreshbooksService) accountsFromNewAPI() ([]*Account, error) {
+	identity, err := s.identity()
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	for _, membership := range identity.BusinessMemberships {
+		accounts = append(accounts, &Account{
+			ID:   membership.Business.ID,
+			Name: membership.Business.Name,
+		})
+	}
+	return accounts, nil
+}
+
+// usersFromNewAPI maps team members, time entries refer to their identity
+func (s *FreshbooksService) usersFromNewAPI() ([]*User, error) {
+	businessID, err := s.businessID()
+	if err != nil {
+		return nil, err
+	}
+	var users []*User
+	path := fmt.Sprintf("/auth/api/v1/businesses/%d/team_members", businessID)
+	err = s.getPages(path, func(b json.RawMessage) (freshbooksPages, error) {
+		var response struct {
+			Response []freshbooksTeamMember `json:"response"`
+			Meta     freshbooksPages        `json:"meta"`
+		}
+		if err := json.Unmarshal(b, &response); err != nil {
+			return response.Meta, err
+		}
+		for _, object := range response.Response {
+			if !object.Active {
+				continue
+			}
+			users = append(users, &User{
+				ForeignID: strconv.Itoa(object.IdentityID),
+				Name:      strings.TrimSpace(object.FirstName + " " + object.LastName),
+				Email:     object.Email,
+			})
+		}
+		return response.Meta, nil
+	})
+	return users, err
+}
+
+// clientsFromNewAPI maps clients which aren't deleted or archived
+func (s *FreshbooksService) clientsFromNewAPI() ([]*Client, error) {
+	accountID, err := s.accountingAccountID()
+	if err != nil {
+		return nil, err
+	}
+	var clients []*Client
+	path := fmt.Sprintf("/accounting/account/%s/users/clients", accountID)
+	err = s.getPages(path, func(b json.RawMessage) (freshbooksPages, error) {
+		var response struct {
+			Response struct {
+				Result struct {
+					Clients []freshbooksClient `json:"clients"`
+					freshbooksPages
+				} `json:"result"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(b, &response); err != nil {
+			return freshbooksPages{}, err
+		}
+		result := response.Response.Result
+		for _, object := range result.Clients {
+			if object.VisState != 0 {
+				continue
+			}
+			name := object.Organization
+			if name == "" {
+				name = strings.TrimSpace(object.FirstName + " " + object.LastName)
+			}
+			clients = append(clients, &Client{
+				ForeignID: strconv.Itoa(object.ID),
+				Name:      name,
+			})
+		}
+		return result.freshbooksPages, nil
+	})
+	return clients, err
+}
+
+func (s *FreshbooksService) projects() ([]freshbooksProject, error) {
+	businessID, err := s.businessID()
+	if err != nil {
+		return nil, err
+	}
+	var projects []freshbooksProject
+	path := fmt.Sprintf("/projects/business/%d/projects", businessID)
+	err = s.getPages(path, func(b json.RawMessage) (freshbooksPages, error) {
+		var response struct {
+			Projects []freshbooksProject `json:"projects"`
+			Meta     freshbooksPages     `json:"meta"`
+		}
+		if err := json.Unmarshal(b, &response); err != nil {
+			return response.Meta, err
+		}
+		projects = append(projects, response.Projects...)
+		return response.Meta, nil
+	})
+	return projects, err
+}
+
+func (s *FreshbooksService) projectsFromNewAPI() ([]*Project, error) {
+	foreignObjects, err := s.projects()
+	if err != nil {
+		return nil, err
+	}
+	var projects []*Project
+	for _, object := range foreignObjects {
+		project := &Project{
+			Active:         object.Active && !object.Complete,
+			Billable:       object.Billable,
+			Name:           object.Title,
+			DueDate:        object.DueDate,
+			EstimatedHours: object.Budget,
+			ForeignID:      strconv.Itoa(object.ID),
+		}
+		if object.Rate != "" {
+			project.Rate, _ = object.Rate.Float64()
+		}
+		if object.ClientID > 0 {
+			project.foreignClientID = strconv.Itoa(object.ClientID)
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// tasksFromNewAPI maps services of projects to tasks,
+// foreign IDs combine service and project IDs like Classic tasks
+func (s *FreshbooksService) tasksFromNewAPI() ([]*Task, error) {
+	projects, err := s.projects()
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	for _, project := range projects {
+		for _, service := range project.Services {
+			tasks = append(tasks, &Task{
+				Active:           project.Active && !project.Complete,
+				Name:             service.Name,
+				ForeignID:        fmt.Sprintf("%d-%d", service.ID, project.ID),
+				foreignProjectID: strconv.Itoa(project.ID),
+			})
+		}
+	}
+	return tasks, nil
+}
+
+func (s *FreshbooksService) exportTimeEntryToNewAPI(t *TimeEntry) (int, error) {
+	businessID, err := s.businessID()
+	if err != nil {
+		return 0, err
+	}
+	if t.DurationInSeconds <= 0 {
+		// running entries are exported once they are stopped
+		return numberStrToInt(t.foreignID), nil
+	}
+	start, err := time.Parse(time.RFC3339, t.Start)
+	if err != nil {
+		return 0, err
+	}
+	entry := freshbooksTimeEntry{
+		IsLogged:   true,
+		Duration:   t.DurationInSeconds,
+		Note:       t.Description,
+		StartedAt:  start.UTC().Format("2006-01-02T15:04:05.000Z"),
+		ProjectID:  numberStrToInt(t.foreignProjectID),
+		ServiceID:  numberStrToInt(t.foreignTaskID),
+		IdentityID: numberStrToInt(t.foreignUserID),
+	}
+	if entry.ServiceID == 0 {
+		return 0, fmt.Errorf("task not provided for time entry '%s'", entry.Note)
+	}
+	method, path := "POST", fmt.Sprintf("/timetracking/business/%d/time_entries", businessID)
+	if foreignID := numberStrToInt(t.foreignID); foreignID > 0 {
+		method, path = "PUT", fmt.Sprintf("%s/%d", path, foreignID)
+	}
+	var response struct {
+		TimeEntry freshbooksTimeEntry `json:"time_entry"`
+	}
+	body := struct {
+		TimeEntry freshbooksTimeEntry `json:"time_entry"`
+	}{entry}
+	if err := s.do(method, path, body, &response); err != nil {
+		return 0, err
+	}
+	return response.TimeEntry.ID, nil
+}
+
+// migrateFreshbooksConnections links objects of the new API to the Toggl
+// objects linked to Freshbooks Classic, IDs differ between the APIs so
+// objects are matched by the names of the linked Toggl objects. Objects
+// are migrated once, when the new API has no connection of their type yet.
+// Exported time entries can't be matched and aren't migrated.
+func migrateFreshbooksConnections(p *Pipe) error {
+	if p.serviceID != "freshbooks" {
+		return nil
+	}
+	service, err := p.Service()
+	if err != nil {
+		return err
+	}
+	s := service.(*FreshbooksService)
+	if !s.usesNewAPI() || s.FreshbooksParams == nil {
+		return nil
+	}
+	migrated, err := loadConnection(s, freshbooksMigrationID)
+	if err != nil {
+		return err
+	}
+	classic := &FreshbooksService{workspaceID: s.workspaceID}
+	if migrated.Data[timeEntriesConnectionID] == 0 {
+		migrated.Data[timeEntriesConnectionID] = 1
+		if err := restartFreshbooksExport(p, s, classic); err != nil {
+			return err
+		}
+	}
+	for _, objectType := range freshbooksMigratedObjects {
+		if migrated.Data[objectType] > 0 {
+			continue
+		}
+		migrated.Data[objectType] = 1
+		connection, err := loadConnection(s, objectType)
+		if err != nil {
+			return err
+		}
+		classicConnection, err := loadConnection(classic, objectType)
+		if err != nil {
+			return err
+		}
+		if len(connection.Data) > 0 || len(classicConnection.Data) == 0 {
+			continue
+		}
+		foreignIDs, err := s.migrationKeys(objectType)
+		if err != nil {
+			return err
+		}
+		togglObjects, err := togglClient.GetObjects(p.authorization.WorkspaceToken, s.workspaceID, togglObjectType(objectType))
+		if err != nil {
+			return err
+		}
+		togglKeys := make(map[int]string, len(togglObjects))
+		for _, object := range togglObjects {
+			togglKeys[object.ID] = migrationKey(object.Name, object.ProjectID)
+		}
+		for _, togglID := range classicConnection.Data {
+			if foreignID, found := foreignIDs[togglKeys[togglID]]; found {
+				connection.Data[foreignID] = togglID
+			}
+		}
+		if err := connection.save(); err != nil {
+			return err
+		}
+	}
+	return migrated.save()
+}
+
+// restartFreshbooksExport moves the last sync of the time entries export to now,
+// entries exported to Classic can't be linked to the new API and would be duplicated
+func restartFreshbooksExport(p *Pipe, s, classic *FreshbooksService) error {
+	connection, err := loadConnection(s, timeEntriesConnectionID)
+	if err != nil {
+		return err
+	}
+	classicConnection, err := loadConnection(classic, timeEntriesConnectionID)
+	if err != nil {
+		return err
+	}
+	if len(connection.Data) > 0 || len(classicConnection.Data) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if p.ID == "timeentries" {
+		// the status of the running export is saved when the run ends
+		p.lastSync = &now
+		return nil
+	}
+	status, err := loadPipeStatus(s.workspaceID, "freshbooks", "timeentries")
+	if err != nil {
+		return err
+	}
+	if status == nil {
+		status = NewPipeStatus(s.workspaceID, "freshbooks", "timeentries")
+		status.Status = "success"
+	}
+	status.SyncDate = now.Format(time.RFC3339)
+	return store.SavePipeStatus(status)
+}
+
+// migrationKey identifies an object by name, tasks within their Toggl project
+func migrationKey(name string, projectID int) string {
+	return fmt.Sprintf("%s/%d", strings.ToLower(strings.TrimSpace(name)), projectID)
+}
+
+// migrationKeys returns foreign IDs of objectType by migration key,
+// names shared by several objects are left out as ambiguous
+func (s *FreshbooksService) migrationKeys(objectType string) (map[string]string, error) {
+	keys := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	add := func(key, foreignID string) {
+		if _, exists := keys[key]; exists {
+			ambiguous[key] = true
+		}
+		keys[key] = foreignID
+	}
+	switch objectType {
+	case usersPipeID:
+		users, err := s.Users()
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			add(migrationKey(user.Name, 0), user.ForeignID)
+		}
+	case clientsPipeID:
+		clients, err := s.Clients()
+		if err != nil {
+			return nil, err
+		}
+		for _, client := range clients {
+			add(migrationKey(client.Name, 0), client.ForeignID)
+		}
+	case projectsPipeID:
+		projects, err := s.Projects()
+		if err != nil {
+			return nil, err
+		}
+		for _, project := range projects {
+			add(migrationKey(project.Name, 0), project.ForeignID)
+		}
+	case tasksPipeId:
+		projectConnection, err := loadConnection(s, projectsPipeID)
+		if err != nil {
+			return nil, err
+		}
+		tasks, err := s.Tasks()
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			add(migrationKey(task.Name, projectConnection.Data[task.foreignProjectID]), task.ForeignID)
+		}
+	}
+	for key := range ambiguous {
+		delete(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newFreshbooksTestServer(t *testing.T, posted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path + "?" + r.URL.Query().Get("page") {
+		case "GET /auth/api/v1/users/me?":
+			w.Write([]byte(`{"response":{"business_memberships":[{"business":{"id":1,"name":"Studio","account_id":"xZ1"}}]}}`))
+		case "GET /accounting/account/xZ1/users/clients?1":
+			w.Write([]byte(`{"response":{"result":{"clients":[{"id":10,"organization":"ACME","vis_state":0}],"page":1,"pages":2}}}`))
+		case "GET /accounting/account/xZ1/users/clients?2":
+			w.Write([]byte(`{"response":{"result":{"clients":[{"id":11,"organization":"","fname":"Jane","lname":"Doe","vis_state":0},{"id":12,"organization":"Gone","vis_state":1}],"page":2,"pages":2}}}`))
+		case "GET /projects/business/1/projects?1":
+			w.Write([]byte(`{"projects":[
+				{"id":900,"title":"Website","active":true,"complete":false,"billable":true,"client_id":10,"services":[{"id":3,"name":"Design"}]},
+				{"id":901,"title":"Brochure","active":true,"complete":true,"client_id":0,"services":[]}
+			],"meta":{"page":1,"pages":1}}`))
+		case "POST /timetracking/business/1/time_entries?":
+			b, _ := ioutil.ReadAll(r.Body)
+			*posted = append(*posted, string(b))
+			w.Write([]byte(`{"time_entry":{"id":55}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestFreshbooksNewAPI(t *testing.T) {
+	var posted []string
+	ts := newFreshbooksTestServer(t, &posted)
+	defer ts.Close()
+	defer func(apiURL string) { freshbooksAPIURL = apiURL }(freshbooksAPIURL)
+	freshbooksAPIURL = ts.URL
+
+	s := getService("freshbooks", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := s.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].ID != 1 || accounts[0].Name != "Studio" {
+		t.Fatalf("expected businesses as accounts, got %+v", accounts)
+	}
+	if err := s.setParams([]byte(`{"account_id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if key := s.keyFor(projectsPipeID); key != "freshbooks:account:1:projects" {
+		t.Errorf("unexpected key %s", key)
+	}
+
+	clients, err := s.Clients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients[0].Name != "ACME" || clients[1].Name != "Jane Doe" {
+		t.Errorf("expected active clients of all pages, got %+v", clients)
+	}
+
+	projects, err := s.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects[0].foreignClientID != "10" || !projects[0].Active || projects[1].Active {
+		t.Errorf("unexpected projects %+v", projects)
+	}
+
+	tasks, err := s.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ForeignID != "3-900" || tasks[0].Name != "Design" {
+		t.Errorf("expected project services as tasks, got %+v", tasks)
+	}
+
+	id, err := s.ExportTimeEntry(&TimeEntry{
+		Start: "2026-10-03T10:00:00+02:00", DurationInSeconds: 1800, Description: "Mockups",
+		foreignTaskID: "3", foreignProjectID: "900", foreignUserID: "7",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 55 || len(posted) != 1 || posted[0] != `{"time_entry":{"is_logged":true,"duration":1800,"note":"Mockups","started_at":"2026-10-03T08:00:00.000Z","project_id":900,"service_id":3,"identity_id":7}}` {
+		t.Errorf("unexpected export %d %v", id, posted)
+	}
+}
+
+func TestMigrateFreshbooksConnections(t *testing.T) {
+	ts := newFreshbooksTestServer(t, nil)
+	defer ts.Close()
+	defer func(apiURL string) { freshbooksAPIURL = apiURL }(freshbooksAPIURL)
+	freshbooksAPIURL = ts.URL
+
+	authorization := NewAuthorization(48, "freshbooks")
+	authorization.WorkspaceToken = "toggl"
+	authorization.Data = []byte(`{"AccessToken":"token"}`)
+	if err := authorization.save(); err != nil {
+		t.Fatal(err)
+	}
+	classic := &FreshbooksService{workspaceID: 48}
+	for pipeID, data := range map[string]map[string]int{
+		projectsPipeID:          {"4": 21, "5": 22},
+		tasksPipeId:             {"8-4": 31},
+		timeEntriesConnectionID: {"61": 501},
+	} {
+		connection := NewConnection(classic, pipeID)
+		connection.Data = data
+		if err := connection.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := &fakeTogglAPI{objects: map[string][]TogglObject{
+		"projects": {{ID: 21, Name: "Website"}, {ID: 22, Name: "Retired"}},
+		"tasks":    {{ID: 31, Name: "design", ProjectID: 21}},
+	}}
+	defer withFakeTogglAPI(fake)()
+
+	export := NewPipeStatus(48, "freshbooks", "timeentries")
+	export.SyncDate = "2026-01-01T00:00:00Z"
+	if err := store.SavePipeStatus(export); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPipe(48, "freshbooks", projectsPipeID)
+	p.ServiceParams = []byte(`{"account_id":1}`)
+	p.authorization = authorization
+	if err := migrateFreshbooksConnections(p); err != nil {
+		t.Fatal(err)
+	}
+	lastSync, err := store.LoadLastSync(48, pipesKey("freshbooks", "timeentries"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastSync == nil || time.Since(*lastSync) > time.Minute {
+		t.Errorf("expected time entries export to restart at the migration, got %v", lastSync)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	projects, err := loadConnection(s, projectsPipeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects.Data) != 1 || projects.Data["900"] != 21 {
+		t.Errorf("expected project to be relinked by name, got %v", projects.Data)
+	}
+	tasks, err := loadConnection(s, tasksPipeId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks.Data) != 1 || tasks.Data["3-900"] != 31 {
+		t.Errorf("expected task to be relinked within its project, got %v", tasks.Data)
+	}
+	classicProjects, err := loadConnection(classic, projectsPipeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classicProjects.Data) != 2 {
+		t.Errorf("expected Classic connection to be kept, got %v", classicProjects.Data)
+	}
+
+	// later runs skip migrated types even when their connections are empty
+	delete(projects.Data, "900")
+	if err := projects.save(); err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.calls
+	if err := migrateFreshbooksConnections(p); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls != calls {
+		t.Errorf("expected migration to run once, got %d more Toggl calls", fake.calls-calls)
+	}
+}
@@ -4,16 +4,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"code.google.com/p/goauth2/oauth"
 	"github.com/google/go-github/github"
 )
 
+// Clients option values of Github pipes
+const (
+	githubOwnersAsClients        = "owners"
+	githubOrganizationsAsClients = "organizations"
+)
+
+// githubAPIURL is replaced in tests
+var githubAPIURL = "https://api.github.com/"
+
 type GithubService struct {
 	emptyService
 	workspaceID int
-	token       oauth.Token
+	*GithubParams
+	token oauth.Token
+}
+
+// GithubParams selects which repository owners are imported as clients,
+// "owners" imports all of them and "organizations" only organizations
+type GithubParams struct {
+	Clients string `json:"clients,omitempty"`
 }
 
 func (s *GithubService) Name() string {
@@ -28,6 +47,20 @@ func (s *GithubService) keyFor(objectType string) string {
 	return fmt.Sprintf("github:%s", objectType)
 }
 
+func (s *GithubService) setParams(b []byte) error {
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s.GithubParams == nil {
+		return nil
+	}
+	switch s.GithubParams.Clients {
+	case "", githubOwnersAsClients, githubOrganizationsAsClients:
+		return nil
+	}
+	return fmt.Errorf("clients must be %q or %q", githubOwnersAsClients, githubOrganizationsAsClients)
+}
+
 func (s *GithubService) setAuthData(b []byte) error {
 	if err := json.Unmarshal(b, &s.token); err != nil {
 		return err
@@ -42,6 +75,47 @@ func (s *GithubService) Accounts() ([]*Account, error) {
 	return accounts, nil
 }
 
+// clientOf returns owner of the repo when it's imported as client
+func (s *GithubService) clientOf(repo *github.Repository) *github.User {
+	if s.GithubParams == nil || repo.Owner == nil {
+		return nil
+	}
+	switch s.GithubParams.Clients {
+	case githubOwnersAsClients:
+		return repo.Owner
+	case githubOrganizationsAsClients:
+		if repo.Owner.GetType() == "Organization" {
+			return repo.Owner
+		}
+	}
+	return nil
+}
+
+// Map owners of Github repos to clients when enabled
+func (s *GithubService) Clients() ([]*Client, error) {
+	if s.GithubParams == nil || s.GithubParams.Clients == "" {
+		return s.emptyService.Clients()
+	}
+	repos, _, err := s.client().Repositories.List(context.Background(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var clients []*Client
+	seen := map[int64]bool{}
+	for _, object := range repos {
+		owner := s.clientOf(object)
+		if owner == nil || seen[owner.GetID()] {
+			continue
+		}
+		seen[owner.GetID()] = true
+		clients = append(clients, &Client{
+			ForeignID: strconv.FormatInt(owner.GetID(), 10),
+			Name:      owner.GetLogin(),
+		})
+	}
+	return clients, nil
+}
+
 // Map Github repos to projects
 func (s *GithubService) Projects() ([]*Project, error) {
 	repos, _, err := s.client().Repositories.List(context.Background(), "", nil)
@@ -55,12 +129,89 @@ func (s *GithubService) Projects() ([]*Project, error) {
 			Name:      *object.Name,
 			ForeignID: strconv.FormatInt(*object.ID, 10),
 		}
+		if owner := s.clientOf(object); owner != nil {
+			project.foreignClientID = strconv.FormatInt(owner.GetID(), 10)
+		}
 		projects = append(projects, &project)
 	}
 	return projects, nil
 }
 
+// Map collaborators of Github repos to project members,
+// repos whose collaborators the user may not list have none
+func (s *GithubService) ProjectMembers() (map[string][]string, error) {
+	c := s.client()
+	repos, _, err := c.Repositories.List(context.Background(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string][]string)
+	for _, repo := range repos {
+		projectID := strconv.FormatInt(repo.GetID(), 10)
+		opt := &github.ListCollaboratorsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			users, resp, err := c.Repositories.ListCollaborators(context.Background(), repo.GetOwner().GetLogin(), repo.GetName(), opt)
+			if resp != nil && resp.StatusCode == http.StatusForbidden {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, user := range users {
+				members[projectID] = append(members[projectID], strconv.FormatInt(user.GetID(), 10))
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+	return members, nil
+}
+
 func (s *GithubService) client() *github.Client {
-	t := &oauth.Transport{Token: &s.token}
-	return github.NewClient(t.Client())
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	c := github.NewClient(t.Client())
+	c.BaseURL, _ = url.Parse(githubAPIURL)
+	return c
+}
+
+// Map labels of Github repos to tags, labels named alike in several
+// repos become one tag linked to the first of them
+func (s *GithubService) Tags() ([]*Tag, error) {
+	c := s.client()
+	repos, _, err := c.Repositories.List(context.Background(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var tags []*Tag
+	seen := map[string]bool{}
+	for _, repo := range repos {
+		opt := &github.ListOptions{PerPage: 100}
+		for {
+			labels, resp, err := c.Issues.ListLabels(context.Background(), repo.GetOwner().GetLogin(), repo.GetName(), opt)
+			if err != nil {
+				return nil, err
+			}
+			for _, label := range labels {
+				name := strings.ToLower(label.GetName())
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				tags = append(tags, &Tag{
+					ForeignID: strconv.FormatInt(label.GetID(), 10),
+					Name:      label.GetName(),
+				})
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+	return tags, nil
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"code.google.com/p/goauth2/oauth"
@@ -28,3 +31,91 @@ func TestGithubProjects(t *testing.T) {
 		t.Error("should return some projects")
 	}
 }
+
+func TestGithubClients(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user/repos" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[
+			{"id":1,"name":"api","owner":{"id":10,"login":"toggl","type":"Organization"}},
+			{"id":2,"name":"web","owner":{"id":10,"login":"toggl","type":"Organization"}},
+			{"id":3,"name":"dotfiles","owner":{"id":20,"login":"jane","type":"User"}}
+		]`))
+	}))
+	defer ts.Close()
+	defer func(apiURL string) { githubAPIURL = apiURL }(githubAPIURL)
+	githubAPIURL = ts.URL + "/"
+
+	s := getService("github", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setParams([]byte(`{"clients":"organizations"}`)); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := s.Clients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 1 || clients[0].ForeignID != "10" || clients[0].Name != "toggl" {
+		t.Errorf("expected organizations as clients, got %+v", clients)
+	}
+	projects, err := s.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 3 || projects[1].foreignClientID != "10" || projects[2].foreignClientID != "" {
+		t.Errorf("expected only organization repos to have clients, got %+v", projects)
+	}
+
+	if err := s.setParams([]byte(`{"clients":"owners"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if clients, err = s.Clients(); err != nil {
+		t.Fatal(err)
+	}
+	if len(clients) != 2 || clients[1].Name != "jane" {
+		t.Errorf("expected all repository owners as clients, got %+v", clients)
+	}
+}
+
+func TestGithubTags(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path + "?" + r.URL.Query().Get("page") {
+		case "/user/repos?":
+			w.Write([]byte(`[
+				{"id":1,"name":"api","owner":{"id":10,"login":"toggl"}},
+				{"id":2,"name":"web","owner":{"id":10,"login":"toggl"}}
+			]`))
+		case "/repos/toggl/api/labels?":
+			w.Header().Set("Link", `<`+"http://"+r.Host+`/repos/toggl/api/labels?page=2>; rel="next"`)
+			w.Write([]byte(`[{"id":100,"name":"bug"}]`))
+		case "/repos/toggl/api/labels?2":
+			w.Write([]byte(`[{"id":101,"name":"design"}]`))
+		case "/repos/toggl/web/labels?":
+			w.Write([]byte(`[{"id":200,"name":"Bug"},{"id":201,"name":"seo"}]`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	defer func(apiURL string) { githubAPIURL = apiURL }(githubAPIURL)
+	githubAPIURL = ts.URL + "/"
+
+	s := getService("github", 1)
+	tags, err := s.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.ForeignID+":"+tag.Name)
+	}
+	if strings.Join(names, ",") != "100:bug,101:design,201:seo" {
+		t.Errorf("expected labels of all repos and pages once per name, got %v", names)
+	}
+}
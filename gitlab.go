@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+// gitlabURL is used unless the authorization is for a self-managed instance
+const gitlabURL = "https://gitlab.com"
+
+const gitlabPerPage = 100
+
+type GitlabService struct {
+	emptyService
+	workspaceID int
+	*GitlabParams
+	token oauth.Token
+	since *time.Time
+}
+
+// GitlabParams selects a GitLab group, subgroups are included
+type GitlabParams struct {
+	AccountID int64 `json:"account_id"`
+}
+
+type (
+	gitlabGroup struct {
+		ID       int64  `json:"id"`
+		FullName string `json:"full_name"`
+	}
+
+	gitlabMember struct {
+		ID          int    `json:"id"`
+		Name        string `json:"name"`
+		Email       string `json:"email"`
+		PublicEmail string `json:"public_email"`
+		State       string `json:"state"`
+	}
+
+	gitlabProject struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Archived bool   `json:"archived"`
+	}
+
+	gitlabMilestone struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+		State string `json:"state"`
+	}
+
+	gitlabIssue struct {
+		ID        int    `json:"id"`
+		Title     string `json:"title"`
+		State     string `json:"state"`
+		ProjectID int    `json:"project_id"`
+		Assignees []struct {
+			ID int `json:"id"`
+		} `json:"assignees"`
+		TimeStats struct {
+			TimeEstimate int `json:"time_estimate"`
+		} `json:"time_stats"`
+	}
+)
+
+func (s *GitlabService) Name() string {
+	return "gitlab"
+}
+
+func (s *GitlabService) WorkspaceID() int {
+	return s.workspaceID
+}
+
+func (s *GitlabService) keyFor(objectType string) string {
+	if s.GitlabParams == nil {
+		return fmt.Sprintf("gitlab:account:%s", objectType)
+	}
+	return fmt.Sprintf("gitlab:account:%d:%s", s.AccountID, objectType)
+}
+
+func (s *GitlabService) setSince(since *time.Time) {
+	s.since = since
+}
+
+func (s *GitlabService) setParams(b []byte) error {
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s.GitlabParams == nil || s.AccountID == 0 {
+		return errors.New("account_id must be present")
+	}
+	return nil
+}
+
+func (s *GitlabService) setAuthData(b []byte) error {
+	return json.Unmarshal(b, &s.token)
+}
+
+// baseURL returns URL of the instance the authorization was made for
+func (s *GitlabService) baseURL() string {
+	if baseURL := s.token.Extra["base_url"]; baseURL != "" {
+		return baseURL
+	}
+	return gitlabURL
+}
+
+func (s *GitlabService) client() *http.Client {
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	return t.Client()
+}
+
+func (s *GitlabService) do(method, path string, params url.Values, body []byte) (*http.Response, []byte, error) {
+	u := s.baseURL() + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("gitlab: %s %s failed with status code %d: %s", method, path, resp.StatusCode, b)
+	}
+	return resp, b, nil
+}
+
+// getPages requests all pages of path, handle decodes one page
+func (s *GitlabService) getPages(path string, params url.Values, handle func(b []byte) error) error {
+	params.Set("per_page", strconv.Itoa(gitlabPerPage))
+	for page := "1"; page != ""; {
+		params.Set("page", page)
+		resp, b, err := s.do("GET", path, params, nil)
+		if err != nil {
+			return err
+		}
+		if err := handle(b); err != nil {
+			return err
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return nil
+}
+
+func (s *GitlabService) projects() ([]gitlabProject, error) {
+	var projects []gitlabProject
+	params := url.Values{"include_subgroups": {"true"}, "simple": {"true"}}
+	err := s.getPages(fmt.Sprintf("/api/v4/groups/%d/projects", s.AccountID), params, func(b []byte) error {
+		var page []gitlabProject
+		if err := json.Unmarshal(b, &page); err != nil {
+			return err
+		}
+		projects = append(projects, page...)
+		return nil
+	})
+	return projects, err
+}
+
+// Map GitLab groups to local accounts
+func (s *GitlabService) Accounts() ([]*Account, error) {
+	var accounts []*Account
+	params := url.Values{"min_access_level": {"10"}}
+	err := s.getPages("/api/v4/groups", params, func(b []byte) error {
+		var groups []gitlabGroup
+		if err := json.Unmarshal(b, &groups); err != nil {
+			return err
+		}
+		for _, object := range groups {
+			accounts = append(accounts, &Account{ID: object.ID, Name: object.FullName})
+		}
+		return nil
+	})
+	return accounts, err
+}
+
+// Map GitLab group members to local users, e-mails are returned
+// only to admins, otherwise the public e-mail of the member is used
+func (s *GitlabService) Users() ([]*User, error) {
+	var users []*User
+	err := s.getPages(fmt.Sprintf("/api/v4/groups/%d/members/all", s.AccountID), url.Values{}, func(b []byte) error {
+		var members []gitlabMember
+		if err := json.Unmarshal(b, &members); err != nil {
+			return err
+		}
+		for _, object := range members {
+			if object.State != "" && object.State != "active" {
+				continue
+			}
+			email := object.Email
+			if email == "" {
+				email = object.PublicEmail
+			}
+			users = append(users, &User{
+				ForeignID: strconv.Itoa(object.ID),
+				Name:      object.Name,
+				Email:     email,
+			})
+		}
+		return nil
+	})
+	return users, err
+}
+
+// Map GitLab projects to projects
+func (s *GitlabService) Projects() ([]*Project, error) {
+	foreignObjects, err := s.projects()
+	if err != nil {
+		return nil, err
+	}
+	var projects []*Project
+	for _, object := range foreignObjects {
+		projects = append(projects, &Project{
+			ForeignID: strconv.Itoa(object.ID),
+			Name:      object.Name,
+			Active:    !object.Archived,
+		})
+	}
+	return projects, nil
+}
+
+// Map GitLab milestones of projects to tasks
+func (s *GitlabService) TodoLists() ([]*Task, error) {
+	projects, err := s.projects()
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	for _, project := range projects {
+		projectID := strconv.Itoa(project.ID)
+		err := s.getPages(fmt.Sprintf("/api/v4/projects/%d/milestones", project.ID), url.Values{}, func(b []byte) error {
+			var milestones []gitlabMilestone
+			if err := json.Unmarshal(b, &milestones); err != nil {
+				return err
+			}
+			for _, object := range milestones {
+				tasks = append(tasks, &Task{
+					ForeignID:        strconv.Itoa(object.ID),
+					Name:             object.Title,
+					Active:           object.State == "active",
+					foreignProjectID: projectID,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// Map GitLab issues to tasks, closed issues are inactive
+func (s *GitlabService) Tasks() ([]*Task, error) {
+	params := url.Values{"scope": {"all"}, "state": {"all"}}
+	if s.since != nil && !s.since.IsZero() {
+		params.Set("updated_after", s.since.Format(time.RFC3339))
+	}
+	var tasks []*Task
+	err := s.getPages(fmt.Sprintf("/api/v4/groups/%d/issues", s.AccountID), params, func(b []byte) error {
+		var issues []gitlabIssue
+		if err := json.Unmarshal(b, &issues); err != nil {
+			return err
+		}
+		for _, object := range issues {
+			task := &Task{
+				ForeignID:        strconv.Itoa(object.ID),
+				Name:             object.Title,
+				Active:           object.State == "opened",
+				EstimatedSeconds: object.TimeStats.TimeEstimate,
+				foreignProjectID: strconv.Itoa(object.ProjectID),
+			}
+			if len(object.Assignees) > 0 {
+				task.foreignUserID = strconv.Itoa(object.Assignees[0].ID)
+			}
+			tasks = append(tasks, task)
+		}
+		return nil
+	})
+	return tasks, err
+}
+
+// issueReference finds the project and project specific ID of an issue,
+// REST API finds issues by their global ID only for admins
+func (s *GitlabService) issueReference(issueID int) (projectID int, iid string, err error) {
+	query := struct {
+		Query     string            `json:"query"`
+		Variables map[string]string `json:"variables"`
+	}{
+		Query:     `query($id: IssueID!) { issue(id: $id) { iid projectId } }`,
+		Variables: map[string]string{"id": fmt.Sprintf("gid://gitlab/Issue/%d", issueID)},
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return 0, "", err
+	}
+	_, b, err := s.do("POST", "/api/graphql", nil, body)
+	if err != nil {
+		return 0, "", err
+	}
+	var response struct {
+		Data struct {
+			Issue *struct {
+				IID       string `json:"iid"`
+				ProjectID int    `json:"projectId"`
+			} `json:"issue"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return 0, "", err
+	}
+	if response.Data.Issue == nil {
+		return 0, "", fmt.Errorf("gitlab issue %d not found", issueID)
+	}
+	return response.Data.Issue.ProjectID, response.Data.Issue.IID, nil
+}
+
+// ExportTimeEntry adds the duration of the time entry as time spent on its issue,
+// the same as /spend quick action. GitLab doesn't identify spent time, so the
+// issue ID is returned and entries exported once aren't exported again.
+func (s *GitlabService) ExportTimeEntry(t *TimeEntry) (int, error) {
+	if exportedTo := numberStrToInt(t.foreignID); exportedTo > 0 {
+		return exportedTo, nil
+	}
+	issueID := numberStrToInt(t.foreignTaskID)
+	if issueID == 0 {
+		return 0, fmt.Errorf("task not provided for time entry '%s'", t.Description)
+	}
+	if t.DurationInSeconds <= 0 {
+		// running entries are exported once they are stopped
+		return 0, nil
+	}
+	projectID, iid, err := s.issueReference(issueID)
+	if err != nil {
+		return 0, err
+	}
+	minutes := (t.DurationInSeconds + 30) / 60
+	if minutes == 0 {
+		minutes = 1
+	}
+	params := url.Values{"duration": {fmt.Sprintf("%dm", minutes)}}
+	if t.Description != "" {
+		params.Set("summary", t.Description)
+	}
+	path := fmt.Sprintf("/api/v4/projects/%d/issues/%s/add_spent_time", projectID, url.PathEscape(iid))
+	if _, _, err := s.do("POST", path, params, nil); err != nil {
+		return 0, err
+	}
+	return issueID, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newGitlabTestServer(t *testing.T, spent *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path + "?" + r.URL.Query().Get("page") {
+		case "/api/v4/groups/7/issues?1":
+			w.Header().Set("X-Next-Page", "2")
+			w.Write([]byte(`[{"id":101,"title":"Login fails","state":"opened","project_id":3,"assignees":[{"id":9}],"time_stats":{"time_estimate":7200}}]`))
+		case "/api/v4/groups/7/issues?2":
+			w.Write([]byte(`[{"id":102,"title":"Old layout","state":"closed","project_id":3}]`))
+		case "/api/graphql?":
+			b, _ := ioutil.ReadAll(r.Body)
+			if string(b) != `{"query":"query($id: IssueID!) { issue(id: $id) { iid projectId } }","variables":{"id":"gid://gitlab/Issue/101"}}` {
+				t.Errorf("unexpected query %s", b)
+			}
+			w.Write([]byte(`{"data":{"issue":{"iid":"5","projectId":3}}}`))
+		case "/api/v4/projects/3/issues/5/add_spent_time?":
+			*spent = append(*spent, r.URL.RawQuery)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGitlabService(t *testing.T) {
+	var spent []string
+	ts := newGitlabTestServer(t, &spent)
+	defer ts.Close()
+
+	s := getService("gitlab", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token","Extra":{"base_url":"` + ts.URL + `"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setParams([]byte(`{"account_id":7}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, err := s.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("expected issues of all pages, got %+v", tasks)
+	}
+	if tasks[0].ForeignID != "101" || !tasks[0].Active || tasks[1].Active || tasks[1].foreignProjectID != "3" {
+		t.Errorf("unexpected tasks %+v %+v", tasks[0], tasks[1])
+	}
+	if tasks[0].foreignUserID != "9" || tasks[0].EstimatedSeconds != 7200 || tasks[1].foreignUserID != "" {
+		t.Errorf("expected assignee and estimate of the issue, got %+v", tasks[0])
+	}
+
+	entry := &TimeEntry{DurationInSeconds: 5400, Description: "Debugging", foreignTaskID: "101"}
+	id, err := s.ExportTimeEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 101 || len(spent) != 1 || spent[0] != "duration=90m&summary=Debugging" {
+		t.Errorf("expected 90m to be spent on the issue, got %d %v", id, spent)
+	}
+
+	entry.foreignID = "101"
+	if _, err := s.ExportTimeEntry(entry); err != nil || len(spent) != 1 {
+		t.Errorf("expected exported entry not to be exported again, got %v %v", err, spent)
+	}
+}
+
+func TestGitlabConnectionKeys(t *testing.T) {
+	s := getService("gitlab", 59)
+	if err := s.setParams([]byte(`{"account_id":9999999999}`)); err != nil {
+		t.Fatal(err)
+	}
+	testConnectionKeys(t, s)
+}
+
+func TestGetAuthURLRequiresBaseURLOnlyForInstances(t *testing.T) {
+	authURL := func(serviceID string) Response {
+		r := httptest.NewRequest("GET", "/?callback_url=http://localhost", nil)
+		return getAuthURL(Request{r: mux.SetURLVars(r, map[string]string{"service": serviceID})})
+	}
+	if res := authURL("gitlab"); res.status != http.StatusBadRequest || res.content.(error).Error() != "Missing or invalid base_url" {
+		t.Errorf("expected gitlab to require base_url, got %d %v", res.status, res.content)
+	}
+	if res := authURL("asana"); res.status != http.StatusBadRequest || res.content.(error).Error() != "Missing or invalid account_name" {
+		t.Errorf("expected asana to keep the previous auth flow, got %d %v", res.status, res.content)
+	}
+}
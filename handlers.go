@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -29,6 +32,15 @@ func getIntegrations(req Request) Response {
 	return ok(integrations)
 }
 
+func getImportStats(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	stats, err := store.LoadImportStats(workspaceID)
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	return ok(stats)
+}
+
 func getIntegrationPipe(req Request) Response {
 	workspaceID := currentWorkspaceID(req.r)
 	serviceID := mux.Vars(req.r)["service"]
@@ -102,6 +114,14 @@ func putPipeSetup(req Request) Response {
 	if err := json.Unmarshal(req.body, &pipe); err != nil {
 		return internalServerError(err.Error())
 	}
+	if !isValidDeletionPolicy(pipe.DeletionPolicy) {
+		return badRequest("Invalid deletion policy")
+	}
+	for _, field := range pipe.ProjectFields {
+		if !isValidProjectField(field) {
+			return badRequest("Invalid project field " + field)
+		}
+	}
 	if err := pipe.save(); err != nil {
 		return internalServerError(err.Error())
 	}
@@ -139,6 +159,9 @@ func getAuthURL(req Request) Response {
 	if !serviceType.MatchString(serviceID) {
 		return badRequest("Missing or invalid service")
 	}
+	if instanceServices[serviceID] {
+		return getOAuth2InstanceURL(serviceID, req.r.FormValue("base_url"))
+	}
 	if accountName == "" {
 		return badRequest("Missing or invalid account_name")
 	}
@@ -164,6 +187,27 @@ func getAuthURL(req Request) Response {
 	})
 }
 
+// getOAuth2InstanceURL returns the auth URL of a self-managed instance,
+// the URL of the hosted service comes with the integration
+func getOAuth2InstanceURL(serviceID, baseURL string) Response {
+	if baseURL == "" {
+		return badRequest("Missing or invalid base_url")
+	}
+	baseURL, err := normalizeBaseURL(baseURL)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	authURL := oAuth2InstanceURL(serviceID, baseURL)
+	if authURL == "" {
+		return badRequest("Service OAuth config not found")
+	}
+	return ok(struct {
+		AuthURL string `json:"auth_url"`
+	}{
+		authURL,
+	})
+}
+
 func postAuthorization(req Request) Response {
 	workspaceID := currentWorkspaceID(req.r)
 	serviceID := mux.Vars(req.r)["service"]
@@ -188,6 +232,8 @@ func postAuthorization(req Request) Response {
 		authorization.Data, err = oAuth1Exchange(serviceID, payload)
 	case "oauth2":
 		authorization.Data, err = oAuth2Exchange(serviceID, payload)
+	case "apikey":
+		authorization.Data, err = apiKeyExchange(payload)
 	}
 	if err != nil {
 		return internalServerError(err.Error())
@@ -213,8 +259,7 @@ func deleteAuthorization(req Request) Response {
 	if err := authorization.destroy(service); err != nil {
 		return internalServerError(err.Error())
 	}
-	_, err = db.Exec(deletePipeSQL, workspaceID, serviceID+"%")
-	if err != nil {
+	if err := store.DeleteServicePipes(workspaceID, serviceID); err != nil {
 		return internalServerError(err.Error())
 	}
 	return ok(nil)
@@ -335,6 +380,255 @@ func postServicePipeClearConnections(req Request) Response {
 	return noContent()
 }
 
+var errPipeNotConfigured = errors.New("Pipe is not configured")
+
+// pipeService returns the service of a configured pipe with its params set
+func pipeService(workspaceID int, serviceID, pipeID string) (Service, error) {
+	pipe, err := loadPipe(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return nil, err
+	}
+	if pipe == nil {
+		return nil, errPipeNotConfigured
+	}
+	service := getService(serviceID, workspaceID)
+	if err := service.setParams(pipe.ServiceParams); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func pipeServiceError(err error) Response {
+	if err == errPipeNotConfigured {
+		return badRequest(err.Error())
+	}
+	return internalServerError(err.Error())
+}
+
+func getServicePipeConnections(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	service, err := pipeService(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return pipeServiceError(err)
+	}
+	togglNames, err := togglClient.GetObjectNames(currentWorkspaceToken(req.r), workspaceID, togglObjectType(pipeID))
+	if err != nil {
+		return badGateway(err.Error())
+	}
+	items, err := listConnections(service, pipeID, togglNames)
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	return ok(items)
+}
+
+func postServicePipeConnection(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	var item ConnectionItem
+	if err := json.Unmarshal(req.body, &item); err != nil {
+		return badRequest("Invalid payload")
+	}
+	if item.ForeignID == "" || item.TogglID <= 0 {
+		return badRequest("Missing foreign_id or toggl_id")
+	}
+	service, err := pipeService(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return pipeServiceError(err)
+	}
+	togglNames, err := togglClient.GetObjectNames(currentWorkspaceToken(req.r), workspaceID, togglObjectType(pipeID))
+	if err != nil {
+		return badGateway(err.Error())
+	}
+	if _, exists := togglNames[item.TogglID]; !exists {
+		return badRequest("Toggl object not found")
+	}
+	if err := linkManually(service, pipeID, item.ForeignID, item.TogglID); err != nil {
+		return internalServerError(err.Error())
+	}
+	return noContent()
+}
+
+func deleteServicePipeConnection(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	service, err := pipeService(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return pipeServiceError(err)
+	}
+	if err := unlink(service, pipeID, mux.Vars(req.r)["foreign_id"]); err != nil {
+		return internalServerError(err.Error())
+	}
+	return noContent()
+}
+
+func putServicePipeNeverSync(req Request) Response {
+	return setServicePipeNeverSync(req, true)
+}
+
+func deleteServicePipeNeverSync(req Request) Response {
+	return setServicePipeNeverSync(req, false)
+}
+
+func setServicePipeNeverSync(req Request, never bool) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	service, err := pipeService(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return pipeServiceError(err)
+	}
+	if err := setNeverSync(service, pipeID, mux.Vars(req.r)["foreign_id"], never); err != nil {
+		return internalServerError(err.Error())
+	}
+	return noContent()
+}
+
+func getServicePipeSuggestions(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	pipe, err := loadPipe(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	if pipe == nil {
+		return badRequest("Pipe is not configured")
+	}
+	service, err := pipeService(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return pipeServiceError(err)
+	}
+
+	if req.r.FormValue("force") == "true" {
+		if err := clearImportFor(service, connectionPipeID(pipeID)); err != nil {
+			return internalServerError(err.Error())
+		}
+		go func() {
+			if err := pipe.loadAuth(); err != nil {
+				log.Print(err.Error())
+				return
+			}
+			if err := fetchMatchCandidates(pipe); err != nil {
+				log.Print(err.Error())
+			}
+		}()
+		return noContent()
+	}
+
+	token := currentWorkspaceToken(req.r)
+	togglObjects, err := togglClient.GetObjects(token, workspaceID, togglObjectType(pipeID))
+	if err != nil {
+		return badGateway(err.Error())
+	}
+	var togglClients map[int]string
+	if connectionPipeID(pipeID) == projectsPipeID {
+		if togglClients, err = togglClient.GetObjectNames(token, workspaceID, "clients"); err != nil {
+			return badGateway(err.Error())
+		}
+	}
+	suggestions, err := pipeMatchSuggestions(service, pipeID, togglObjects, togglClients)
+	if err == errMatchingNotSupported {
+		return badRequest(err.Error())
+	}
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	if suggestions == nil {
+		return noContent()
+	}
+	return ok(suggestions)
+}
+
+func postServicePipeSuggestions(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	var accepted []MatchSuggestion
+	if err := json.Unmarshal(req.body, &accepted); err != nil {
+		return badRequest("Invalid payload")
+	}
+	service, err := pipeService(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return pipeServiceError(err)
+	}
+	togglNames, err := togglClient.GetObjectNames(currentWorkspaceToken(req.r), workspaceID, togglObjectType(pipeID))
+	if err != nil {
+		return badGateway(err.Error())
+	}
+	for _, suggestion := range accepted {
+		if suggestion.ForeignID == "" {
+			return badRequest("Missing foreign_id")
+		}
+		if _, exists := togglNames[suggestion.TogglID]; !exists {
+			return badRequest(fmt.Sprintf("Toggl object %d not found", suggestion.TogglID))
+		}
+	}
+	for _, suggestion := range accepted {
+		if err := linkManually(service, pipeID, suggestion.ForeignID, suggestion.TogglID); err != nil {
+			return internalServerError(err.Error())
+		}
+	}
+	return noContent()
+}
+
+func getServicePipeRuns(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	runs, err := loadSyncRuns(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	if runs == nil {
+		runs = []*SyncRun{}
+	}
+	return ok(runs)
+}
+
+func postServicePipeRunRevert(req Request) Response {
+	workspaceID := currentWorkspaceID(req.r)
+	serviceID, pipeID := currentServicePipeID(req.r)
+	runID, err := strconv.Atoi(mux.Vars(req.r)["run"])
+	if err != nil {
+		return badRequest("Invalid run")
+	}
+	payload := struct {
+		Mode string `json:"mode"`
+	}{Mode: revertModeArchive}
+	if len(req.body) > 0 {
+		if err := json.Unmarshal(req.body, &payload); err != nil {
+			return badRequest("Invalid payload")
+		}
+	}
+	if payload.Mode != revertModeArchive && payload.Mode != revertModeDelete {
+		return badRequest("Mode must be archive or delete")
+	}
+
+	pipe, err := loadPipe(workspaceID, serviceID, pipeID)
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	if pipe == nil {
+		return badRequest("Pipe is not configured")
+	}
+	run, err := loadSyncRun(workspaceID, serviceID, pipeID, runID)
+	if err != nil {
+		return internalServerError(err.Error())
+	}
+	if run == nil {
+		return notFound("Run not found")
+	}
+	if err := pipe.loadAuth(); err != nil {
+		return badRequest(err.Error())
+	}
+	notifications, err := run.revert(pipe, payload.Mode)
+	if err == errRunAlreadyReverted {
+		return badRequest(err.Error())
+	}
+	if err != nil {
+		return badGateway(err.Error())
+	}
+	return ok(map[string][]string{"notifications": notifications})
+}
+
 func postPipeRun(req Request) Response {
 	workspaceID := currentWorkspaceID(req.r)
 
@@ -367,8 +661,7 @@ func postPipeRun(req Request) Response {
 		}()
 		time.Sleep(500 * time.Millisecond)
 	} else {
-		_, err = db.Exec(queuePipeAsFirstSQL, pipe.workspaceID, pipe.key)
-		if err != nil {
+		if err := store.QueuePipeAsFirst(pipe.workspaceID, pipe.key); err != nil {
 			return internalServerError(err.Error())
 		}
 	}
@@ -380,11 +673,11 @@ func getStatus(req Request) Response {
 		Reasons []string `json:"reasons"`
 	}{}
 
-	if dbIsDown() {
+	if store.IsDown() {
 		resp.Reasons = append(resp.Reasons, "Database is down")
 	}
 
-	if err := pingTogglAPI(); err != nil {
+	if err := togglClient.Ping(); err != nil {
 		resp.Reasons = append(resp.Reasons, err.Error())
 	}
 
@@ -393,3 +686,7 @@ func getStatus(req Request) Response {
 	}
 	return ok(map[string]string{"status": "OK"})
 }
+
+func getMetrics(req Request) Response {
+	return ok(metrics.snapshot())
+}
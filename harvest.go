@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+// harvestAPIURL and harvestIDURL are replaced in tests
+var (
+	harvestAPIURL = "https://api.harvestapp.com"
+	harvestIDURL  = "https://id.getharvest.com"
+)
+
+type HarvestService struct {
+	emptyService
+	workspaceID int
+	*HarvestParams
+	token oauth.Token
+	since *time.Time
+}
+
+// HarvestParams selects a Harvest account, its ID is sent
+// in Harvest-Account-Id header of every API request
+type HarvestParams struct {
+	AccountID int64 `json:"account_id"`
+}
+
+type (
+	harvestReference struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	harvestAccount struct {
+		ID      int64  `json:"id"`
+		Name    string `json:"name"`
+		Product string `json:"product"`
+	}
+
+	harvestUser struct {
+		ID        int    `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		IsActive  bool   `json:"is_active"`
+	}
+
+	harvestClient struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+
+	harvestProject struct {
+		ID         int               `json:"id"`
+		Name       string            `json:"name"`
+		IsActive   bool              `json:"is_active"`
+		IsBillable bool              `json:"is_billable"`
+		Client     *harvestReference `json:"client"`
+		StartsOn   string            `json:"starts_on"`
+		EndsOn     string            `json:"ends_on"`
+		// Budget is in hours when BudgetBy is "project",
+		// HourlyRate applies when BillBy is "Project"
+		Budget     *float64 `json:"budget"`
+		BudgetBy   string   `json:"budget_by"`
+		HourlyRate *float64 `json:"hourly_rate"`
+		BillBy     string   `json:"bill_by"`
+	}
+
+	harvestTaskAssignment struct {
+		ID       int              `json:"id"`
+		IsActive bool             `json:"is_active"`
+		Project  harvestReference `json:"project"`
+		Task     harvestReference `json:"task"`
+	}
+
+	harvestTimeEntry struct {
+		ID          int               `json:"id"`
+		SpentDate   string            `json:"spent_date"`
+		Hours       float64           `json:"hours"`
+		Notes       string            `json:"notes"`
+		IsRunning   bool              `json:"is_running"`
+		Billable    bool              `json:"billable"`
+		StartedTime string            `json:"started_time"`
+		User        harvestReference  `json:"user"`
+		Project     harvestReference  `json:"project"`
+		Task        *harvestReference `json:"task"`
+	}
+
+	harvestTimeEntryRequest struct {
+		UserID    int     `json:"user_id,omitempty"`
+		ProjectID int     `json:"project_id"`
+		TaskID    int     `json:"task_id"`
+		SpentDate string  `json:"spent_date"`
+		Hours     float64 `json:"hours"`
+		Notes     string  `json:"notes"`
+	}
+)
+
+func (s *HarvestService) Name() string {
+	return "harvest"
+}
+
+func (s *HarvestService) WorkspaceID() int {
+	return s.workspaceID
+}
+
+func (s *HarvestService) keyFor(objectType string) string {
+	if s.HarvestParams == nil {
+		return fmt.Sprintf("harvest:account:%s", objectType)
+	}
+	return fmt.Sprintf("harvest:account:%d:%s", s.AccountID, objectType)
+}
+
+func (s *HarvestService) setSince(since *time.Time) {
+	s.since = since
+}
+
+func (s *HarvestService) setParams(b []byte) error {
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s.HarvestParams == nil || s.AccountID == 0 {
+		return errors.New("account_id must be present")
+	}
+	return nil
+}
+
+func (s *HarvestService) setAuthData(b []byte) error {
+	return json.Unmarshal(b, &s.token)
+}
+
+func (s *HarvestService) do(method, u string, body interface{}) ([]byte, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Toggl Pipes (support@toggl.com)")
+	if s.HarvestParams != nil {
+		req.Header.Set("Harvest-Account-Id", strconv.FormatInt(s.AccountID, 10))
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	resp, err := t.Client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("harvest: %s %s failed with status code %d: %s", method, u, resp.StatusCode, b)
+	}
+	return b, nil
+}
+
+// getPages requests all pages of path, Harvest returns objects of a page
+// under the name of the resource, key, and the number of the next page
+func (s *HarvestService) getPages(path, key string, params url.Values, handle func(objects json.RawMessage) error) error {
+	for page := 1; page > 0; {
+		params.Set("page", strconv.Itoa(page))
+		b, err := s.do("GET", harvestAPIURL+path+"?"+params.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		var response map[string]json.RawMessage
+		if err := json.Unmarshal(b, &response); err != nil {
+			return err
+		}
+		if err := handle(response[key]); err != nil {
+			return err
+		}
+		page = 0
+		if next, ok := response["next_page"]; ok {
+			json.Unmarshal(next, &page)
+		}
+	}
+	return nil
+}
+
+func (s *HarvestService) updatedSince() url.Values {
+	params := url.Values{}
+	if s.since != nil && !s.since.IsZero() {
+		params.Set("updated_since", s.since.UTC().Format(time.RFC3339))
+	}
+	return params
+}
+
+// Map Harvest accounts of the authorized user to local accounts
+func (s *HarvestService) Accounts() ([]*Account, error) {
+	b, err := s.do("GET", harvestIDURL+"/api/v2/accounts", nil)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Accounts []harvestAccount `json:"accounts"`
+	}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	for _, object := range response.Accounts {
+		if object.Product != "harvest" {
+			continue
+		}
+		accounts = append(accounts, &Account{ID: object.ID, Name: object.Name})
+	}
+	return accounts, nil
+}
+
+// Map active Harvest users to local users
+func (s *HarvestService) Users() ([]*User, error) {
+	var users []*User
+	err := s.getPages("/v2/users", "users", url.Values{"is_active": {"true"}}, func(objects json.RawMessage) error {
+		var foreignObjects []harvestUser
+		if err := json.Unmarshal(objects, &foreignObjects); err != nil {
+			return err
+		}
+		for _, object := range foreignObjects {
+			users = append(users, &User{
+				ForeignID: strconv.Itoa(object.ID),
+				Name:      strings.TrimSpace(object.FirstName + " " + object.LastName),
+				Email:     object.Email,
+			})
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (s *HarvestService) Clients() ([]*Client, error) {
+	var clients []*Client
+	err := s.getPages("/v2/clients", "clients", url.Values{}, func(objects json.RawMessage) error {
+		var foreignObjects []harvestClient
+		if err := json.Unmarshal(objects, &foreignObjects); err != nil {
+			return err
+		}
+		for _, object := range foreignObjects {
+			clients = append(clients, &Client{
+				ForeignID: strconv.Itoa(object.ID),
+				Name:      object.Name,
+			})
+		}
+		return nil
+	})
+	return clients, err
+}
+
+func (s *HarvestService) Projects() ([]*Project, error) {
+	var projects []*Project
+	err := s.getPages("/v2/projects", "projects", url.Values{}, func(objects json.RawMessage) error {
+		var foreignObjects []harvestProject
+		if err := json.Unmarshal(objects, &foreignObjects); err != nil {
+			return err
+		}
+		for _, object := range foreignObjects {
+			project := &Project{
+				ForeignID: strconv.Itoa(object.ID),
+				Name:      object.Name,
+				Active:    object.IsActive,
+				Billable:  object.IsBillable,
+				StartDate: object.StartsOn,
+				DueDate:   object.EndsOn,
+			}
+			if object.Client != nil {
+				project.foreignClientID = strconv.Itoa(object.Client.ID)
+			}
+			if object.Budget != nil && object.BudgetBy == "project" {
+				project.EstimatedHours = int(math.Round(*object.Budget))
+			}
+			if object.HourlyRate != nil && object.BillBy == "Project" {
+				project.Rate = *object.HourlyRate
+			}
+			projects = append(projects, project)
+		}
+		return nil
+	})
+	return projects, err
+}
+
+// Map Harvest task assignments to tasks, Harvest tasks are shared by
+// projects so foreign IDs combine task and project IDs like in Freshbooks
+func (s *HarvestService) Tasks() ([]*Task, error) {
+	var tasks []*Task
+	err := s.getPages("/v2/task_assignments", "task_assignments", s.updatedSince(), func(objects json.RawMessage) error {
+		var foreignObjects []harvestTaskAssignment
+		if err := json.Unmarshal(objects, &foreignObjects); err != nil {
+			return err
+		}
+		for _, object := range foreignObjects {
+			tasks = append(tasks, &Task{
+				ForeignID:        fmt.Sprintf("%d-%d", object.Task.ID, object.Project.ID),
+				Name:             object.Task.Name,
+				Active:           object.IsActive,
+				foreignProjectID: strconv.Itoa(object.Project.ID),
+			})
+		}
+		return nil
+	})
+	return tasks, err
+}
+
+// Map stopped Harvest time entries to time entries, entries without
+// a start time start at the beginning of the day they were spent on
+func (s *HarvestService) TimeEntries() ([]*TimeEntry, error) {
+	var timeEntries []*TimeEntry
+	err := s.getPages("/v2/time_entries", "time_entries", s.updatedSince(), func(objects json.RawMessage) error {
+		var foreignObjects []harvestTimeEntry
+		if err := json.Unmarshal(objects, &foreignObjects); err != nil {
+			return err
+		}
+		for _, object := range foreignObjects {
+			if object.IsRunning {
+				continue
+			}
+			start, err := time.Parse("2006-01-02", object.SpentDate)
+			if err != nil {
+				return err
+			}
+			if startedTime, err := time.Parse("3:04pm", object.StartedTime); err == nil {
+				start = start.Add(time.Duration(startedTime.Hour())*time.Hour + time.Duration(startedTime.Minute())*time.Minute)
+			}
+			entry := &TimeEntry{
+				Start:             start.Format(time.RFC3339),
+				DurationInSeconds: int(math.Round(object.Hours * 3600)),
+				Description:       object.Notes,
+				Billable:          object.Billable,
+				foreignID:         strconv.Itoa(object.ID),
+				foreignUserID:     strconv.Itoa(object.User.ID),
+				foreignProjectID:  strconv.Itoa(object.Project.ID),
+			}
+			if object.Task != nil {
+				entry.foreignTaskID = fmt.Sprintf("%d-%d", object.Task.ID, object.Project.ID)
+			}
+			timeEntries = append(timeEntries, entry)
+		}
+		return nil
+	})
+	return timeEntries, err
+}
+
+// ExportTimeEntry creates or updates the time entry as duration in hours,
+// Harvest requires every time entry to have a project and a task
+func (s *HarvestService) ExportTimeEntry(t *TimeEntry) (int, error) {
+	if t.DurationInSeconds <= 0 {
+		// running entries are exported once they are stopped
+		return numberStrToInt(t.foreignID), nil
+	}
+	taskID := numberStrToInt(t.foreignTaskID)
+	projectID := numberStrToInt(t.foreignProjectID)
+	if taskID == 0 || projectID == 0 {
+		return 0, fmt.Errorf("task not provided for time entry '%s'", t.Description)
+	}
+	start, err := time.Parse(time.RFC3339, t.Start)
+	if err != nil {
+		return 0, err
+	}
+	entry := harvestTimeEntryRequest{
+		UserID:    numberStrToInt(t.foreignUserID),
+		ProjectID: projectID,
+		TaskID:    taskID,
+		SpentDate: start.Format("2006-01-02"),
+		Hours:     math.Round(float64(t.DurationInSeconds)/36) / 100,
+		Notes:     t.Description,
+	}
+	method, u := "POST", harvestAPIURL+"/v2/time_entries"
+	if foreignID := numberStrToInt(t.foreignID); foreignID > 0 {
+		method, u = "PATCH", fmt.Sprintf("%s/%d", u, foreignID)
+	}
+	b, err := s.do(method, u, entry)
+	if err != nil {
+		return 0, err
+	}
+	var response harvestTimeEntry
+	if err := json.Unmarshal(b, &response); err != nil {
+		return 0, err
+	}
+	return response.ID, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newHarvestTestServer(t *testing.T, posted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v2/accounts" && r.Header.Get("Harvest-Account-Id") != "123" {
+			t.Errorf("expected account header in request %s", r.URL)
+		}
+		switch r.Method + " " + r.URL.Path + "?" + r.URL.Query().Get("page") {
+		case "GET /api/v2/accounts?":
+			w.Write([]byte(`{"accounts":[{"id":123,"name":"Agency","product":"harvest"},{"id":456,"name":"Agency","product":"forecast"}]}`))
+		case "GET /v2/projects?1":
+			w.Write([]byte(`{"projects":[{"id":1,"name":"Website","is_active":true,"is_billable":true,"client":{"id":5,"name":"ACME"}}],"next_page":2}`))
+		case "GET /v2/projects?2":
+			w.Write([]byte(`{"projects":[{"id":2,"name":"Internal","is_active":false,"is_billable":false,"client":null}],"next_page":null}`))
+		case "GET /v2/task_assignments?1":
+			w.Write([]byte(`{"task_assignments":[{"id":70,"is_active":true,"project":{"id":1,"name":"Website"},"task":{"id":8,"name":"Design"}}],"next_page":null}`))
+		case "GET /v2/time_entries?1":
+			w.Write([]byte(`{"time_entries":[
+				{"id":900,"spent_date":"2026-10-01","hours":1.5,"notes":"Mockups","is_running":false,"billable":true,"started_time":"9:30am","user":{"id":3},"project":{"id":1},"task":{"id":8}},
+				{"id":901,"spent_date":"2026-10-02","hours":0.2,"notes":"","is_running":true,"user":{"id":3},"project":{"id":1},"task":{"id":8}}
+			],"next_page":null}`))
+		case "POST /v2/time_entries?":
+			b, _ := ioutil.ReadAll(r.Body)
+			*posted = append(*posted, string(b))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":902}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestHarvestService(t *testing.T) {
+	var posted []string
+	ts := newHarvestTestServer(t, &posted)
+	defer ts.Close()
+	defer func(apiURL, idURL string) {
+		harvestAPIURL, harvestIDURL = apiURL, idURL
+	}(harvestAPIURL, harvestIDURL)
+	harvestAPIURL, harvestIDURL = ts.URL, ts.URL
+
+	s := getService("harvest", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := s.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].ID != 123 {
+		t.Fatalf("expected only Harvest accounts, got %+v", accounts)
+	}
+	if err := s.setParams([]byte(`{"account_id":123}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	projects, err := s.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects[0].foreignClientID != "5" || !projects[0].Billable || projects[1].Active {
+		t.Errorf("unexpected projects %+v", projects)
+	}
+
+	tasks, err := s.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ForeignID != "8-1" || tasks[0].foreignProjectID != "1" {
+		t.Errorf("unexpected tasks %+v", tasks)
+	}
+
+	timeEntries, err := s.TimeEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeEntries) != 1 {
+		t.Fatalf("expected running entry to be skipped, got %+v", timeEntries)
+	}
+	entry := timeEntries[0]
+	if entry.Start != "2026-10-01T09:30:00Z" || entry.DurationInSeconds != 5400 || entry.foreignTaskID != "8-1" || entry.foreignUserID != "3" {
+		t.Errorf("unexpected time entry %+v", entry)
+	}
+
+	id, err := s.ExportTimeEntry(&TimeEntry{
+		Start: "2026-10-03T10:00:00+02:00", DurationInSeconds: 2700, Description: "Review",
+		foreignTaskID: "8", foreignProjectID: "1", foreignUserID: "3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 902 || len(posted) != 1 || posted[0] != `{"user_id":3,"project_id":1,"task_id":8,"spent_date":"2026-10-03","hours":0.75,"notes":"Review"}` {
+		t.Errorf("unexpected export %d %v", id, posted)
+	}
+}
+
+func TestHarvestConnectionKeys(t *testing.T) {
+	s := getService("harvest", 60)
+	if err := s.setParams([]byte(`{"account_id":9999999999}`)); err != nil {
+		t.Fatal(err)
+	}
+	testConnectionKeys(t, s)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -19,21 +20,8 @@ const todoPipeId = "todolists"
 var ErrNotSupported = errors.New("service does not support")
 
 func getAccounts(s Service) (*AccountsResponse, error) {
-	var result []byte
-	rows, err := db.Query(`
-		SELECT data FROM imports
-		WHERE workspace_id = $1 AND key = $2
-		ORDER by created_at DESC
-		LIMIT 1
-	`, s.WorkspaceID(), s.keyFor("accounts"))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	if !rows.Next() {
-		return nil, rows.Err()
-	}
-	if err := rows.Scan(&result); err != nil {
+	result, err := store.LoadImport(s.WorkspaceID(), s.keyFor("accounts"))
+	if err != nil || result == nil {
 		return nil, err
 	}
 
@@ -58,10 +46,7 @@ func fetchAccounts(s Service) error {
 		bugsnag.Notify(err)
 		return err
 	}
-	_, err = db.Exec(`
-    INSERT INTO imports(workspace_id, key, data, created_at)
-    VALUES($1, $2, $3, NOW())
-  	`, s.WorkspaceID(), s.keyFor("accounts"), b)
+	err = saveImport(s.WorkspaceID(), s.keyFor("accounts"), b)
 	if err != nil {
 		bugsnag.Notify(err)
 		return err
@@ -70,32 +55,11 @@ func fetchAccounts(s Service) error {
 }
 
 func clearImportFor(s Service, pipeID string) error {
-	_, err := db.Exec(`
-	    DELETE FROM imports
-	    WHERE workspace_id = $1 AND key = $2
-	`, s.WorkspaceID(), s.keyFor(pipeID))
-	return err
+	return store.DeleteImports(s.WorkspaceID(), s.keyFor(pipeID))
 }
 
 func getObject(s Service, pipeID string) ([]byte, error) {
-	var result []byte
-	rows, err := db.Query(`
-		SELECT data FROM imports
-		WHERE workspace_id = $1 AND key = $2
-		ORDER by created_at DESC
-		LIMIT 1
-	`, s.WorkspaceID(), s.keyFor(pipeID))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	if !rows.Next() {
-		return nil, rows.Err()
-	}
-	if err := rows.Scan(&result); err != nil {
-		return nil, err
-	}
-	return result, nil
+	return store.LoadImport(s.WorkspaceID(), s.keyFor(pipeID))
 }
 
 func getUsers(s Service) (*UsersResponse, error) {
@@ -168,43 +132,54 @@ func postUsers(p *Pipe) error {
 		return errors.New("service users not found")
 	}
 
-	var selector Selector
-	if err := json.Unmarshal(p.payload, &selector); err != nil {
+	var connection *Connection
+	if connection, err = loadConnection(s, usersPipeID); err != nil {
 		return err
 	}
 
-	var users []*User
-	for _, userID := range selector.IDs {
-		for _, user := range usersResponse.Users {
-			if user.ForeignID == strconv.Itoa(userID) {
-				user.SendInvitation = selector.SendInvites
-				users = append(users, user)
-			}
+	// automatic runs have no selection and only sync users lifecycle
+	var usersImport UsersImport
+	if len(p.payload) > 0 {
+		var selector Selector
+		if err := json.Unmarshal(p.payload, &selector); err != nil {
+			return err
 		}
-	}
 
-	b, err := postPipesAPI(p.authorization.WorkspaceToken, usersPipeID, usersRequest{Users: users})
-	if err != nil {
-		return err
-	}
+		var users []*User
+		for _, userID := range selector.IDs {
+			for _, user := range usersResponse.Users {
+				if user.ForeignID == strconv.Itoa(userID) {
+					user.SendInvitation = selector.SendInvites
+					users = append(users, user)
+				}
+			}
+		}
 
-	var usersImport UsersImport
-	if err := json.Unmarshal(b, &usersImport); err != nil {
-		return err
+		b, err := togglClient.PostPipesAPI(p.authorization.WorkspaceToken, usersPipeID, usersRequest{Users: users})
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &usersImport); err != nil {
+			return err
+		}
+		for _, user := range usersImport.WorkspaceUsers {
+			connection.Data[user.ForeignID] = user.ID
+		}
 	}
 
-	var connection *Connection
-	if connection, err = loadConnection(s, usersPipeID); err != nil {
-		return err
-	}
-	for _, user := range usersImport.WorkspaceUsers {
-		connection.Data[user.ForeignID] = user.ID
+	notifications := usersImport.Notifications
+	if p.UsersLifecycle {
+		lifecycleNotifications, err := syncUsersLifecycle(p, s, usersResponse, connection)
+		if err != nil {
+			return err
+		}
+		notifications = append(notifications, lifecycleNotifications...)
 	}
 	if err := connection.save(); err != nil {
 		return err
 	}
 
-	p.PipeStatus.complete(usersPipeID, usersImport.Notifications, usersImport.Count())
+	p.PipeStatus.complete(usersPipeID, notifications, usersImport.Count())
 	return nil
 }
 
@@ -220,31 +195,50 @@ func postClients(p *Pipe) error {
 	if clientsResponse == nil {
 		return errors.New("service clients not found")
 	}
-	clients := clientRequest{
-		Clients: clientsResponse.Clients,
-	}
 	if len(clientsResponse.Clients) == 0 {
 		return nil
 	}
-	b, err := postPipesAPI(p.authorization.WorkspaceToken, clientsPipeID, clients)
-	if err != nil {
-		return err
-	}
-	var clientsImport ClientsImport
-	if err := json.Unmarshal(b, &clientsImport); err != nil {
-		return err
-	}
 	var connection *Connection
 	if connection, err = loadConnection(service, clientsPipeID); err != nil {
 		return err
 	}
-	for _, client := range clientsImport.Clients {
-		connection.Data[client.ForeignID] = client.ID
+	clients := clientsResponse.Clients
+	repair := &linkRepair{
+		post: func(from, to int) ([]byte, error) {
+			if err := p.syncRun.snapshot(p, clientsPipeID, clientObjects(clients[from:to])); err != nil {
+				return nil, err
+			}
+			return togglClient.PostPipesAPI(p.authorization.WorkspaceToken, clientsPipeID, clientRequest{Clients: clients[from:to]})
+		},
+		handle: func(b []byte) ([]string, int, error) {
+			var clientsImport ClientsImport
+			if err := json.Unmarshal(b, &clientsImport); err != nil {
+				return nil, 0, err
+			}
+			for _, client := range clientsImport.Clients {
+				if err := p.syncRun.record(clientsPipeID, clientsPipeID, client.ForeignID, connection.Data[client.ForeignID],
+					TogglObject{ID: client.ID, Name: client.Name}); err != nil {
+					return nil, 0, err
+				}
+				connection.Data[client.ForeignID] = client.ID
+			}
+			return clientsImport.Notifications, clientsImport.Count(), nil
+		},
+		unlink: func(i int) func() {
+			return unlinkObject(connection, clients[i].ForeignID, &clients[i].ID)
+		},
+		describe: func(i int) string {
+			return fmt.Sprintf("Client '%s'", clients[i].Name)
+		},
+	}
+	if err := repair.run(len(clients)); err != nil {
+		return err
 	}
 	if err := connection.save(); err != nil {
 		return err
 	}
-	p.PipeStatus.complete(clientsPipeID, clientsImport.Notifications, clientsImport.Count())
+	p.PipeStatus.RepairedLinks += repair.repairedCount()
+	p.PipeStatus.complete(clientsPipeID, repair.notifications(), repair.count)
 	return nil
 }
 
@@ -260,30 +254,76 @@ func postProjects(p *Pipe) error {
 	if projectsResponse == nil {
 		return errors.New("service projects not found")
 	}
-	projects := projectRequest{
-		Projects: projectsResponse.Projects,
-		SupportsClient: projectsResponse.SupportsClient,
+	var connection *Connection
+	if connection, err = loadConnection(s, projectsPipeID); err != nil {
+		return err
 	}
-
-	b, err := postPipesAPI(p.authorization.WorkspaceToken, projectsPipeID, projects)
+	projects, vanished, err := applyProjectDeletions(p, projectsResponse)
 	if err != nil {
 		return err
 	}
-	var projectsImport ProjectsImport
-	if err := json.Unmarshal(b, &projectsImport); err != nil {
-		return err
+	var memberNotifications []string
+	if p.ProjectMembers {
+		if memberNotifications, err = linkProjectMembers(s, projects); err != nil {
+			return err
+		}
 	}
-	var connection *Connection
-	if connection, err = loadConnection(s, projectsPipeID); err != nil {
+	repair := &linkRepair{
+		post: func(from, to int) ([]byte, error) {
+			if err := p.syncRun.snapshot(p, projectsPipeID, projectObjects(projects[from:to])); err != nil {
+				return nil, err
+			}
+			return togglClient.PostPipesAPI(p.authorization.WorkspaceToken, projectsPipeID, projectRequest{
+				Projects:       projects[from:to],
+				SupportsClient: projectsResponse.SupportsClient,
+				Fields:         p.requestFields(),
+			})
+		},
+		handle: func(b []byte) ([]string, int, error) {
+			var projectsImport ProjectsImport
+			if err := json.Unmarshal(b, &projectsImport); err != nil {
+				return nil, 0, err
+			}
+			for _, project := range projectsImport.Projects {
+				if err := p.syncRun.record(projectsPipeID, projectsPipeID, project.ForeignID, connection.Data[project.ForeignID],
+					TogglObject{ID: project.ID, Name: project.Name, Active: project.Active}); err != nil {
+					return nil, 0, err
+				}
+				connection.Data[project.ForeignID] = project.ID
+			}
+			return projectsImport.Notifications, projectsImport.Count(), nil
+		},
+		unlink: func(i int) func() {
+			if isVanished(vanished, projects[i].ForeignID) {
+				delete(connection.Data, projects[i].ForeignID)
+				return nil
+			}
+			return unlinkObject(connection, projects[i].ForeignID, &projects[i].ID)
+		},
+		describe: func(i int) string {
+			return fmt.Sprintf("Project '%s'", projects[i].Name)
+		},
+	}
+	if err := repair.run(len(projects)); err != nil {
 		return err
 	}
-	for _, project := range projectsImport.Projects {
-		connection.Data[project.ForeignID] = project.ID
+	notifications := append(repair.notifications(), memberNotifications...)
+	if p.detectsDeletionsOf(projectsPipeID) {
+		fetched := make([]string, 0, len(projectsResponse.Projects))
+		for _, project := range projectsResponse.Projects {
+			fetched = append(fetched, project.ForeignID)
+		}
+		deletionNotifications, err := settleDeletions(s, projectsPipeID, "Project", connection, vanished, fetched)
+		if err != nil {
+			return err
+		}
+		notifications = append(notifications, deletionNotifications...)
 	}
 	if err := connection.save(); err != nil {
 		return err
 	}
-	p.PipeStatus.complete(projectsPipeID, projectsImport.Notifications, projectsImport.Count())
+	p.PipeStatus.RepairedLinks += repair.repairedCount()
+	p.PipeStatus.complete(projectsPipeID, notifications, repair.count)
 	return nil
 }
 
@@ -299,33 +339,24 @@ func postTodoLists(p *Pipe) error {
 	if tasksResponse == nil {
 		return errors.New("service tasks not found")
 	}
-	trs, err := adjustRequestSize(tasksResponse.Tasks, 1)
+	tasks, vanished, err := applyTaskDeletions(p, tasksResponse)
 	if err != nil {
 		return err
 	}
-	var notifications []string
-	var count int
-	for _, tr := range trs {
-		b, err := postPipesAPI(p.authorization.WorkspaceToken, tasksPipeId, tr)
-		if err != nil {
-			return err
-		}
-		var tasksImport TasksImport
-		if err := json.Unmarshal(b, &tasksImport); err != nil {
-			return err
-		}
-		connection, err := loadConnection(s, todoPipeId)
+	trs, err := tasksResponse.requests(tasks)
+	if err != nil {
+		return err
+	}
+	notifications, count, err := postTaskRequests(p, s, todoPipeId, trs, vanished)
+	if err != nil {
+		return err
+	}
+	if p.detectsDeletionsOf(todoPipeId) {
+		deletionNotifications, err := settleTaskDeletions(s, todoPipeId, tasksResponse.Tasks, vanished)
 		if err != nil {
 			return err
 		}
-		for _, task := range tasksImport.Tasks {
-			connection.Data[task.ForeignID] = task.ID
-		}
-		if err := connection.save(); err != nil {
-			return err
-		}
-		notifications = append(notifications, tasksImport.Notifications...)
-		count += tasksImport.Count()
+		notifications = append(notifications, deletionNotifications...)
 	}
 	p.PipeStatus.complete(todoPipeId, notifications, count)
 	return nil
@@ -343,37 +374,83 @@ func postTasks(p *Pipe) error {
 	if tasksResponse == nil {
 		return errors.New("service tasks not found")
 	}
-	trs, err := adjustRequestSize(tasksResponse.Tasks, 1)
+	tasks, vanished, err := applyTaskDeletions(p, tasksResponse)
 	if err != nil {
 		return err
 	}
+	trs, err := tasksResponse.requests(tasks)
+	if err != nil {
+		return err
+	}
+	notifications, count, err := postTaskRequests(p, s, tasksPipeId, trs, vanished)
+	if err != nil {
+		return err
+	}
+	if p.detectsDeletionsOf(tasksPipeId) {
+		deletionNotifications, err := settleTaskDeletions(s, tasksPipeId, tasksResponse.Tasks, vanished)
+		if err != nil {
+			return err
+		}
+		notifications = append(notifications, deletionNotifications...)
+	}
+	p.PipeStatus.complete(p.ID, notifications, count)
+	return nil
+}
+
+// postTaskRequests posts task batches and saves their connections
+// to the connection of pipeID after each batch
+func postTaskRequests(p *Pipe, s Service, pipeID string, trs []*taskRequest, vanished []vanishedLink) ([]string, int, error) {
 	var notifications []string
 	var count int
 	for _, tr := range trs {
-		b, err := postPipesAPI(p.authorization.WorkspaceToken, tasksPipeId, tr)
+		connection, err := loadConnection(s, pipeID)
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
-		var tasksImport TasksImport
-		if err := json.Unmarshal(b, &tasksImport); err != nil {
-			return err
-		}
-		connection, err := loadConnection(s, tasksPipeId)
-		if err != nil {
-			return err
+		tasks := tr.Tasks
+		repair := &linkRepair{
+			post: func(from, to int) ([]byte, error) {
+				if err := p.syncRun.snapshot(p, tasksPipeId, taskObjects(tasks[from:to])); err != nil {
+					return nil, err
+				}
+				return togglClient.PostPipesAPI(p.authorization.WorkspaceToken, tasksPipeId, taskRequest{Tasks: tasks[from:to], Fields: tr.Fields})
+			},
+			handle: func(b []byte) ([]string, int, error) {
+				var tasksImport TasksImport
+				if err := json.Unmarshal(b, &tasksImport); err != nil {
+					return nil, 0, err
+				}
+				for _, task := range tasksImport.Tasks {
+					if err := p.syncRun.record(tasksPipeId, pipeID, task.ForeignID, connection.Data[task.ForeignID],
+						TogglObject{ID: task.ID, Name: task.Name, Active: task.Active, ProjectID: task.ProjectID}); err != nil {
+						return nil, 0, err
+					}
+					connection.Data[task.ForeignID] = task.ID
+				}
+				return tasksImport.Notifications, tasksImport.Count(), nil
+			},
+			unlink: func(i int) func() {
+				if isVanished(vanished, tasks[i].ForeignID) {
+					delete(connection.Data, tasks[i].ForeignID)
+					return nil
+				}
+				return unlinkObject(connection, tasks[i].ForeignID, &tasks[i].ID)
+			},
+			describe: func(i int) string {
+				return fmt.Sprintf("Task '%s'", tasks[i].Name)
+			},
 		}
-
-		for _, task := range tasksImport.Tasks {
-			connection.Data[task.ForeignID] = task.ID
+		if err := repair.run(len(tasks)); err != nil {
+			return nil, 0, err
 		}
 		if err := connection.save(); err != nil {
-			return err
+			return nil, 0, err
 		}
-		notifications = append(notifications, tasksImport.Notifications...)
-		count += tasksImport.Count()
+		p.PipeStatus.RepairedLinks += repair.repairedCount()
+		notifications = append(notifications, repair.notifications()...)
+		count += repair.count
 	}
-	p.PipeStatus.complete(p.ID, notifications, count)
-	return nil
+	return notifications, count, nil
 }
 
 func saveObject(p *Pipe, pipeID string, obj interface{}) error {
@@ -386,10 +463,7 @@ func saveObject(p *Pipe, pipeID string, obj interface{}) error {
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`
-	  INSERT INTO imports(workspace_id, key, data, created_at)
-    VALUES($1, $2, $3, NOW())
-	`, p.workspaceID, s.keyFor(pipeID), b)
+	err = saveImport(p.workspaceID, s.keyFor(pipeID), b)
 	if err != nil {
 		bugsnag.Notify(err)
 		return err
@@ -409,6 +483,28 @@ func fetchUsers(p *Pipe) error {
 		response.Error = err.Error()
 		return err
 	}
+	neverSync, err := loadNeverSync(s, usersPipeID)
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	response.Users = make([]*User, 0, len(users))
+	for _, user := range users {
+		if _, excluded := neverSync.Data[user.ForeignID]; !excluded {
+			response.Users = append(response.Users, user)
+		}
+	}
+	if !p.UsersLifecycle {
+		return nil
+	}
+	if response.Deactivated, err = removedUsers(s, users); err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	if response.Groups, err = fetchGroups(s); err != nil {
+		response.Error = err.Error()
+		return err
+	}
 	return nil
 }
 
@@ -458,14 +554,25 @@ func fetchProjects(p *Pipe) error {
 	if err != nil {
 		return err
 	}
-	service.setSince(p.lastSync)
+	service.setSince(p.fetchSince(projectsPipeID))
 	projects, err := service.Projects()
 	if err != nil {
 		response.Error = err.Error()
 		return err
 	}
 
-	response.Projects = trimSpacesFromName(projects)
+	neverSync, err := loadNeverSync(service, projectsPipeID)
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	response.Fields = availableProjectFields(projects)
+	response.Projects = make([]*Project, 0, len(projects))
+	for _, project := range p.withProjectFields(trimSpacesFromName(projects)) {
+		if _, excluded := neverSync.Data[project.ForeignID]; !excluded {
+			response.Projects = append(response.Projects, project)
+		}
+	}
 
 	var clientConnections, projectConnections *Connection
 	if clientConnections, err = loadConnection(service, clientsPipeID); err != nil {
@@ -481,7 +588,19 @@ func fetchProjects(p *Pipe) error {
 		project.ID = projectConnections.Data[project.ForeignID]
 		project.ClientID = clientConnections.Data[project.foreignClientID]
 	}
+	if p.ProjectMembers {
+		if err := fetchProjectMembers(service, response.Projects); err != nil {
+			response.Error = err.Error()
+			return err
+		}
+	}
 
+	if p.detectsDeletionsOf(projectsPipeID) {
+		if response.Vanished, err = vanishedProjects(service, response.Projects, projectConnections); err != nil {
+			response.Error = err.Error()
+			return err
+		}
+	}
 	return nil
 }
 
@@ -502,7 +621,7 @@ func fetchTodoLists(p *Pipe) error {
 	if err != nil {
 		return err
 	}
-	service.setSince(p.lastSync)
+	service.setSince(p.fetchSince(todoPipeId))
 	tasks, err := service.TodoLists()
 	if err != nil {
 		response.Error = err.Error()
@@ -520,8 +639,17 @@ func fetchTodoLists(p *Pipe) error {
 		return err
 	}
 
+	neverSync, err := loadNeverSync(service, todoPipeId)
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+
 	response.Tasks = make([]*Task, 0)
 	for _, task := range tasks {
+		if _, excluded := neverSync.Data[task.ForeignID]; excluded {
+			continue
+		}
 		id := taskConnections.Data[task.ForeignID]
 		if (id > 0) || task.Active {
 			task.ID = id
@@ -529,6 +657,13 @@ func fetchTodoLists(p *Pipe) error {
 			response.Tasks = append(response.Tasks, task)
 		}
 	}
+
+	if p.detectsDeletionsOf(todoPipeId) {
+		if response.Vanished, err = vanishedTasks(service, todoPipeId, tasks, taskConnections); err != nil {
+			response.Error = err.Error()
+			return err
+		}
+	}
 	return nil
 }
 
@@ -549,13 +684,13 @@ func fetchTasks(p *Pipe) error {
 	if err != nil {
 		return err
 	}
-	service.setSince(p.lastSync)
+	service.setSince(p.fetchSince(tasksPipeId))
 	tasks, err := service.Tasks()
 	if err != nil {
 		response.Error = err.Error()
 		return err
 	}
-	var projectConnections, taskConnections *Connection
+	var projectConnections, taskConnections, userConnections *Connection
 
 	if projectConnections, err = loadConnection(service, projectsPipeID); err != nil {
 		response.Error = err.Error()
@@ -565,20 +700,48 @@ func fetchTasks(p *Pipe) error {
 		response.Error = err.Error()
 		return err
 	}
+	if userConnections, err = loadConnection(service, usersPipeID); err != nil {
+		response.Error = err.Error()
+		return err
+	}
 
+	neverSync, err := loadNeverSync(service, tasksPipeId)
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+
+	response.Fields = availableTaskFields(tasks)
 	response.Tasks = make([]*Task, 0)
 	for _, task := range tasks {
+		if _, excluded := neverSync.Data[task.ForeignID]; excluded {
+			continue
+		}
 		id := taskConnections.Data[task.ForeignID]
 		if (id > 0) || task.Active {
 			task.ID = id
 			task.ProjectID = projectConnections.Data[task.foreignProjectID]
+			if task.foreignUserID != "" {
+				task.UserID = userConnections.Data[task.foreignUserID]
+				if task.UserID == 0 {
+					response.UnlinkedAssignees = append(response.UnlinkedAssignees, task.ForeignID)
+				}
+			}
 			response.Tasks = append(response.Tasks, task)
 		}
 	}
+
+	if p.detectsDeletionsOf(tasksPipeId) {
+		if response.Vanished, err = vanishedTasks(service, tasksPipeId, tasks, taskConnections); err != nil {
+			response.Error = err.Error()
+			return err
+		}
+	}
 	return nil
 }
 
-func adjustRequestSize(tasks []*Task, split int) ([]*taskRequest, error) {
+// adjustRequestSize splits tasks into requests which stay below maxPayloadSizeBytes
+func adjustRequestSize(tasks []*Task, fields []string, split int) ([]*taskRequest, error) {
 	var trs []*taskRequest
 	var size int
 	size = len(tasks) / split
@@ -590,7 +753,8 @@ func adjustRequestSize(tasks []*Task, split int) ([]*taskRequest, error) {
 		}
 		if endIndex > startIndex {
 			t := taskRequest{
-				Tasks: tasks[startIndex:endIndex],
+				Tasks:  tasks[startIndex:endIndex],
+				Fields: fields,
 			}
 			trs = append(trs, &t)
 		}
@@ -601,7 +765,7 @@ func adjustRequestSize(tasks []*Task, split int) ([]*taskRequest, error) {
 			return nil, err
 		}
 		if len(j) > maxPayloadSizeBytes {
-			return adjustRequestSize(tasks, split+1)
+			return adjustRequestSize(tasks, fields, split+1)
 		}
 	}
 	return trs, nil
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -20,7 +21,7 @@ func TestTaskSplitting(t *testing.T) {
 	taskCount := 9007
 	for i := 1; i < 5; i++ {
 		ts := generateTasks(taskCount * i)
-		trs, err := adjustRequestSize(ts, 1)
+		trs, err := adjustRequestSize(ts, nil, 1)
 		if err != nil {
 			t.Error(err)
 		}
@@ -39,7 +40,7 @@ func TestTaskSplitting(t *testing.T) {
 
 func TestTaskSplittingSmallCount(t *testing.T) {
 	ts := generateTasks(3)
-	trs, err := adjustRequestSize(ts, 3)
+	trs, err := adjustRequestSize(ts, nil, 3)
 	if err != nil {
 		t.Error(err)
 	}
@@ -57,7 +58,7 @@ func TestTaskSplittingSmallDifferent(t *testing.T) {
 	counts := []int{3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137, 139, 149, 151, 157, 163, 167, 173, 179, 181, 191, 193, 197, 199, 211, 223, 227, 229, 233, 239, 241, 251, 257, 263, 269, 271, 277, 281, 283, 293, 307, 311, 313, 317, 331, 337, 347, 349, 353, 359, 367, 373, 379, 383, 389, 397, 401, 409, 419, 421, 431, 433, 439, 443, 449, 457, 461, 463, 467, 479, 487, 491, 499, 503, 509, 521, 523, 541, 547, 557, 563, 569, 571, 577, 587, 593, 599, 601, 607, 613, 617, 619, 631, 641, 643, 647, 653, 659, 661, 673, 677, 683, 691, 701, 709, 719, 727, 733, 739, 743, 751, 757, 761, 769, 773, 787, 797, 809, 811, 821, 823, 827, 829, 839, 853, 857, 859, 863, 877, 881, 883, 887, 907, 911, 919, 929, 937, 941, 947, 953, 967, 971, 977, 983, 991, 997}
 	for _, c := range counts {
 		ts := generateTasks(c)
-		trs, err := adjustRequestSize(ts, 3)
+		trs, err := adjustRequestSize(ts, nil, 3)
 		if err != nil {
 			t.Error(err)
 		}
@@ -74,9 +75,66 @@ func TestTaskSplittingSmallDifferent(t *testing.T) {
 	}
 }
 
-func TestGetProjects(t *testing.T) {
-	db = connectDB(testDBConnString)
+func TestTaskSplittingWithFields(t *testing.T) {
+	ts := generateTasks(30000)
+	for _, task := range ts {
+		task.UserID = 123456
+		task.EstimatedSeconds = 36000
+		task.foreignUserID = "123456"
+	}
+	fields := availableTaskFields(ts)
+	if len(fields) != 2 {
+		t.Fatalf("expected estimate and user fields, got %v", fields)
+	}
+	trs, err := adjustRequestSize(ts, fields, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, tr := range trs {
+		b, err := json.Marshal(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > maxPayloadSizeBytes {
+			t.Errorf("expected request below %d bytes, got %d", maxPayloadSizeBytes, len(b))
+		}
+		if len(tr.Fields) != 2 {
+			t.Errorf("expected fields in every request, got %v", tr.Fields)
+		}
+		total += len(tr.Tasks)
+	}
+	if total != len(ts) {
+		t.Errorf("expected %d tasks, got %d", len(ts), total)
+	}
+}
 
+func TestTaskRequestsKeepUnlinkedAssignees(t *testing.T) {
+	response := &TasksResponse{
+		Tasks: []*Task{
+			{Name: "assigned", ForeignID: "1", UserID: 5},
+			{Name: "unassigned", ForeignID: "2"},
+			{Name: "unlinked", ForeignID: "3", EstimatedSeconds: 3600},
+		},
+		Fields:            []string{taskFieldEstimate, taskFieldUser},
+		UnlinkedAssignees: []string{"3"},
+	}
+	trs, err := response.requests(response.Tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trs) != 2 || len(trs[0].Tasks) != 2 || len(trs[1].Tasks) != 1 {
+		t.Fatalf("expected tasks with unlinked assignees in a separate request, got %+v", trs)
+	}
+	if !reflect.DeepEqual(trs[0].Fields, []string{taskFieldEstimate, taskFieldUser}) {
+		t.Errorf("expected user field for linked and unassigned tasks, got %v", trs[0].Fields)
+	}
+	if !reflect.DeepEqual(trs[1].Fields, []string{taskFieldEstimate}) || trs[1].Tasks[0].ForeignID != "3" {
+		t.Errorf("expected no user field for unlinked assignees, got %v", trs[1].Fields)
+	}
+}
+
+func TestGetProjects(t *testing.T) {
 	p := NewPipe(1, TestServiceName, "projects")
 
 	fetchProjects(p)
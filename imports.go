@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/bugsnag/bugsnag-go"
+)
+
+// ImportStats describes the stored snapshots of one import key
+type ImportStats struct {
+	Key      string    `json:"key"`
+	Count    int       `json:"count"`
+	Bytes    int64     `json:"bytes"`
+	LatestAt time.Time `json:"latest_at"`
+}
+
+func importHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// saveImport stores a snapshot, identical to the latest one
+// only refreshes the timestamp of the latest snapshot
+func saveImport(workspaceID int, key string, data []byte) error {
+	stored, err := store.SaveImport(workspaceID, key, data, importHash(data))
+	if err != nil {
+		return err
+	}
+	if stored {
+		metrics.inc("imports_stored")
+	} else {
+		metrics.inc("imports_deduplicated")
+	}
+	return nil
+}
+
+// importsCompactor removes snapshots outside the retention policy,
+// the latest snapshot of every key is always kept
+func importsCompactor() {
+	for {
+		time.Sleep(importsCompactInterval)
+		compactImports()
+	}
+}
+
+func compactImports() {
+	log.Println("-- Imports compactor started")
+	deleted, err := store.CompactImports(importsKeepLast, importsMaxAge)
+	if err != nil {
+		bugsnag.Notify(err)
+		return
+	}
+	metrics.add("imports_compacted", deleted)
+	log.Printf("-- Imports compactor finished, deleted %d snapshots\n", deleted)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSaveImportDeduplicatesAndCompacts(t *testing.T) {
+	workspaceID, key := 43, "test:projects"
+	for _, data := range []string{`{"a":1}`, `{"a":1}`, `{"a":2}`, `{"a":3}`, `{"a":3}`} {
+		if err := saveImport(workspaceID, key, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := store.LoadImportStats(workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Count != 3 {
+		t.Fatalf("expected 3 distinct snapshots, got %+v", stats)
+	}
+
+	if _, err := store.CompactImports(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CompactImports(0, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = store.LoadImportStats(workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Count != 1 {
+		t.Fatalf("expected only the latest snapshot to be kept, got %+v", stats)
+	}
+	b, err := store.LoadImport(workspaceID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"a":3}` {
+		t.Fatalf("expected latest snapshot, got %s", b)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"reflect"
 	"testing"
 )
@@ -10,7 +11,13 @@ var testDB = "pipes_test"
 func init() {
 	InitFlags()
 	loadIntegrations()
-	db = connectDB(testDBConnString)
+	var err error
+	if store, err = openStorage(testStorageType, testDBConnString); err != nil {
+		log.Fatal(err)
+	}
+	if err := store.Migrate(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func TestWorkspaceIntegrations(t *testing.T) {
@@ -26,10 +33,14 @@ func TestWorkspaceIntegrations(t *testing.T) {
 
 	want := []Integration{
 		{ID: "basecamp", Name: "Basecamp", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-basecamp", Image: "/images/logo-basecamp.png", AuthType: "oauth2"},
-		{ID: "freshbooks", Name: "Freshbooks", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-freshbooks-classic", Image: "/images/logo-freshbooks.png", AuthType: "oauth1"},
+		{ID: "freshbooks", Name: "Freshbooks", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-freshbooks", Image: "/images/logo-freshbooks.png", AuthType: "oauth2"},
 		{ID: "teamweek", Name: "Toggl Plan", Link: "https://support.toggl.com/en/articles/2212490-integration-with-toggl-plan-teamweek", Image: "/images/logo-teamweek.png", AuthType: "oauth2"},
 		{ID: "asana", Name: "Asana", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-asana", Image: "/images/logo-asana.png", AuthType: "oauth2"},
 		{ID: "github", Name: "Github", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-github", Image: "/images/logo-github.png", AuthType: "oauth2"},
+		{ID: "gitlab", Name: "GitLab", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-gitlab", Image: "/images/logo-gitlab.png", AuthType: "oauth2"},
+		{ID: "harvest", Name: "Harvest", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-harvest", Image: "/images/logo-harvest.png", AuthType: "oauth2"},
+		{ID: "linear", Name: "Linear", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-linear", Image: "/images/logo-linear.png", AuthType: "oauth2"},
+		{ID: "trello", Name: "Trello", Link: "https://support.toggl.com/import-and-export/integrations-via-toggl-pipes/integration-with-trello", Image: "/images/logo-trello.png", AuthType: "apikey"},
 	}
 
 	if len(integrations) != len(want) {
@@ -52,7 +63,7 @@ func TestWorkspaceIntegrationPipes(t *testing.T) {
 
 	want := [][]*Pipe{
 		{ // Basecamp
-			{ID: "users", Name: "Users", Premium: false, AutomaticOption: false},
+			{ID: "users", Name: "Users", Premium: false, AutomaticOption: true},
 			{ID: "projects", Name: "Projects", Premium: false, AutomaticOption: true},
 			{ID: "todolists", Name: "Todo lists", Premium: true, AutomaticOption: true},
 			{ID: "todos", Name: "Todos", Premium: true, AutomaticOption: true},
@@ -64,17 +75,45 @@ func TestWorkspaceIntegrationPipes(t *testing.T) {
 			{ID: "timeentries", Name: "Time entries", Premium: true, AutomaticOption: true},
 		},
 		{ // Teamweek
-			{ID: "users", Name: "Users", Premium: false, AutomaticOption: false},
+			{ID: "users", Name: "Users", Premium: false, AutomaticOption: true},
 			{ID: "projects", Name: "Projects", Premium: false, AutomaticOption: true},
 			{ID: "tasks", Name: "Tasks", Premium: true, AutomaticOption: true},
 		},
 		{ // Asana
-			{ID: "users", Name: "Users", Premium: false, AutomaticOption: false},
+			{ID: "users", Name: "Users", Premium: false, AutomaticOption: true},
 			{ID: "projects", Name: "Projects", Premium: false, AutomaticOption: true},
+			{ID: "todolists", Name: "Sections", Premium: true, AutomaticOption: true},
 			{ID: "tasks", Name: "Tasks", Premium: true, AutomaticOption: true},
+			{ID: "tags", Name: "Tags", Premium: false, AutomaticOption: true},
 		},
 		{ // Github
 			{ID: "projects", Name: "Github repos", Premium: false, AutomaticOption: true},
+			{ID: "tags", Name: "Labels", Premium: false, AutomaticOption: true},
+		},
+		{ // GitLab
+			{ID: "users", Name: "Users", Premium: false, AutomaticOption: false},
+			{ID: "projects", Name: "Projects", Premium: false, AutomaticOption: true},
+			{ID: "todolists", Name: "Milestones", Premium: true, AutomaticOption: true},
+			{ID: "tasks", Name: "Issues", Premium: true, AutomaticOption: true},
+			{ID: "timeentries", Name: "Time entries", Premium: true, AutomaticOption: true},
+		},
+		{ // Harvest
+			{ID: "users", Name: "Users", Premium: false, AutomaticOption: false},
+			{ID: "projects", Name: "Projects", Premium: false, AutomaticOption: true},
+			{ID: "tasks", Name: "Tasks", Premium: true, AutomaticOption: true},
+			{ID: "timeentries_import", Name: "Time entries import", Premium: true, AutomaticOption: true},
+			{ID: "timeentries", Name: "Time entries", Premium: true, AutomaticOption: true},
+		},
+		{ // Linear
+			{ID: "users", Name: "Users", Premium: false, AutomaticOption: false},
+			{ID: "projects", Name: "Projects", Premium: false, AutomaticOption: true},
+			{ID: "tasks", Name: "Issues", Premium: true, AutomaticOption: true},
+		},
+		{ // Trello
+			{ID: "users", Name: "Users", Premium: false, AutomaticOption: false},
+			{ID: "projects", Name: "Boards", Premium: false, AutomaticOption: true},
+			{ID: "todolists", Name: "Lists", Premium: true, AutomaticOption: true},
+			{ID: "tasks", Name: "Cards", Premium: true, AutomaticOption: true},
 		},
 	}
 
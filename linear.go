@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.google.com/p/goauth2/oauth"
+)
+
+// linearAPIURL is replaced in tests
+var linearAPIURL = "https://api.linear.app/graphql"
+
+const linearPerPage = 100
+
+type LinearService struct {
+	emptyService
+	workspaceID int
+	*LinearParams
+	token oauth.Token
+	since *time.Time
+}
+
+// LinearParams selects a Linear team
+type LinearParams struct {
+	AccountID string `json:"account_id"`
+}
+
+type (
+	linearRequest struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables,omitempty"`
+	}
+
+	linearError struct {
+		Message string `json:"message"`
+	}
+
+	// linearConnection is a page of a GraphQL connection
+	linearConnection struct {
+		Nodes    json.RawMessage `json:"nodes"`
+		PageInfo struct {
+			HasNextPage bool   `json:"hasNextPage"`
+			EndCursor   string `json:"endCursor"`
+		} `json:"pageInfo"`
+	}
+
+	linearTeam struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	linearUser struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		Email  string `json:"email"`
+		Active bool   `json:"active"`
+	}
+
+	linearProject struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		State string `json:"state"`
+	}
+
+	linearIssue struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+		State struct {
+			Type string `json:"type"`
+		} `json:"state"`
+		Project *struct {
+			ID string `json:"id"`
+		} `json:"project"`
+		Assignee *struct {
+			ID string `json:"id"`
+		} `json:"assignee"`
+	}
+)
+
+const (
+	linearTeamsQuery = `query($first: Int!, $after: String) {
+  page: teams(first: $first, after: $after) {
+    nodes { id name }
+    pageInfo { hasNextPage endCursor }
+  }
+}`
+
+	linearMembersQuery = `query($teamId: String!, $first: Int!, $after: String) {
+  team(id: $teamId) {
+    page: members(first: $first, after: $after) {
+      nodes { id name email active }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+}`
+
+	linearProjectsQuery = `query($teamId: String!, $first: Int!, $after: String) {
+  team(id: $teamId) {
+    page: projects(first: $first, after: $after) {
+      nodes { id name state }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+}`
+
+	linearIssuesQuery = `query($teamId: String!, $first: Int!, $after: String, $filter: IssueFilter) {
+  team(id: $teamId) {
+    page: issues(first: $first, after: $after, filter: $filter) {
+      nodes { id title state { type } project { id } assignee { id } }
+      pageInfo { hasNextPage endCursor }
+    }
+  }
+}`
+)
+
+func (s *LinearService) Name() string {
+	return "linear"
+}
+
+func (s *LinearService) WorkspaceID() int {
+	return s.workspaceID
+}
+
+func (s *LinearService) keyFor(objectType string) string {
+	if s.LinearParams == nil {
+		return fmt.Sprintf("linear:account:%s", objectType)
+	}
+	return fmt.Sprintf("linear:account:%s:%s", accountKey(s.AccountID), objectType)
+}
+
+func (s *LinearService) setSince(since *time.Time) {
+	s.since = since
+}
+
+func (s *LinearService) setParams(b []byte) error {
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s.LinearParams == nil || s.AccountID == "" {
+		return errors.New("account_id must be present")
+	}
+	return nil
+}
+
+func (s *LinearService) setAuthData(b []byte) error {
+	return json.Unmarshal(b, &s.token)
+}
+
+// query posts a GraphQL query and decodes its data into v
+func (s *LinearService) query(query string, variables map[string]interface{}, v interface{}) error {
+	body, err := json.Marshal(linearRequest{Query: query, Variables: variables})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", linearAPIURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	resp, err := t.Client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []linearError   `json:"errors"`
+	}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return fmt.Errorf("linear: request failed with status code %d: %s", resp.StatusCode, b)
+	}
+	if len(response.Errors) > 0 {
+		var messages []string
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("linear: %s", strings.Join(messages, "; "))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("linear: request failed with status code %d: %s", resp.StatusCode, b)
+	}
+	return json.Unmarshal(response.Data, v)
+}
+
+// queryPages runs query for every page of the connection aliased as "page",
+// either at the root of the query or under the selected team
+func (s *LinearService) queryPages(query string, variables map[string]interface{}, handle func(nodes json.RawMessage) error) error {
+	variables["first"] = linearPerPage
+	variables["after"] = nil
+	for {
+		var data struct {
+			Page *linearConnection `json:"page"`
+			Team *struct {
+				Page *linearConnection `json:"page"`
+			} `json:"team"`
+		}
+		if err := s.query(query, variables, &data); err != nil {
+			return err
+		}
+		page := data.Page
+		if data.Team != nil {
+			page = data.Team.Page
+		}
+		if page == nil {
+			return errors.New("linear: response is missing the requested page")
+		}
+		if err := handle(page.Nodes); err != nil {
+			return err
+		}
+		if !page.PageInfo.HasNextPage {
+			return nil
+		}
+		variables["after"] = page.PageInfo.EndCursor
+	}
+}
+
+// Map Linear teams to local accounts
+func (s *LinearService) Accounts() ([]*Account, error) {
+	var accounts []*Account
+	err := s.queryPages(linearTeamsQuery, map[string]interface{}{}, func(nodes json.RawMessage) error {
+		var teams []linearTeam
+		if err := json.Unmarshal(nodes, &teams); err != nil {
+			return err
+		}
+		for _, object := range teams {
+			accounts = append(accounts, &Account{ForeignID: object.ID, Name: object.Name})
+		}
+		return nil
+	})
+	return accounts, err
+}
+
+// Map active Linear team members to local users
+func (s *LinearService) Users() ([]*User, error) {
+	var users []*User
+	variables := map[string]interface{}{"teamId": s.AccountID}
+	err := s.queryPages(linearMembersQuery, variables, func(nodes json.RawMessage) error {
+		var members []linearUser
+		if err := json.Unmarshal(nodes, &members); err != nil {
+			return err
+		}
+		for _, object := range members {
+			if !object.Active {
+				continue
+			}
+			users = append(users, &User{
+				ForeignID: object.ID,
+				Name:      object.Name,
+				Email:     object.Email,
+			})
+		}
+		return nil
+	})
+	return users, err
+}
+
+// Map Linear projects to projects, completed and canceled projects are inactive
+func (s *LinearService) Projects() ([]*Project, error) {
+	var projects []*Project
+	variables := map[string]interface{}{"teamId": s.AccountID}
+	err := s.queryPages(linearProjectsQuery, variables, func(nodes json.RawMessage) error {
+		var foreignObjects []linearProject
+		if err := json.Unmarshal(nodes, &foreignObjects); err != nil {
+			return err
+		}
+		for _, object := range foreignObjects {
+			projects = append(projects, &Project{
+				ForeignID: object.ID,
+				Name:      object.Name,
+				Active:    object.State != "completed" && object.State != "canceled",
+			})
+		}
+		return nil
+	})
+	return projects, err
+}
+
+// Map Linear issues to tasks, completed and canceled issues are inactive.
+// Issues without a project are skipped as tasks must belong to a project.
+func (s *LinearService) Tasks() ([]*Task, error) {
+	variables := map[string]interface{}{"teamId": s.AccountID}
+	if s.since != nil && !s.since.IsZero() {
+		variables["filter"] = map[string]interface{}{
+			"updatedAt": map[string]string{"gt": s.since.Format(time.RFC3339)},
+		}
+	}
+	var tasks []*Task
+	err := s.queryPages(linearIssuesQuery, variables, func(nodes json.RawMessage) error {
+		var issues []linearIssue
+		if err := json.Unmarshal(nodes, &issues); err != nil {
+			return err
+		}
+		for _, object := range issues {
+			if object.Project == nil {
+				continue
+			}
+			task := &Task{
+				ForeignID:        object.ID,
+				Name:             object.Title,
+				Active:           object.State.Type != "completed" && object.State.Type != "canceled",
+				foreignProjectID: object.Project.ID,
+			}
+			if object.Assignee != nil {
+				task.foreignUserID = object.Assignee.ID
+			}
+			tasks = append(tasks, task)
+		}
+		return nil
+	})
+	return tasks, err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var linearQueryConnection = regexp.MustCompile(`page: (\w+)\(`)
+
+// newLinearTestServer replays responses recorded in testdata/linear,
+// named after the queried connection and the requested cursor
+func newLinearTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var request linearRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		match := linearQueryConnection.FindStringSubmatch(request.Query)
+		if match == nil {
+			t.Errorf("unexpected query %s", request.Query)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		name := match[1]
+		if after, ok := request.Variables["after"].(string); ok {
+			name += "_" + after
+		}
+		b, err := ioutil.ReadFile(filepath.Join("testdata", "linear", name+".json"))
+		if err != nil {
+			t.Errorf("no recorded response for %s", name)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+}
+
+func TestLinearService(t *testing.T) {
+	ts := newLinearTestServer(t)
+	defer ts.Close()
+	defer func(apiURL string) { linearAPIURL = apiURL }(linearAPIURL)
+	linearAPIURL = ts.URL
+
+	s := getService("linear", 1)
+	if err := s.setAuthData([]byte(`{"AccessToken":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := s.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].Name != "Engineering" {
+		t.Fatalf("unexpected accounts %+v", accounts)
+	}
+	if err := s.setParams([]byte(`{"account_id":"` + accounts[0].ForeignID + `"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := s.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Email != "jane@example.com" {
+		t.Errorf("expected only active members, got %+v", users)
+	}
+
+	projects, err := s.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || !projects[0].Active || projects[1].Active {
+		t.Errorf("expected completed project to be inactive, got %+v", projects)
+	}
+
+	tasks, err := s.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasks[0].foreignUserID != "2e0f7ad1-4e8d-4c1e-9d2b-5d6f5b0b6f11" || tasks[1].foreignUserID != "" {
+		t.Errorf("expected assignee of the issue, got %+v", tasks[0])
+	}
+	active := map[string]bool{}
+	for _, task := range tasks {
+		active[task.Name] = task.Active
+	}
+	want := map[string]bool{"Crash on login": true, "Dark mode": false, "New landing page": false}
+	if len(active) != len(want) {
+		t.Fatalf("expected issues with a project from all pages, got %v", active)
+	}
+	for name, isActive := range want {
+		if active[name] != isActive {
+			t.Errorf("expected issue %s active=%v", name, isActive)
+		}
+	}
+}
+
+func TestLinearServiceErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":null,"errors":[{"message":"Entity not found"}]}`))
+	}))
+	defer ts.Close()
+	defer func(apiURL string) { linearAPIURL = apiURL }(linearAPIURL)
+	linearAPIURL = ts.URL
+
+	s := getService("linear", 1)
+	s.setParams([]byte(`{"account_id":"missing"}`))
+	if _, err := s.Projects(); err == nil || err.Error() != "linear: Entity not found" {
+		t.Errorf("expected GraphQL error to be returned, got %v", err)
+	}
+}
+
+func TestLinearConnectionKeys(t *testing.T) {
+	s := getService("linear", 54)
+	if err := s.setParams([]byte(`{"account_id":"8f2c1b9e-4d3a-4f6b-9c0e-2a7d5e1f3b4c"}`)); err != nil {
+		t.Fatal(err)
+	}
+	testConnectionKeys(t, s)
+	connection := NewConnection(s, projectsPipeID)
+	connection.Data["project"] = 1
+	if err := connection.save(); err != nil {
+		t.Fatal(err)
+	}
+}
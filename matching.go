@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// matchThreshold is the lowest similarity score a match is suggested with
+const matchThreshold = 0.75
+
+var errMatchingNotSupported = errors.New("Matching is supported only for projects and tasks")
+
+// MatchSuggestion proposes linking a foreign object to an existing Toggl object
+type MatchSuggestion struct {
+	ForeignID   string  `json:"foreign_id"`
+	ForeignName string  `json:"foreign_name"`
+	TogglID     int     `json:"toggl_id"`
+	TogglName   string  `json:"toggl_name"`
+	Score       float64 `json:"score"`
+}
+
+// matchCandidate is an object to be matched, contextID is the Toggl ID
+// of the client of a project or of the project of a task
+type matchCandidate struct {
+	foreignID string
+	togglID   int
+	name      string
+	contextID int
+}
+
+// normalizeName lowercases the name and replaces punctuation
+// and repeated whitespace with single spaces
+func normalizeName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// nameVariants returns the normalized name and the name
+// without the context names it is prefixed with, e.g. "Acme - Website"
+func nameVariants(name string, contextNames ...string) []string {
+	normalized := normalizeName(name)
+	variants := []string{normalized}
+	for _, contextName := range contextNames {
+		prefix := normalizeName(contextName)
+		if prefix != "" && strings.HasPrefix(normalized, prefix+" ") {
+			variants = append(variants, normalized[len(prefix)+1:])
+		}
+	}
+	return variants
+}
+
+// similarity scores names from 0 to 1 by their edit distance
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	diff := len(ra) - len(rb)
+	if diff < 0 {
+		diff = -diff
+	}
+	// the distance is at least the difference of lengths
+	if float64(diff)/float64(longest) > 1-matchThreshold {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// scoreMatch scores a pair of candidates, contextNames resolves context IDs
+// to names which may prefix object names. Candidates in different contexts
+// are scored lower or, with strictContext, not matched at all.
+func scoreMatch(foreign, toggl matchCandidate, contextNames map[int]string, strictContext bool) float64 {
+	names := []string{contextNames[foreign.contextID], contextNames[toggl.contextID]}
+	var score float64
+	for _, a := range nameVariants(foreign.name, names...) {
+		for _, b := range nameVariants(toggl.name, names...) {
+			if s := similarity(a, b); s > score {
+				score = s
+			}
+		}
+	}
+	if foreign.contextID == 0 || toggl.contextID == 0 {
+		return score
+	}
+	switch {
+	case foreign.contextID == toggl.contextID:
+		score += 0.1
+	case strictContext:
+		return 0
+	default:
+		score -= 0.2
+	}
+	if score > 1 {
+		score = 1
+	}
+	return score
+}
+
+// maxMatchComparisons caps fuzzy comparisons of one request, once they are
+// used up only names equal after normalization are matched
+var maxMatchComparisons = 200000
+
+// suggestMatches pairs each foreign object with at most one Toggl object,
+// best scoring pairs are taken first. Toggl objects are indexed by their
+// name variants, so only objects with an equal name or a name of similar
+// length are scored.
+func suggestMatches(foreign, toggl []matchCandidate, contextNames map[int]string, strictContext bool) []MatchSuggestion {
+	names := make([]string, 0, len(contextNames))
+	for _, name := range contextNames {
+		names = append(names, name)
+	}
+	type lengthEntry struct {
+		length int
+		index  int
+	}
+	exact := make(map[string][]int)
+	var byLength []lengthEntry
+	for i, t := range toggl {
+		for _, variant := range nameVariants(t.name, names...) {
+			exact[variant] = append(exact[variant], i)
+			byLength = append(byLength, lengthEntry{utf8.RuneCountInString(variant), i})
+		}
+	}
+	sort.Slice(byLength, func(i, j int) bool { return byLength[i].length < byLength[j].length })
+
+	var suggestions []MatchSuggestion
+	// scored marks Toggl objects already scored for foreign object i with i+1
+	scored := make([]int, len(toggl))
+	comparisons := 0
+	for i, f := range foreign {
+		score := func(j int) {
+			scored[j] = i + 1
+			t := toggl[j]
+			if score := scoreMatch(f, t, contextNames, strictContext); score >= matchThreshold {
+				suggestions = append(suggestions, MatchSuggestion{
+					ForeignID:   f.foreignID,
+					ForeignName: f.name,
+					TogglID:     t.togglID,
+					TogglName:   t.name,
+					Score:       score,
+				})
+			}
+		}
+		variants := nameVariants(f.name, names...)
+		for _, variant := range variants {
+			for _, j := range exact[variant] {
+				if scored[j] != i+1 {
+					score(j)
+				}
+			}
+		}
+		for _, variant := range variants {
+			// similarity is 0 when lengths differ by more than 1-matchThreshold
+			length := float64(utf8.RuneCountInString(variant))
+			from := sort.Search(len(byLength), func(k int) bool {
+				return float64(byLength[k].length) >= length*matchThreshold
+			})
+			for k := from; k < len(byLength) && float64(byLength[k].length)*matchThreshold <= length; k++ {
+				if j := byLength[k].index; scored[j] != i+1 && comparisons < maxMatchComparisons {
+					comparisons++
+					score(j)
+				}
+			}
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		if suggestions[i].ForeignID != suggestions[j].ForeignID {
+			return suggestions[i].ForeignID < suggestions[j].ForeignID
+		}
+		return suggestions[i].TogglID < suggestions[j].TogglID
+	})
+
+	matchedForeign := make(map[string]bool)
+	matchedToggl := make(map[int]bool)
+	result := make([]MatchSuggestion, 0)
+	for _, suggestion := range suggestions {
+		if matchedForeign[suggestion.ForeignID] || matchedToggl[suggestion.TogglID] {
+			continue
+		}
+		matchedForeign[suggestion.ForeignID] = true
+		matchedToggl[suggestion.TogglID] = true
+		result = append(result, suggestion)
+	}
+	return result
+}
+
+// fetchMatchCandidates imports foreign objects of the pipe for suggestions,
+// nothing is posted to Toggl so objects are only linked as far as they already are
+func fetchMatchCandidates(p *Pipe) error {
+	s, err := p.Service()
+	if err != nil {
+		return err
+	}
+	projectConnections, err := loadConnection(s, projectsPipeID)
+	if err != nil {
+		return err
+	}
+	switch pipeID := connectionPipeID(p.ID); pipeID {
+	case usersPipeID:
+		return errMatchingNotSupported
+	case projectsPipeID:
+		projects, err := s.Projects()
+		if err != nil {
+			return err
+		}
+		clientConnections, err := loadConnection(s, clientsPipeID)
+		if err != nil {
+			return err
+		}
+		projects = trimSpacesFromName(projects)
+		for _, project := range projects {
+			project.ID = projectConnections.Data[project.ForeignID]
+			project.ClientID = clientConnections.Data[project.foreignClientID]
+		}
+		return saveObject(p, pipeID, ProjectsResponse{Projects: projects})
+	default:
+		fetch := s.Tasks
+		if pipeID == todoPipeId {
+			fetch = s.TodoLists
+		}
+		tasks, err := fetch()
+		if err != nil {
+			return err
+		}
+		taskConnections, err := loadConnection(s, pipeID)
+		if err != nil {
+			return err
+		}
+		for _, task := range tasks {
+			task.ID = taskConnections.Data[task.ForeignID]
+			task.ProjectID = projectConnections.Data[task.foreignProjectID]
+		}
+		return saveObject(p, pipeID, TasksResponse{Tasks: tasks})
+	}
+}
+
+// pipeMatchSuggestions suggests links for foreign objects of the latest import
+// which are not linked yet to Toggl objects which are not linked yet.
+// togglClients maps Toggl client IDs to names, it is used for projects.
+func pipeMatchSuggestions(s Service, pipeID string, togglObjects []TogglObject, togglClients map[int]string) ([]MatchSuggestion, error) {
+	if connectionPipeID(pipeID) == usersPipeID {
+		return nil, errMatchingNotSupported
+	}
+	connection, err := loadConnection(s, connectionPipeID(pipeID))
+	if err != nil {
+		return nil, err
+	}
+	neverSync, err := loadNeverSync(s, pipeID)
+	if err != nil {
+		return nil, err
+	}
+	isUnlinked := func(foreignID string) bool {
+		_, linked := connection.Data[foreignID]
+		_, excluded := neverSync.Data[foreignID]
+		return !linked && !excluded
+	}
+
+	var foreign []matchCandidate
+	var contextNames map[int]string
+	var strictContext bool
+	if connectionPipeID(pipeID) == projectsPipeID {
+		response, err := getProjects(s)
+		if err != nil || response == nil {
+			return nil, err
+		}
+		for _, project := range response.Projects {
+			if isUnlinked(project.ForeignID) {
+				foreign = append(foreign, matchCandidate{foreignID: project.ForeignID, name: project.Name, contextID: project.ClientID})
+			}
+		}
+		contextNames = togglClients
+	} else {
+		response, err := getTasks(s, connectionPipeID(pipeID))
+		if err != nil || response == nil {
+			return nil, err
+		}
+		for _, task := range response.Tasks {
+			if isUnlinked(task.ForeignID) {
+				foreign = append(foreign, matchCandidate{foreignID: task.ForeignID, name: task.Name, contextID: task.ProjectID})
+			}
+		}
+		strictContext = true
+	}
+
+	linked := make(map[int]bool, len(connection.Data))
+	for _, togglID := range connection.Data {
+		linked[togglID] = true
+	}
+	toggl := make([]matchCandidate, 0, len(togglObjects))
+	for _, object := range togglObjects {
+		if linked[object.ID] {
+			continue
+		}
+		contextID := object.ClientID
+		if strictContext {
+			contextID = object.ProjectID
+		}
+		toggl = append(toggl, matchCandidate{togglID: object.ID, name: object.Name, contextID: contextID})
+	}
+	return suggestMatches(foreign, toggl, contextNames, strictContext), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPipeMatchSuggestions(t *testing.T) {
+	s := getService(TestServiceName, 45)
+	response := ProjectsResponse{Projects: []*Project{
+		{ForeignID: "1", Name: "Website  redesign", ClientID: 5},
+		{ForeignID: "2", Name: "API: v2"},
+		{ForeignID: "3", Name: "Something else"},
+		{ForeignID: "4", Name: "Linked"},
+	}}
+	b, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveImport(s.WorkspaceID(), s.keyFor(projectsPipeID), b); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkManually(s, projectsPipeID, "4", 14); err != nil {
+		t.Fatal(err)
+	}
+
+	togglObjects := []TogglObject{
+		{ID: 11, Name: "Acme - Website Redesign", ClientID: 5},
+		{ID: 12, Name: "api v2"},
+		{ID: 13, Name: "Unrelated"},
+		{ID: 14, Name: "Linked"},
+	}
+	suggestions, err := pipeMatchSuggestions(s, projectsPipeID, togglObjects, map[int]string{5: "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 2 {
+		t.Fatalf("expected 2 suggestions, got %+v", suggestions)
+	}
+	matched := map[string]int{}
+	for _, suggestion := range suggestions {
+		matched[suggestion.ForeignID] = suggestion.TogglID
+	}
+	if matched["1"] != 11 || matched["2"] != 12 {
+		t.Errorf("unexpected suggestions %+v", suggestions)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if score := similarity(normalizeName("Mobile App"), normalizeName("mobile-apps")); score < matchThreshold {
+		t.Errorf("expected similar names to match, got %f", score)
+	}
+	if score := similarity(normalizeName("Backend"), normalizeName("Frontend")); score >= matchThreshold {
+		t.Errorf("expected different names not to match, got %f", score)
+	}
+}
+
+func TestSuggestMatchesIsCapped(t *testing.T) {
+	defer func(max int) { maxMatchComparisons = max }(maxMatchComparisons)
+	maxMatchComparisons = 0
+
+	foreign := []matchCandidate{{foreignID: "1", name: "Website"}, {foreignID: "2", name: "Mobile App"}}
+	toggl := []matchCandidate{{togglID: 11, name: "website"}, {togglID: 12, name: "mobile-apps"}, {togglID: 13, name: "Backend"}}
+	suggestions := suggestMatches(foreign, toggl, nil, false)
+	if len(suggestions) != 1 || suggestions[0].TogglID != 11 {
+		t.Errorf("expected only equal names to be matched without comparisons left, got %+v", suggestions)
+	}
+
+	maxMatchComparisons = 100
+	if suggestions = suggestMatches(foreign, toggl, nil, false); len(suggestions) != 2 {
+		t.Errorf("expected similar names to be matched, got %+v", suggestions)
+	}
+}
+
+func TestFetchMatchCandidatesDoesNotPost(t *testing.T) {
+	fake := &fakeTogglAPI{}
+	defer withFakeTogglAPI(fake)()
+	for _, pipeID := range []string{projectsPipeID, tasksPipeId, todoPipeId} {
+		// suggestions are fetched without a pipe status
+		p := NewPipe(56, TestServiceName, pipeID)
+		if err := fetchMatchCandidates(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.calls != 0 {
+		t.Errorf("expected no Toggl calls, got %d", fake.calls)
+	}
+	s := getService(TestServiceName, 56)
+	response, err := getProjects(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response == nil || len(response.Projects) != 4 {
+		t.Errorf("expected foreign projects to be imported, got %+v", response)
+	}
+}
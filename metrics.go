@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// metricsRegistry keeps process wide counters and gauges
+// which are exposed through the internal metrics listener
+type metricsRegistry struct {
+	sync.RWMutex
+	counters map[string]*int64
+	gauges   map[string]func() interface{}
+}
+
+var metrics = &metricsRegistry{
+	counters: make(map[string]*int64),
+	gauges:   make(map[string]func() interface{}),
+}
+
+func (m *metricsRegistry) counter(name string) *int64 {
+	m.RLock()
+	c, exists := m.counters[name]
+	m.RUnlock()
+	if exists {
+		return c
+	}
+	m.Lock()
+	defer m.Unlock()
+	if c, exists = m.counters[name]; !exists {
+		c = new(int64)
+		m.counters[name] = c
+	}
+	return c
+}
+
+func (m *metricsRegistry) inc(name string) {
+	m.add(name, 1)
+}
+
+func (m *metricsRegistry) add(name string, delta int64) {
+	atomic.AddInt64(m.counter(name), delta)
+}
+
+func (m *metricsRegistry) value(name string) int64 {
+	return atomic.LoadInt64(m.counter(name))
+}
+
+func (m *metricsRegistry) registerGauge(name string, fn func() interface{}) {
+	m.Lock()
+	m.gauges[name] = fn
+	m.Unlock()
+}
+
+// snapshot calls gauges without holding the lock, as gauges may read counters
+func (m *metricsRegistry) snapshot() map[string]interface{} {
+	m.RLock()
+	result := make(map[string]interface{}, len(m.counters)+len(m.gauges))
+	for name, c := range m.counters {
+		result[name] = atomic.LoadInt64(c)
+	}
+	gauges := make(map[string]func() interface{}, len(m.gauges))
+	for name, fn := range m.gauges {
+		gauges[name] = fn
+	}
+	m.RUnlock()
+	for name, fn := range gauges {
+		result[name] = fn()
+	}
+	return result
+}
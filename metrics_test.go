@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsAreServedOnlyInternally(t *testing.T) {
+	public := httptest.NewRecorder()
+	routes.ServeHTTP(public, httptest.NewRequest("GET", "/api/v1/metrics", nil))
+	if public.Code != http.StatusNotFound {
+		t.Errorf("expected metrics not to be served by the API, got %d", public.Code)
+	}
+
+	internal := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(internal, httptest.NewRequest("GET", "/metrics", nil))
+	if internal.Code != http.StatusOK {
+		t.Errorf("expected metrics to be served internally, got %d", internal.Code)
+	}
+}
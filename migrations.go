@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// migration is an up-only schema change. Applied migrations are recorded
+// in schema_migrations with their checksum, so an applied migration
+// must never be edited, add a new one instead.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+const (
+	// arbitrary key for pg_advisory_lock, so that only one instance migrates
+	migrationsLockID = 727376
+
+	createSchemaMigrationsSQL = `CREATE TABLE IF NOT EXISTS schema_migrations(
+    version INTEGER PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+  )`
+	selectSchemaMigrationsSQL = `SELECT version, checksum FROM schema_migrations`
+	insertSchemaMigrationSQL  = `INSERT INTO schema_migrations(version, name, checksum)
+    VALUES($1, $2, $3)
+  `
+)
+
+var migrations = []migration{
+	{1, "initial schema", `
+CREATE TABLE IF NOT EXISTS authorizations(
+  workspace_id INTEGER,
+  workspace_token VARCHAR(50),
+  service VARCHAR(50),
+  data JSON
+);
+
+CREATE TABLE IF NOT EXISTS imports(
+  workspace_id INTEGER,
+  key VARCHAR(50),
+  data JSON,
+  created_at TIMESTAMP
+);
+
+DROP INDEX IF EXISTS workspace_imports;
+CREATE INDEX IF NOT EXISTS workspace_imports_at ON imports USING btree (workspace_id, key, created_at);
+
+CREATE TABLE IF NOT EXISTS pipes(
+  workspace_id INTEGER,
+  key VARCHAR(50),
+  data JSON
+);
+
+CREATE TABLE IF NOT EXISTS pipes_status(
+  workspace_id INTEGER,
+  key VARCHAR(50),
+  data JSON
+);
+
+CREATE TABLE IF NOT EXISTS connections(
+  workspace_id INTEGER,
+  key VARCHAR(50),
+  data JSON
+);
+
+CREATE TABLE IF NOT EXISTS workspace_tokens(
+  token_hash VARCHAR(64) PRIMARY KEY,
+  workspace_id INTEGER,
+  expires_at TIMESTAMP WITH TIME ZONE
+);
+
+DO $$
+BEGIN
+  IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'pipes_pk') THEN
+    ALTER TABLE pipes ADD CONSTRAINT pipes_pk PRIMARY KEY (workspace_id, key);
+  END IF;
+END;
+$$;
+
+CREATE TABLE IF NOT EXISTS queued_pipes (
+  workspace_id INTEGER,
+  key VARCHAR(50),
+  priority INTEGER DEFAULT 0,
+  created_at timestamp without time zone DEFAULT now(),
+  locked_at timestamp without time zone DEFAULT NULL,
+  synced_at timestamp without time zone DEFAULT NULL,
+  FOREIGN KEY (workspace_id, key) REFERENCES pipes (workspace_id, key) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS pipes_queue_unique ON queued_pipes (workspace_id, key, coalesce(locked_at, '0001-01-01 00:00:00'::timestamp), coalesce(synced_at,'0001-01-01 00:00:00'::timestamp));
+
+CREATE OR REPLACE FUNCTION get_queued_pipes() RETURNS TABLE(workspace_id INTEGER, key VARCHAR(50)) AS $$
+BEGIN
+  RETURN QUERY
+  WITH pending_queue AS (
+    SELECT DISTINCT ON (t.workspace_id) t.workspace_id, t.key, t.priority, t.created_at
+    FROM (
+      SELECT queued_pipes.workspace_id, queued_pipes.key, queued_pipes.priority, queued_pipes.created_at
+      FROM queued_pipes
+      WHERE locked_at IS NULL AND synced_at IS NULL
+      FOR UPDATE
+    ) as t
+  )
+  UPDATE
+    queued_pipes
+  SET
+    locked_at = NOW()
+  FROM (
+    SELECT pending_queue.workspace_id, pending_queue.key
+    FROM pending_queue
+    ORDER BY pending_queue.priority DESC, pending_queue.created_at ASC
+    LIMIT 10
+  ) as pipe
+  WHERE pipe.workspace_id = queued_pipes.workspace_id AND pipe.key = queued_pipes.key AND synced_at IS NULL
+  RETURNING pipe.workspace_id, pipe.key;
+END;
+$$
+LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION queue_automatic_pipes() RETURNS VOID AS $$
+DECLARE
+  r pipes%rowtype;
+BEGIN
+  FOR r IN SELECT workspace_id, key FROM pipes
+  WHERE data->>'automatic' = 'true'
+  LOOP
+  INSERT INTO queued_pipes (workspace_id, key)
+  SELECT r.workspace_id, r.key
+  WHERE NOT EXISTS
+  (
+    SELECT 1 FROM queued_pipes
+    WHERE workspace_id = r.workspace_id
+    AND key = r.key
+    AND synced_at IS NULL
+    FOR UPDATE
+  );
+  END LOOP;
+END;
+$$
+LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION queue_pipe_as_first(workspace_id_param INTEGER, key_param VARCHAR(50)) RETURNS VOID AS $$
+BEGIN
+  WITH priority_cte AS (
+    SELECT coalesce(max(priority), 0)+1 as new_priority FROM queued_pipes WHERE locked_at IS NULL AND synced_at IS NULL
+  ),
+  existing_pipe AS
+  (
+    UPDATE queued_pipes
+    SET priority = new_priority FROM priority_cte
+    WHERE workspace_id = workspace_id_param
+    AND key = key_param
+    AND locked_at IS NULL
+    AND synced_at IS NULL
+    RETURNING workspace_id
+  )
+  INSERT INTO queued_pipes (workspace_id, key, priority)
+  SELECT workspace_id_param, key_param, new_priority FROM priority_cte
+  WHERE NOT EXISTS (SELECT 1 FROM existing_pipe)
+  AND NOT EXISTS
+  (
+    SELECT 1 FROM queued_pipes
+    WHERE workspace_id = workspace_id_param
+    AND key = key_param
+    AND synced_at IS NULL
+    FOR UPDATE
+  );
+END;
+$$
+LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION remove_locked_from_queue(age INTERVAL) RETURNS VOID AS $$
+BEGIN
+  DELETE FROM queued_pipes
+  WHERE synced_at IS NULL
+  AND locked_at < (now() - age);
+END;
+$$
+LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION remove_synced_from_queue(age INTERVAL) RETURNS VOID AS $$
+BEGIN
+  DELETE FROM queued_pipes
+  WHERE synced_at < (now() - age);
+END;
+$$
+LANGUAGE plpgsql;
+`},
+	{2, "constraints for connections, pipes_status and authorizations", `
+DELETE FROM connections a USING connections b
+WHERE a.ctid < b.ctid AND a.workspace_id = b.workspace_id AND a.key = b.key;
+DELETE FROM connections WHERE workspace_id IS NULL OR key IS NULL;
+ALTER TABLE connections ADD CONSTRAINT connections_pk PRIMARY KEY (workspace_id, key);
+DELETE FROM connections WHERE data IS NULL;
+ALTER TABLE connections ALTER COLUMN data SET NOT NULL;
+
+DELETE FROM pipes_status a USING pipes_status b
+WHERE a.ctid < b.ctid AND a.workspace_id = b.workspace_id AND a.key = b.key;
+DELETE FROM pipes_status WHERE workspace_id IS NULL OR key IS NULL;
+ALTER TABLE pipes_status ADD CONSTRAINT pipes_status_pk PRIMARY KEY (workspace_id, key);
+DELETE FROM pipes_status WHERE data IS NULL;
+ALTER TABLE pipes_status ALTER COLUMN data SET NOT NULL;
+
+DELETE FROM authorizations a USING authorizations b
+WHERE a.ctid < b.ctid AND a.workspace_id = b.workspace_id AND a.service = b.service;
+DELETE FROM authorizations WHERE workspace_id IS NULL OR service IS NULL;
+ALTER TABLE authorizations ADD CONSTRAINT authorizations_pk PRIMARY KEY (workspace_id, service);
+-- the service authorization is kept, the token is set again when the user authorizes
+UPDATE authorizations SET workspace_token = '' WHERE workspace_token IS NULL;
+ALTER TABLE authorizations ALTER COLUMN workspace_token SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS workspace_tokens_expires_at ON workspace_tokens USING btree (expires_at);
+`},
+	{3, "connection items", `
+CREATE TABLE connection_items(
+  workspace_id INTEGER NOT NULL,
+  key VARCHAR(50) NOT NULL,
+  foreign_id TEXT NOT NULL,
+  toggl_id INTEGER NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (workspace_id, key, foreign_id)
+);
+
+CREATE INDEX connection_items_toggl_id ON connection_items USING btree (workspace_id, key, toggl_id);
+
+INSERT INTO connection_items(workspace_id, key, foreign_id, toggl_id)
+SELECT c.workspace_id, c.key, item.key, item.value::integer
+FROM connections c, json_each_text(c.data->'Data') item
+WHERE json_typeof(c.data->'Data') = 'object'
+AND item.value ~ '^-?[0-9]+$';
+
+-- connections is kept for rolling back, a later migration drops it
+`},
+	{4, "import hashes", `
+ALTER TABLE imports ADD COLUMN content_hash VARCHAR(64);
+ALTER TABLE imports ADD COLUMN size INTEGER;
+`},
+	{5, "sync runs", `
+CREATE TABLE sync_runs(
+  id SERIAL PRIMARY KEY,
+  workspace_id INTEGER NOT NULL,
+  key VARCHAR(50) NOT NULL,
+  data JSON NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX sync_runs_workspace_key ON sync_runs USING btree (workspace_id, key, id);
+`},
+	{6, "short never sync keys", `
+UPDATE connection_items SET key = regexp_replace(key, '_never_sync$', '_nosync')
+WHERE key LIKE '%\_never\_sync';
+`},
+}
+
+func migrationChecksum(m migration) string {
+	sum := sha256.Sum256([]byte(m.sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// Migrate applies pending migrations, each in its own transaction
+func (s *PostgresStorage) Migrate() error {
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, migrationsLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationsLockID)
+
+	if _, err := conn.ExecContext(context.Background(), createSchemaMigrationsSQL); err != nil {
+		return err
+	}
+	applied, err := loadAppliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		checksum := migrationChecksum(m)
+		if appliedChecksum, exists := applied[m.version]; exists {
+			if appliedChecksum != checksum {
+				return fmt.Errorf("migration %d (%s) was changed after it was applied", m.version, m.name)
+			}
+			continue
+		}
+		log.Printf("Applying migration %d: %s\n", m.version, m.name)
+		tx, err := conn.BeginTx(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.sql); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(insertSchemaMigrationSQL, m.version, m.name, checksum); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadAppliedMigrations(conn *sql.Conn) (map[int]string, error) {
+	rows, err := conn.QueryContext(context.Background(), selectSchemaMigrationsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		applied[version] = checksum
+	}
+	return applied, rows.Err()
+}
+
+// Migrate is a no-op, memory storage has no schema
+func (s *MemoryStorage) Migrate() error {
+	return nil
+}
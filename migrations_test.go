@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", m.name, m.version, i+1)
+		}
+		if m.name == "" || m.sql == "" {
+			t.Errorf("migration %d must have name and sql", m.version)
+		}
+	}
+}
@@ -7,10 +7,12 @@ type (
 		Name string `json:"name"`
 	}
 
-	// Account represents account from third party integration
+	// Account represents account from third party integration,
+	// ForeignID is set instead of ID when the service has non-numeric IDs
 	Account struct {
-		ID   int64  `json:"id"`
-		Name string `json:"name"`
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+		ForeignID string `json:"foreign_id,omitempty"`
 	}
 
 	User struct {
@@ -18,7 +20,18 @@ type (
 		Email          string `json:"email"`
 		Name           string `json:"name"`
 		SendInvitation bool   `json:"send_invitation,omitempty"`
-		ForeignID      string `json:"foreign_id,omitempty"`
+		// Inactive deactivates the workspace user, see removedUsers
+		Inactive  bool   `json:"inactive,omitempty"`
+		ForeignID string `json:"foreign_id,omitempty"`
+	}
+
+	// Group is a Toggl group, members are linked through users connection
+	Group struct {
+		ID             int      `json:"id,omitempty"`
+		Name           string   `json:"name"`
+		UserIDs        []int    `json:"user_ids"`
+		ForeignID      string   `json:"foreign_id,omitempty"`
+		ForeignUserIDs []string `json:"foreign_user_ids,omitempty"`
 	}
 
 	Client struct {
@@ -30,22 +43,45 @@ type (
 	Project struct {
 		ID       int    `json:"id,omitempty"`
 		Name     string `json:"name,omitempty"`
-		Active   bool   `json:"active,omitempty"`
+		Active   bool   `json:"active"`
 		Billable bool   `json:"billable,omitempty"`
 		ClientID int    `json:"cid,omitempty"`
 
+		// Optional fields, see projectFields
+		Color          string  `json:"color,omitempty"`
+		StartDate      string  `json:"start_date,omitempty"`
+		DueDate        string  `json:"due_date,omitempty"`
+		EstimatedHours int     `json:"estimated_hours,omitempty"`
+		Rate           float64 `json:"rate,omitempty"`
+		Template       bool    `json:"template,omitempty"`
+
+		// Members are synced when the pipe syncs project members
+		UserIDs        []int    `json:"user_ids,omitempty"`
+		ForeignUserIDs []string `json:"foreign_user_ids,omitempty"`
+
 		ForeignID       string `json:"foreign_id,omitempty"`
 		foreignClientID string
 	}
 
+	Tag struct {
+		ID        int    `json:"id,omitempty"`
+		Name      string `json:"name"`
+		ForeignID string `json:"foreign_id,omitempty"`
+	}
+
 	Task struct {
 		ID        int    `json:"id,omitempty"`
 		Name      string `json:"name"`
 		Active    bool   `json:"active"`
 		ProjectID int    `json:"pid"`
+		UserID    int    `json:"uid,omitempty"`
+
+		// Optional fields, see availableTaskFields
+		EstimatedSeconds int `json:"estimated_seconds,omitempty"`
 
 		ForeignID        string `json:"foreign_id,omitempty"`
 		foreignProjectID string
+		foreignUserID    string
 	}
 
 	TimeEntry struct {
@@ -65,6 +101,12 @@ type (
 		foreignProjectID string
 	}
 
+	// ImportedTimeEntry is a time entry of a service to be created in Toggl
+	ImportedTimeEntry struct {
+		TimeEntry
+		ForeignID string `json:"foreign_id"`
+	}
+
 	AccountsResponse struct {
 		Error    string     `json:"error"`
 		Accounts []*Account `json:"accounts"`
@@ -73,6 +115,10 @@ type (
 	UsersResponse struct {
 		Error string  `json:"error"`
 		Users []*User `json:"users"`
+		// Deactivated users are connected but were removed from the service,
+		// Groups are synced too, both only when the pipe syncs users lifecycle
+		Deactivated []*User  `json:"deactivated,omitempty"`
+		Groups      []*Group `json:"groups,omitempty"`
 	}
 
 	ClientsResponse struct {
@@ -83,11 +129,35 @@ type (
 	ProjectsResponse struct {
 		Error    string     `json:"error"`
 		SupportsClient bool `json:"supports_client"`
+		// Fields are optional project fields the service has values for
+		Fields   []string   `json:"fields,omitempty"`
 		Projects []*Project `json:"projects"`
+		// Vanished projects are connected but weren't returned by the service anymore
+		Vanished []*Project `json:"vanished,omitempty"`
 	}
 
 	TasksResponse struct {
 		Error string  `json:"error"`
 		Tasks []*Task `json:"tasks"`
+		// Fields are optional task fields the service has values for
+		Fields []string `json:"fields,omitempty"`
+		// Vanished tasks are connected but weren't returned by the service anymore
+		Vanished []*Task `json:"vanished,omitempty"`
+		// UnlinkedAssignees are foreign IDs of tasks assigned to users
+		// who aren't Toggl users yet, their Toggl user isn't overwritten
+		UnlinkedAssignees []string `json:"unlinked_assignees,omitempty"`
+	}
+
+	TagsResponse struct {
+		Error string `json:"error"`
+		Tags  []*Tag `json:"tags"`
+	}
+
+	TimeEntriesResponse struct {
+		Error       string               `json:"error"`
+		TimeEntries []*ImportedTimeEntry `json:"time_entries"`
+		// UnmappedProjects are names of projects which aren't imported yet,
+		// their time entries are skipped
+		UnmappedProjects []string `json:"unmapped_projects,omitempty"`
 	}
 )
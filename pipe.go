@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +18,12 @@ type Pipe struct {
 	Premium         bool        `json:"premium"`
 	PipeStatus      *PipeStatus `json:"pipe_status,omitempty"`
 	ServiceParams   []byte      `json:"service_params,omitempty"`
+	DeletionPolicy  string      `json:"deletion_policy,omitempty"`
+	ProjectFields   []string    `json:"project_fields,omitempty"`
+	// UsersLifecycle deactivates removed users and syncs groups in users pipes
+	UsersLifecycle bool `json:"users_lifecycle,omitempty"`
+	// ProjectMembers syncs members of projects in projects pipes
+	ProjectMembers bool `json:"project_members,omitempty"`
 
 	authorization *Authorization
 	workspaceID   int
@@ -26,55 +31,9 @@ type Pipe struct {
 	key           string
 	payload       []byte
 	lastSync      *time.Time
+	syncRun       *SyncRun
 }
 
-const (
-	selectPipesSQL = `SELECT workspace_id, key, data
-    FROM pipes WHERE workspace_id = $1
-  `
-	singlePipesSQL = `SELECT workspace_id, key, data
-    FROM pipes WHERE workspace_id = $1
-    AND key = $2 LIMIT 1
-  `
-	deletePipeSQL = `DELETE FROM pipes
-    WHERE workspace_id = $1
-    AND key LIKE $2
-  `
-	insertPipesSQL = `
-    WITH existing_pipe AS (
-      UPDATE pipes SET data = $3
-      WHERE workspace_id = $1 AND key = $2
-      RETURNING key
-    ),
-    inserted_pipe AS (
-      INSERT INTO pipes(workspace_id, key, data)
-      SELECT $1, $2, $3
-      WHERE NOT EXISTS (SELECT 1 FROM existing_pipe)
-      RETURNING key
-    )
-    SELECT * FROM inserted_pipe
-    UNION
-    SELECT * FROM existing_pipe
-  `
-	deletePipeConnectionsSQL = `DELETE FROM connections
-    WHERE workspace_id = $1
-    AND key = $2
-  `
-	selectPipesFromQueueSQL = `SELECT workspace_id, key
-	FROM get_queued_pipes()`
-
-	queueAutomaticPipesSQL = `SELECT queue_automatic_pipes()`
-
-	queuePipeAsFirstSQL = `SELECT queue_pipe_as_first($1, $2)`
-
-	setQueuedPipeSyncedSQL = `UPDATE queued_pipes
-	SET synced_at = now()
-	WHERE workspace_id = $1
-	AND key = $2
-	AND locked_at IS NOT NULL
-	AND synced_at IS NULL`
-)
-
 func NewPipe(workspaceID int, serviceID, pipeID string) *Pipe {
 	return &Pipe{
 		ID:          pipeID,
@@ -90,15 +49,7 @@ func pipesKey(serviceID, pipeID string) string {
 
 func (p *Pipe) save() error {
 	p.Configured = true
-	b, err := json.Marshal(p)
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec(insertPipesSQL, p.workspaceID, p.key, b)
-	if err != nil {
-		return err
-	}
-	return nil
+	return store.SavePipe(p)
 }
 
 func (p *Pipe) validateServiceConfig(payload []byte) string {
@@ -112,28 +63,22 @@ func (p *Pipe) validateServiceConfig(payload []byte) string {
 }
 
 func (p *Pipe) validatePayload(payload []byte) string {
-	if p.ID == "users" && len(payload) == 0 {
+	if p.ID == "users" && len(payload) == 0 && !p.UsersLifecycle {
 		return "Missing request payload"
 	}
 	p.payload = payload
 	return ""
 }
 
-func (p *Pipe) load(rows *sql.Rows) error {
-	var wid int
-	var b []byte
-	var key string
-	if err := rows.Scan(&wid, &key, &b); err != nil {
-		return err
-	}
-	err := json.Unmarshal(b, p)
-	if err != nil {
-		return err
+func decodePipe(workspaceID int, key string, b []byte) (*Pipe, error) {
+	var pipe Pipe
+	if err := json.Unmarshal(b, &pipe); err != nil {
+		return nil, err
 	}
-	p.key = key
-	p.workspaceID = wid
-	p.serviceID = strings.Split(key, ":")[0]
-	return nil
+	pipe.key = key
+	pipe.workspaceID = workspaceID
+	pipe.serviceID = strings.Split(key, ":")[0]
+	return &pipe, nil
 }
 
 func (p *Pipe) NewStatus() error {
@@ -169,6 +114,9 @@ func (p *Pipe) loadAuth() error {
 func (p *Pipe) run() {
 	var err error
 	defer func() {
+		if err := p.saveSyncRun(); err != nil {
+			BugsnagNotifyPipe(p, err)
+		}
 		p.endSync(true, err)
 	}()
 
@@ -180,6 +128,11 @@ func (p *Pipe) run() {
 		BugsnagNotifyPipe(p, err)
 		return
 	}
+	p.startSyncRun()
+	if err = migrateFreshbooksConnections(p); err != nil {
+		BugsnagNotifyPipe(p, err)
+		return
+	}
 	if err = p.fetchObjects(false); err != nil {
 		BugsnagNotifyPipe(p, err)
 		return
@@ -191,8 +144,9 @@ func (p *Pipe) run() {
 }
 
 func (p *Pipe) loadLastSync() {
-	err := db.QueryRow(lastSyncSQL, p.workspaceID, p.key).Scan(&p.lastSync)
-	if err != nil {
+	lastSync, err := store.LoadLastSync(p.workspaceID, p.key)
+	p.lastSync = lastSync
+	if err != nil || lastSync == nil {
 		var err error
 		t := time.Now()
 		date := struct {
@@ -222,6 +176,10 @@ func (p *Pipe) fetchObjects(saveStatus bool) (err error) {
 		err = fetchTasks(p)
 	case "timeentries":
 		err = fetchTimeEntries(p)
+	case importedTimeEntriesPipeID:
+		err = fetchImportedTimeEntries(p)
+	case tagsPipeID:
+		err = fetchTags(p)
 	default:
 		panic(fmt.Sprintf("fetchObjects: Unrecognized pipeID - %s", p.ID))
 	}
@@ -240,6 +198,10 @@ func (p *Pipe) postObjects(saveStatus bool) (err error) {
 		err = postTasks(p)
 	case "timeentries":
 		err = postTimeEntries(p)
+	case importedTimeEntriesPipeID:
+		err = postImportedTimeEntries(p)
+	case tagsPipeID:
+		err = postTags(p)
 	default:
 		panic(fmt.Sprintf("postObjects: Unrecognized pipeID - %s", p.ID))
 	}
@@ -267,25 +229,7 @@ func (p *Pipe) endSync(saveStatus bool, err error) error {
 }
 
 func (p *Pipe) destroy(workspaceID int) error {
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	if _, err = tx.Exec(deletePipeSQL, workspaceID, p.key); err != nil {
-		rollbackErr := tx.Rollback()
-		if rollbackErr != nil {
-			return rollbackErr
-		}
-		return err
-	}
-	if _, err = tx.Exec(deletePipeStatusSQL, workspaceID, p.key); err != nil {
-		rollbackErr := tx.Rollback()
-		if rollbackErr != nil {
-			return rollbackErr
-		}
-		return err
-	}
-	return tx.Commit()
+	return store.DeletePipe(workspaceID, p.key)
 }
 
 func loadPipe(workspaceID int, serviceID, pipeID string) (*Pipe, error) {
@@ -294,107 +238,25 @@ func loadPipe(workspaceID int, serviceID, pipeID string) (*Pipe, error) {
 }
 
 func loadPipeWithKey(workspaceID int, key string) (*Pipe, error) {
-	rows, err := db.Query(singlePipesSQL, workspaceID, key)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	if !rows.Next() {
-		return nil, rows.Err()
-	}
-	var pipe Pipe
-	if err := pipe.load(rows); err != nil {
-		return nil, err
-	}
-	return &pipe, nil
+	return store.LoadPipe(workspaceID, key)
 }
 
 func loadPipes(workspaceID int) (map[string]*Pipe, error) {
-	pipes := make(map[string]*Pipe)
-	rows, err := db.Query(selectPipesSQL, workspaceID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var pipe Pipe
-		if err := pipe.load(rows); err != nil {
-			return nil, err
-		}
-		pipes[pipe.key] = &pipe
-	}
-	return pipes, nil
+	return store.LoadPipes(workspaceID)
 }
 
-func (p *Pipe) clearPipeConnections() (err error) {
+func (p *Pipe) clearPipeConnections() error {
 	s, err := p.Service()
 	if err != nil {
-		return
-	}
-
-	pipeStatus, err := loadPipeStatus(p.workspaceID, p.serviceID, p.ID)
-	if err != nil {
-		return
-	}
-
-	key := s.keyFor(p.ID)
-
-	tx, err := db.Begin()
-	if err != nil {
-		return
-	}
-	_, err = tx.Exec(deletePipeConnectionsSQL, p.workspaceID, key)
-	if err != nil {
-		rollbackErr := tx.Rollback()
-		if rollbackErr != nil {
-			err = rollbackErr
-		}
-
-		return
-	}
-	_, err = tx.Exec(deletePipeStatusSQL, p.workspaceID, pipeStatus.key)
-	if err != nil {
-		rollbackErr := tx.Rollback()
-		if rollbackErr != nil {
-			err = rollbackErr
-		}
-
+		return err
 	}
-
-	return
+	return store.ClearConnection(p.workspaceID, s.keyFor(p.ID), p.key)
 }
 
 func getPipesFromQueue() ([]*Pipe, error) {
-	var pipes []*Pipe
-	rows, err := db.Query(selectPipesFromQueueSQL)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var workspaceID int
-		var key string
-		err := rows.Scan(&workspaceID, &key)
-		if err != nil {
-			return nil, err
-		}
-
-		if workspaceID > 0 && len(key) > 0 {
-			pipe, err := loadPipeWithKey(workspaceID, key)
-			if err != nil {
-				return nil, err
-			}
-			pipes = append(pipes, pipe)
-		}
-	}
-	return pipes, nil
+	return store.LoadQueuedPipes()
 }
 
 func setQueuedPipeSynced(pipe *Pipe) error {
-	_, err := db.Exec(setQueuedPipeSyncedSQL, pipe.workspaceID, pipe.key)
-	return err
+	return store.SetQueuedPipeSynced(pipe)
 }
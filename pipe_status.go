@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -15,6 +14,8 @@ type PipeStatus struct {
 	SyncDate      string   `json:"sync_date,omitempty"`
 	ObjectCounts  []string `json:"object_counts,omitempty"`
 	Notifications []string `json:"notifications,omitempty"`
+	RepairedLinks int      `json:"repaired_links,omitempty"`
+	RunID         int      `json:"run_id,omitempty"`
 
 	workspaceID int
 	serviceID   string
@@ -22,43 +23,7 @@ type PipeStatus struct {
 	key         string
 }
 
-const (
-	startStatus         = "running"
-	selectPipeStatusSQL = `SELECT key, data
-    FROM pipes_status
-    WHERE workspace_id = $1
-  `
-	singlePipeStatusSQL = `SELECT data
-    FROM pipes_status
-    WHERE workspace_id = $1
-    AND key = $2 LIMIT 1
-  `
-	deletePipeStatusSQL = `DELETE FROM pipes_status
-		WHERE workspace_id = $1
-		AND key LIKE $2
-  `
-	lastSyncSQL = `SELECT (data->>'sync_date')::timestamp with time zone
-    FROM pipes_status
-    WHERE workspace_id = $1
-    AND key = $2
-  `
-	insertPipeStatusSQL = `
-    WITH existing_status AS (
-      UPDATE pipes_status SET data = $3
-      WHERE workspace_id = $1 AND key = $2
-      RETURNING key
-    ),
-    inserted_status AS (
-      INSERT INTO pipes_status(workspace_id, key, data)
-      SELECT $1, $2, $3
-      WHERE NOT EXISTS (SELECT 1 FROM existing_status)
-      RETURNING key
-    )
-    SELECT * FROM inserted_status
-    UNION
-    SELECT * FROM existing_status
-  `
-)
+const startStatus = "running"
 
 func NewPipeStatus(workspaceID int, serviceID, pipeID string) *PipeStatus {
 	return &PipeStatus{
@@ -78,30 +43,26 @@ func (p *PipeStatus) save() error {
 		} else {
 			p.Message = fmt.Sprintf("No new %s were imported/exported", p.pipeID)
 		}
+		if p.RepairedLinks > 0 {
+			p.Message += fmt.Sprintf(", %d links to objects deleted in Toggl were repaired", p.RepairedLinks)
+		}
 	}
-	b, err := json.Marshal(p)
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec(insertPipeStatusSQL, p.workspaceID, p.key, b)
-	if err != nil {
-		return err
-	}
-	return nil
+	return store.SavePipeStatus(p)
 }
 
-func (p *PipeStatus) load(rows *sql.Rows) error {
-	var b []byte
-	var key string
-	if err := rows.Scan(&key, &b); err != nil {
-		return err
+func decodePipeStatus(workspaceID int, key string, b []byte) (*PipeStatus, error) {
+	var pipeStatus PipeStatus
+	if err := json.Unmarshal(b, &pipeStatus); err != nil {
+		return nil, err
 	}
-	err := json.Unmarshal(b, p)
-	if err != nil {
-		return err
+	ids := strings.SplitN(key, ":", 2)
+	pipeStatus.workspaceID = workspaceID
+	pipeStatus.serviceID = ids[0]
+	if len(ids) > 1 {
+		pipeStatus.pipeID = ids[1]
 	}
-	p.key = key
-	return nil
+	pipeStatus.key = key
+	return &pipeStatus, nil
 }
 
 func (p *PipeStatus) addError(err error) {
@@ -131,42 +92,9 @@ func (p *PipeStatus) generateLog() string {
 }
 
 func loadPipeStatus(workspaceID int, serviceID, pipeID string) (*PipeStatus, error) {
-	key := pipesKey(serviceID, pipeID)
-	rows, err := db.Query(singlePipeStatusSQL, workspaceID, key)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	if !rows.Next() {
-		return nil, rows.Err()
-	}
-	var b []byte
-	if err := rows.Scan(&b); err != nil {
-		return nil, err
-	}
-	var pipeStatus PipeStatus
-	if err = json.Unmarshal(b, &pipeStatus); err != nil {
-		return nil, err
-	}
-	pipeStatus.workspaceID = workspaceID
-	pipeStatus.serviceID = serviceID
-	pipeStatus.pipeID = pipeID
-	return &pipeStatus, nil
+	return store.LoadPipeStatus(workspaceID, pipesKey(serviceID, pipeID))
 }
 
 func loadPipeStatuses(workspaceID int) (map[string]*PipeStatus, error) {
-	pipeStatuses := make(map[string]*PipeStatus)
-	rows, err := db.Query(selectPipeStatusSQL, workspaceID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var pipeStatus PipeStatus
-		if err := pipeStatus.load(rows); err != nil {
-			return nil, err
-		}
-		pipeStatuses[pipeStatus.key] = &pipeStatus
-	}
-	return pipeStatuses, nil
+	return store.LoadPipeStatuses(workspaceID)
 }
@@ -46,36 +46,42 @@ func TestPipeEndSyncJSONParsingFail(t *testing.T) {
 	}
 }
 
+func TestDeleteServicePipesKeepsOtherServices(t *testing.T) {
+	for _, serviceID := range []string{"asana", "asana2"} {
+		if err := NewPipe(62, serviceID, projectsPipeID).save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteServicePipes(62, "asana"); err != nil {
+		t.Fatal(err)
+	}
+	pipes, err := store.LoadPipes(62)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipes) != 1 || pipes[pipesKey("asana2", projectsPipeID)] == nil {
+		t.Errorf("expected only the pipe of asana2 to be kept, got %v", pipes)
+	}
+}
+
 func TestGetPipesFromQueue_DoesNotReturnMultipleSameWorkspace(t *testing.T) {
-	db = connectDB(testDBConnString)
-	createAndEnqueuePipeFn := func(workspaceID int, serviceID, pipeID string, priority int) *Pipe {
+	createAndEnqueuePipeFn := func(workspaceID int, serviceID, pipeID string) *Pipe {
 		pipe := NewPipe(workspaceID, serviceID, pipeID)
 		pipe.Automatic = true
-		pipe.Configured = true
-		data, err := json.Marshal(pipe)
-		if err != nil {
+		if err := pipe.save(); err != nil {
 			t.Error(err)
-			return nil
 		}
-		_, err = db.Exec(`
-			with created as (
-				insert into pipes(workspace_id, key, data)
-				values ($1, $2, $3)
-				returning *
-			)
-			insert into queued_pipes(workspace_id, key, priority)
-			select created.workspace_id, created.key, $4 from created
-		`, pipe.workspaceID, pipe.key, data, priority)
-		if err != nil {
+		if err := store.QueuePipeAsFirst(pipe.workspaceID, pipe.key); err != nil {
 			t.Error(err)
 		}
 		return pipe
 	}
 
-	createAndEnqueuePipeFn(1, "asana", "users", 0)
-	createAndEnqueuePipeFn(2, "asana", "projects", 10)
-	createAndEnqueuePipeFn(1, "asana", "projects", 0)
-	createAndEnqueuePipeFn(3, "asana", "projects", 100)
+	// every queued pipe gets higher priority than the previous ones
+	createAndEnqueuePipeFn(1, "asana", "users")
+	createAndEnqueuePipeFn(2, "asana", "projects")
+	createAndEnqueuePipeFn(1, "asana", "projects")
+	createAndEnqueuePipeFn(3, "asana", "projects")
 
 	// first fetch should return 3 pipes and unique per workspace
 	pipes, err := getPipesFromQueue()
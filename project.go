@@ -1,9 +1,41 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Optional project fields, a pipe syncs only the fields chosen in its setup
+// and leaves the others under Toggl's control
+const (
+	projectFieldColor          = "color"
+	projectFieldStartDate      = "start_date"
+	projectFieldDueDate        = "due_date"
+	projectFieldEstimatedHours = "estimated_hours"
+	projectFieldRate           = "rate"
+	projectFieldTemplate       = "template"
+)
+
+// projectMembersField is overwritten in Toggl when the pipe syncs project members,
+// it's not an optional field as members are fetched only when synced
+const projectMembersField = "user_ids"
+
+var projectFields = []string{
+	projectFieldColor,
+	projectFieldStartDate,
+	projectFieldDueDate,
+	projectFieldEstimatedHours,
+	projectFieldRate,
+	projectFieldTemplate,
+}
+
 type (
 	projectRequest struct {
-		Projects []*Project `json:"projects"`
-		SupportsClient bool `json:"supports_client"`
+		Projects       []*Project `json:"projects"`
+		SupportsClient bool       `json:"supports_client"`
+		// Fields are overwritten in Toggl even when the project has no value
+		Fields []string `json:"fields,omitempty"`
 	}
 
 	ProjectsImport struct {
@@ -15,3 +47,148 @@ type (
 func (p *ProjectsImport) Count() int {
 	return len(p.Projects)
 }
+
+func isValidProjectField(field string) bool {
+	for _, f := range projectFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// has tells whether the project has a value for the optional field
+func (p *Project) has(field string) bool {
+	switch field {
+	case projectFieldColor:
+		return p.Color != ""
+	case projectFieldStartDate:
+		return p.StartDate != ""
+	case projectFieldDueDate:
+		return p.DueDate != ""
+	case projectFieldEstimatedHours:
+		return p.EstimatedHours != 0
+	case projectFieldRate:
+		return p.Rate != 0
+	case projectFieldTemplate:
+		return p.Template
+	}
+	return false
+}
+
+func (p *Project) clear(field string) {
+	switch field {
+	case projectFieldColor:
+		p.Color = ""
+	case projectFieldStartDate:
+		p.StartDate = ""
+	case projectFieldDueDate:
+		p.DueDate = ""
+	case projectFieldEstimatedHours:
+		p.EstimatedHours = 0
+	case projectFieldRate:
+		p.Rate = 0
+	case projectFieldTemplate:
+		p.Template = false
+	}
+}
+
+// availableProjectFields returns optional fields the service has values for
+func availableProjectFields(projects []*Project) []string {
+	var fields []string
+	for _, field := range projectFields {
+		for _, project := range projects {
+			if project.has(field) {
+				fields = append(fields, field)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+func (p *Pipe) syncsProjectField(field string) bool {
+	for _, f := range p.ProjectFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// withProjectFields clears the fields which are not synced by the pipe,
+// templates are left out unless the template field is synced
+func (p *Pipe) withProjectFields(projects []*Project) []*Project {
+	synced := make([]*Project, 0, len(projects))
+	for _, project := range projects {
+		if project.Template && !p.syncsProjectField(projectFieldTemplate) {
+			continue
+		}
+		for _, field := range projectFields {
+			if !p.syncsProjectField(field) {
+				project.clear(field)
+			}
+		}
+		synced = append(synced, project)
+	}
+	return synced
+}
+
+// requestFields returns fields of projects which are overwritten in Toggl
+func (p *Pipe) requestFields() []string {
+	if !p.ProjectMembers {
+		return p.ProjectFields
+	}
+	fields := make([]string, 0, len(p.ProjectFields)+1)
+	return append(append(fields, p.ProjectFields...), projectMembersField)
+}
+
+// fetchProjectMembers sets foreign IDs of project members,
+// projects of services without members have none
+func fetchProjectMembers(s Service, projects []*Project) error {
+	members, err := s.ProjectMembers()
+	if errors.Is(err, ErrNotSupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		project.ForeignUserIDs = members[project.ForeignID]
+	}
+	return nil
+}
+
+// linkProjectMembers sets Toggl IDs of project members through users connection,
+// members who are not Toggl users yet are left out and notified about
+func linkProjectMembers(s Service, projects []*Project) ([]string, error) {
+	connection, err := loadConnection(s, usersPipeID)
+	if err != nil {
+		return nil, err
+	}
+	names, err := foreignNames(s, usersPipeID)
+	if err != nil {
+		return nil, err
+	}
+	var notifications []string
+	for _, project := range projects {
+		project.UserIDs = make([]int, 0, len(project.ForeignUserIDs))
+		var missing []string
+		for _, foreignID := range project.ForeignUserIDs {
+			if userID := connection.Data[foreignID]; userID > 0 {
+				project.UserIDs = append(project.UserIDs, userID)
+				continue
+			}
+			name := names[foreignID]
+			if name == "" {
+				name = foreignID
+			}
+			missing = append(missing, name)
+		}
+		if len(missing) > 0 {
+			notifications = append(notifications, fmt.Sprintf("Members of project '%s' are not Toggl users yet: %s",
+				project.Name, strings.Join(missing, ", ")))
+		}
+	}
+	return notifications, nil
+}
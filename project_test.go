@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestProjectFields(t *testing.T) {
+	projects := []*Project{
+		{ForeignID: "1", Name: "Website", Color: "#4573d2", DueDate: "2026-12-01", Rate: 90},
+		{ForeignID: "2", Name: "Onboarding", Template: true},
+	}
+	fields := availableProjectFields(projects)
+	if !reflect.DeepEqual(fields, []string{projectFieldColor, projectFieldDueDate, projectFieldRate, projectFieldTemplate}) {
+		t.Errorf("unexpected available fields %v", fields)
+	}
+
+	p := NewPipe(1, TestServiceName, projectsPipeID)
+	p.ProjectFields = []string{projectFieldDueDate}
+	synced := p.withProjectFields(projects)
+	if len(synced) != 1 {
+		t.Fatalf("expected template to be left out, got %+v", synced)
+	}
+	want := Project{ForeignID: "1", Name: "Website", DueDate: "2026-12-01"}
+	if !reflect.DeepEqual(*synced[0], want) {
+		t.Errorf("expected only due date to be synced, got %+v", *synced[0])
+	}
+}
+
+func TestProjectMembers(t *testing.T) {
+	ts := newAsanaTestServer(t)
+	defer ts.Close()
+	defer func(apiURL string) { asanaAPIURL = apiURL }(asanaAPIURL)
+	asanaAPIURL = ts.URL + "/"
+
+	authorization := NewAuthorization(51, "asana")
+	authorization.WorkspaceToken = "toggl"
+	authorization.Data = []byte(`{"AccessToken":"token"}`)
+	if err := authorization.save(); err != nil {
+		t.Fatal(err)
+	}
+	p := NewPipe(51, "asana", projectsPipeID)
+	p.ServiceParams = []byte(`{"account_id":2}`)
+	p.ProjectMembers = true
+	p.authorization = authorization
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewConnection(s, usersPipeID)
+	users.Data["7"] = 70
+	if err := users.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewConnection(s, projectsPipeID).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fetchProjects(p); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeTogglAPI{responses: map[string][]byte{
+		projectsPipeID: []byte(`{"projects":[{"id":21,"name":"Website","foreign_id":"1"},{"id":22,"name":"Ads","foreign_id":"2"}]}`),
+	}}
+	defer withFakeTogglAPI(fake)()
+	if err := postProjects(p); err != nil {
+		t.Fatal(err)
+	}
+	var request projectRequest
+	if err := json.Unmarshal(fake.payloads[projectsPipeID], &request); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(request.Fields, []string{projectMembersField}) {
+		t.Errorf("expected members to be overwritten in Toggl, got %v", request.Fields)
+	}
+	if len(request.Projects) != 2 || !reflect.DeepEqual(request.Projects[0].UserIDs, []int{70}) || len(request.Projects[1].UserIDs) != 0 {
+		t.Errorf("expected linked members to be posted, got %s", fake.payloads[projectsPipeID])
+	}
+	want := []string{"Members of project 'Website' are not Toggl users yet: 8"}
+	if !reflect.DeepEqual(p.PipeStatus.Notifications, want) {
+		t.Errorf("expected notification about member without Toggl user, got %v", p.PipeStatus.Notifications)
+	}
+}
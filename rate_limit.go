@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// serviceRequestsPerSecond is the sustained request rate allowed
+	// against each provider, shared by all workspaces and workers.
+	serviceRequestsPerSecond = map[string]float64{
+		"asana":      2.5,
+		"basecamp":   4,
+		"freshbooks": 2,
+		"teamweek":   5,
+		"github":     1.3,
+	}
+	defaultRequestsPerSecond = 5.0
+
+	// maxWorkspaceRequests caps concurrent requests one workspace
+	// can have in flight against a single provider.
+	maxWorkspaceRequests = 2
+
+	maxServiceRetries  = 4
+	serviceRetryBase   = 500 * time.Millisecond
+	serviceRetryMax    = 60 * time.Second
+	serviceLimitersMux sync.Mutex
+	serviceLimiters    = map[string]*serviceLimiter{}
+	workspaceSlots     = map[string]chan struct{}{}
+)
+
+// serviceLimiter spaces out requests made to one provider
+// and holds all of them back when the provider asks us to slow down.
+type serviceLimiter struct {
+	sync.Mutex
+	interval     time.Duration
+	next         time.Time
+	blockedUntil time.Time
+}
+
+func limiterFor(serviceID string) *serviceLimiter {
+	serviceLimitersMux.Lock()
+	defer serviceLimitersMux.Unlock()
+	limiter, exists := serviceLimiters[serviceID]
+	if !exists {
+		rate, found := serviceRequestsPerSecond[serviceID]
+		if !found {
+			rate = defaultRequestsPerSecond
+		}
+		limiter = &serviceLimiter{interval: time.Duration(float64(time.Second) / rate)}
+		serviceLimiters[serviceID] = limiter
+	}
+	return limiter
+}
+
+func workspaceSlotFor(serviceID string, workspaceID int) chan struct{} {
+	key := fmt.Sprintf("%s:%d", serviceID, workspaceID)
+	serviceLimitersMux.Lock()
+	defer serviceLimitersMux.Unlock()
+	slot, exists := workspaceSlots[key]
+	if !exists {
+		slot = make(chan struct{}, maxWorkspaceRequests)
+		workspaceSlots[key] = slot
+	}
+	return slot
+}
+
+// wait blocks until the next request to the provider is allowed
+func (l *serviceLimiter) wait() {
+	l.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	if start.Before(l.blockedUntil) {
+		start = l.blockedUntil
+	}
+	l.next = start.Add(l.interval)
+	l.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// pause holds back every request to the provider for the given duration
+func (l *serviceLimiter) pause(d time.Duration) {
+	l.Lock()
+	if until := time.Now().Add(d); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+	l.Unlock()
+}
+
+// rateLimitedTransport is used as the HTTP transport of service clients
+type rateLimitedTransport struct {
+	serviceID   string
+	workspaceID int
+	base        http.RoundTripper
+}
+
+func newRateLimitedTransport(serviceID string, workspaceID int) *rateLimitedTransport {
+	return &rateLimitedTransport{
+		serviceID:   serviceID,
+		workspaceID: workspaceID,
+		base:        http.DefaultTransport,
+	}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	slot := workspaceSlotFor(t.serviceID, t.workspaceID)
+	limiter := limiterFor(t.serviceID)
+	for attempt := 0; ; attempt++ {
+		// the slot is released during backoff, so other requests of the workspace can go on
+		slot <- struct{}{}
+		limiter.wait()
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			<-slot
+			return nil, err
+		}
+
+		if d, limited := providerRateLimitDelay(resp); limited {
+			limiter.pause(d)
+		}
+		if !shouldRetryStatus(req.Method, resp.StatusCode) || attempt >= maxServiceRetries {
+			return slotReleasingResponse(resp, slot), nil
+		}
+
+		// Request body was already consumed, it can be retried only if it can be recreated
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return slotReleasingResponse(resp, slot), nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return slotReleasingResponse(resp, slot), nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		delay := backoffDelay(attempt)
+		if d, found := retryAfter(resp); found && d > delay {
+			delay = d
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			limiter.pause(delay)
+		}
+		resp.Body.Close()
+		<-slot
+		metrics.inc("service_retries_" + t.serviceID)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// slotReleasingBody releases the workspace slot once the body is closed,
+// so reading the body counts against the limit too
+type slotReleasingBody struct {
+	io.ReadCloser
+	release sync.Once
+	slot    chan struct{}
+}
+
+func (b *slotReleasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release.Do(func() { <-b.slot })
+	return err
+}
+
+func slotReleasingResponse(resp *http.Response, slot chan struct{}) *http.Response {
+	resp.Body = &slotReleasingBody{ReadCloser: resp.Body, slot: slot}
+	return resp
+}
+
+// ServiceStatusError is returned by connectors whose client library
+// reports an unexpected HTTP status only as an error
+type ServiceStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *ServiceStatusError) Error() string {
+	return e.Status
+}
+
+// withServiceRetry applies the same limits to services whose client
+// library doesn't allow replacing its HTTP client. fn must return
+// a ServiceStatusError for the status to be retried.
+func withServiceRetry(s Service, idempotent bool, fn func() error) error {
+	slot := workspaceSlotFor(s.Name(), s.WorkspaceID())
+	method := http.MethodGet
+	if !idempotent {
+		method = http.MethodPost
+	}
+	limiter := limiterFor(s.Name())
+	for attempt := 0; ; attempt++ {
+		slot <- struct{}{}
+		limiter.wait()
+		err := fn()
+		<-slot
+		if err == nil || attempt >= maxServiceRetries {
+			return err
+		}
+		var statusErr *ServiceStatusError
+		if !errors.As(err, &statusErr) || !shouldRetryStatus(method, statusErr.StatusCode) {
+			return err
+		}
+		delay := backoffDelay(attempt)
+		if statusErr.StatusCode == http.StatusTooManyRequests {
+			limiter.pause(delay)
+		}
+		metrics.inc("service_retries_" + s.Name())
+		time.Sleep(delay)
+	}
+}
+
+func shouldRetryStatus(method string, statusCode int) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if statusCode < 500 || statusCode == http.StatusNotImplemented {
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// backoffDelay is exponential backoff with full jitter
+func backoffDelay(attempt int) time.Duration {
+	d := serviceRetryBase << uint(attempt)
+	if d > serviceRetryMax || d <= 0 {
+		d = serviceRetryMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return capDelay(time.Duration(seconds) * time.Second), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return capDelay(time.Until(t)), true
+	}
+	return 0, false
+}
+
+// providerRateLimitDelay reads provider specific headers telling
+// that the quota is used up, even when the request itself succeeded.
+func providerRateLimitDelay(resp *http.Response) (time.Duration, bool) {
+	// Github and Teamweek style: X-RateLimit-Remaining / X-RateLimit-Reset (unix time)
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return capDelay(time.Until(time.Unix(reset, 0))), true
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return retryAfter(resp)
+	}
+	return 0, false
+}
+
+func capDelay(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d > serviceRetryMax {
+		return serviceRetryMax
+	}
+	return d
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedTransportRetries(t *testing.T) {
+	defer func(base time.Duration) { serviceRetryBase = base }(serviceRetryBase)
+	serviceRetryBase = time.Millisecond
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: newRateLimitedTransport("rate_limit_test", 1)}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 but got %d", resp.StatusCode)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls but got %d", calls)
+	}
+}
+
+func TestRateLimitedTransportDoesNotRetryPostOnServerError(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: newRateLimitedTransport("rate_limit_test", 2)}
+	resp, err := client.Post(ts.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	resp.Body.Close()
+	if calls != 1 {
+		t.Errorf("Expected 1 call but got %d", calls)
+	}
+}
+
+func TestServiceRetryReleasesSlotDuringBackoff(t *testing.T) {
+	defer func(base time.Duration) { serviceRetryBase = base }(serviceRetryBase)
+	serviceRetryBase = time.Second
+
+	s := &TestService{workspaceID: 3}
+	slot := workspaceSlotFor(s.Name(), s.WorkspaceID())
+	var calls int
+	slotsInBackoff := make(chan int, 1)
+	err := withServiceRetry(s, true, func() error {
+		calls++
+		switch calls {
+		case 1:
+			time.AfterFunc(20*time.Millisecond, func() { slotsInBackoff <- len(slot) })
+			return &ServiceStatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+		case 2:
+			return errors.New("503 reworded by the connector")
+		}
+		return nil
+	})
+	if err == nil || calls != 2 {
+		t.Errorf("expected only typed status errors to be retried, got %d calls and %v", calls, err)
+	}
+	if held := <-slotsInBackoff; held != 0 {
+		t.Errorf("expected workspace slot to be released during backoff, %d were held", held)
+	}
+}
+
+func TestRateLimitedTransportHoldsSlotUntilBodyIsClosed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: newRateLimitedTransport("rate_limit_test", 3)}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	slot := workspaceSlotFor("rate_limit_test", 3)
+	if len(slot) != 1 {
+		t.Errorf("Expected the slot to be held while the body is read, got %d", len(slot))
+	}
+	resp.Body.Close()
+	resp.Body.Close()
+	if len(slot) != 0 {
+		t.Errorf("Expected the slot to be released once the body is closed, got %d", len(slot))
+	}
+}
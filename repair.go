@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// linkRepair posts a batch of objects to the Toggl pipes API and repairs
+// links to Toggl objects which have been deleted in Toggl.
+// When Toggl responds with "not found" the batch is split in halves until
+// the failing objects are isolated. A failing object with a Toggl ID is
+// unlinked and posted again so that Toggl re-creates it, if that fails too
+// the link is restored and the object is skipped.
+// Splitting is limited by maxRepairDepth and maxRepairPosts, beyond them
+// the "not found" error is returned.
+type linkRepair struct {
+	// post sends objects[from:to]
+	post func(from, to int) ([]byte, error)
+	// handle processes a successful response,
+	// it returns notifications of the response and count of imported objects
+	handle func(b []byte) ([]string, int, error)
+	// unlink drops the link of object i and returns a func restoring it,
+	// nil when object i wasn't linked
+	unlink func(i int) (restore func())
+	// describe names object i in notifications
+	describe func(i int) string
+
+	relinked      []int
+	dropped       []int
+	responseNotes []string
+	count         int
+	repairPosts   int
+}
+
+const (
+	maxRepairDepth = 12
+	maxRepairPosts = 64
+)
+
+// unlinkObject drops the link of an object whose Toggl ID is *id,
+// see linkRepair.unlink
+func unlinkObject(connection *Connection, foreignID string, id *int) func() {
+	togglID, linked := connection.Data[foreignID]
+	previousID := *id
+	delete(connection.Data, foreignID)
+	*id = 0
+	restore := func() {
+		*id = previousID
+		if linked {
+			connection.Data[foreignID] = togglID
+		}
+	}
+	if previousID == 0 {
+		restore()
+		return nil
+	}
+	return restore
+}
+
+func isTogglNotFound(err error) bool {
+	if errors.Is(err, ErrTogglNotFound) {
+		return true
+	}
+	var togglErr *TogglError
+	return errors.As(err, &togglErr) &&
+		togglErr.StatusCode < 500 &&
+		bytes.Contains(bytes.ToLower(togglErr.Body), []byte("not found"))
+}
+
+func (r *linkRepair) run(count int) error {
+	return r.postRange(0, count, 0)
+}
+
+func (r *linkRepair) postRange(from, to, depth int) error {
+	if depth > 0 {
+		r.repairPosts++
+	}
+	b, err := r.post(from, to)
+	if err == nil {
+		return r.handleResponse(b)
+	}
+	if !isTogglNotFound(err) || to == from {
+		return err
+	}
+	if depth >= maxRepairDepth || r.repairPosts >= maxRepairPosts {
+		return err
+	}
+	if to-from > 1 {
+		middle := (from + to) / 2
+		if err := r.postRange(from, middle, depth+1); err != nil {
+			return err
+		}
+		return r.postRange(middle, to, depth+1)
+	}
+
+	if restore := r.unlink(from); restore != nil {
+		r.repairPosts++
+		b, err = r.post(from, to)
+		if err == nil {
+			r.relinked = append(r.relinked, from)
+			return r.handleResponse(b)
+		}
+		// the link wasn't the cause, so it's kept for the next sync
+		restore()
+		if !isTogglNotFound(err) {
+			return err
+		}
+	}
+	r.dropped = append(r.dropped, from)
+	return nil
+}
+
+func (r *linkRepair) handleResponse(b []byte) error {
+	notifications, count, err := r.handle(b)
+	if err != nil {
+		return err
+	}
+	r.responseNotes = append(r.responseNotes, notifications...)
+	r.count += count
+	return nil
+}
+
+func (r *linkRepair) repairedCount() int {
+	return len(r.relinked) + len(r.dropped)
+}
+
+// notifications returns notifications of Toggl responses followed by repaired links
+func (r *linkRepair) notifications() []string {
+	notifications := r.responseNotes
+	for _, i := range r.relinked {
+		notifications = append(notifications, fmt.Sprintf("%s was deleted in Toggl and has been re-created", r.describe(i)))
+	}
+	for _, i := range r.dropped {
+		notifications = append(notifications, fmt.Sprintf("%s was skipped because Toggl could not find an object it refers to", r.describe(i)))
+	}
+	return notifications
+}
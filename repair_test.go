@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// newProjectsRepair posts projects to a fake Toggl which responds "not found"
+// for batches containing a project failing
+func newProjectsRepair(projects []*Project, connection *Connection, failing func(*Project) bool) *linkRepair {
+	nextID := 100
+	return &linkRepair{
+		post: func(from, to int) ([]byte, error) {
+			var imported ProjectsImport
+			for _, project := range projects[from:to] {
+				if failing(project) {
+					return nil, &TogglError{Method: "POST", URL: "/api/pipes/projects", StatusCode: http.StatusNotFound}
+				}
+			}
+			for _, project := range projects[from:to] {
+				id := project.ID
+				if id == 0 {
+					nextID++
+					id = nextID
+				}
+				imported.Projects = append(imported.Projects, &Project{ID: id, ForeignID: project.ForeignID})
+			}
+			return json.Marshal(imported)
+		},
+		handle: func(b []byte) ([]string, int, error) {
+			var imported ProjectsImport
+			if err := json.Unmarshal(b, &imported); err != nil {
+				return nil, 0, err
+			}
+			for _, project := range imported.Projects {
+				connection.Data[project.ForeignID] = project.ID
+			}
+			return imported.Notifications, imported.Count(), nil
+		},
+		unlink: func(i int) func() {
+			return unlinkObject(connection, projects[i].ForeignID, &projects[i].ID)
+		},
+		describe: func(i int) string { return projects[i].Name },
+	}
+}
+
+func TestLinkRepairRecreatesDeletedObjects(t *testing.T) {
+	deleted := map[int]bool{3: true, 7: true}
+	projects := []*Project{
+		{ID: 1, Name: "a", ForeignID: "a"},
+		{ID: 3, Name: "b", ForeignID: "b"},
+		{ID: 5, Name: "c", ForeignID: "c"},
+		{ID: 7, Name: "d", ForeignID: "d"},
+		{Name: "e", ForeignID: "e"},
+	}
+	connection := &Connection{Data: map[string]int{"a": 1, "b": 3, "c": 5, "d": 7}}
+	repair := newProjectsRepair(projects, connection, func(project *Project) bool { return deleted[project.ID] })
+
+	if err := repair.run(len(projects)); err != nil {
+		t.Fatal(err)
+	}
+	if repair.count != 5 {
+		t.Errorf("expected 5 imported projects, got %d", repair.count)
+	}
+	if repair.repairedCount() != 2 || len(repair.notifications()) != 2 {
+		t.Errorf("expected 2 repaired links, got %d: %v", repair.repairedCount(), repair.notifications())
+	}
+	if connection.Data["a"] != 1 || connection.Data["c"] != 5 {
+		t.Errorf("expected live links to be kept, got %v", connection.Data)
+	}
+	if connection.Data["b"] <= 100 || connection.Data["d"] <= 100 || connection.Data["e"] <= 100 {
+		t.Errorf("expected deleted projects to be re-created, got %v", connection.Data)
+	}
+}
+
+func TestLinkRepairKeepsLinkWhenRecreatingFails(t *testing.T) {
+	projects := []*Project{
+		{ID: 1, Name: "a", ForeignID: "a"},
+		{ID: 3, Name: "b", ForeignID: "b", ClientID: 9},
+	}
+	connection := &Connection{Data: map[string]int{"a": 1, "b": 3}}
+	// the client of "b" was deleted, not the project itself
+	repair := newProjectsRepair(projects, connection, func(project *Project) bool { return project.ClientID == 9 })
+
+	if err := repair.run(len(projects)); err != nil {
+		t.Fatal(err)
+	}
+	if len(repair.dropped) != 1 || len(repair.relinked) != 0 {
+		t.Errorf("expected project to be skipped, got %d dropped and %d relinked", len(repair.dropped), len(repair.relinked))
+	}
+	if connection.Data["b"] != 3 || projects[1].ID != 3 {
+		t.Errorf("expected link of skipped project to be restored, got %v", connection.Data)
+	}
+}
+
+func TestLinkRepairIsLimited(t *testing.T) {
+	projects := make([]*Project, 200)
+	connection := &Connection{Data: map[string]int{}}
+	for i := range projects {
+		projects[i] = &Project{ID: i + 1, ForeignID: strconv.Itoa(i)}
+		connection.Data[projects[i].ForeignID] = i + 1
+	}
+	var posts int
+	repair := newProjectsRepair(projects, connection, func(project *Project) bool { return true })
+	post := repair.post
+	repair.post = func(from, to int) ([]byte, error) {
+		posts++
+		return post(from, to)
+	}
+
+	if err := repair.run(len(projects)); !isTogglNotFound(err) {
+		t.Errorf("expected not found error once the repair is exhausted, got %v", err)
+	}
+	if posts > maxRepairPosts+2 {
+		t.Errorf("expected at most %d posts, got %d", maxRepairPosts+2, posts)
+	}
+	for _, project := range projects {
+		if connection.Data[project.ForeignID] != project.ID {
+			t.Fatalf("expected links to be kept, got %v", connection.Data)
+		}
+	}
+}
@@ -70,6 +70,10 @@ func noContent() Response {
 	return Response{http.StatusNoContent, nil, "application/json"}
 }
 
+func notFound(err string) Response {
+	return Response{http.StatusNotFound, err, "application/json"}
+}
+
 func badGateway(err string) Response {
 	return Response{http.StatusBadGateway, err, "application/json"}
 }
@@ -149,7 +153,7 @@ func withAuth(handler http.HandlerFunc) http.HandlerFunc {
 		}
 
 		var workspaceID int
-		workspaceID, err = getTogglWorkspaceID(authData.Username)
+		workspaceID, err = workspaceIDCache.workspaceID(authData.Username)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
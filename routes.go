@@ -21,6 +21,7 @@ func init() {
 	v1 := routes.Routes.PathPrefix("/api/v1").Subrouter()
 	v1.HandleFunc("/status", handleRequest(getStatus)).Methods("GET")
 	v1.HandleFunc("/integrations", withAuth(handleRequest(getIntegrations))).Methods("GET")
+	v1.HandleFunc("/imports/stats", withAuth(handleRequest(getImportStats))).Methods("GET")
 
 	v1.HandleFunc("/integrations/{service}/pipes/{pipe}", withAuth(handleRequest(getIntegrationPipe))).Methods("GET")
 	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/setup", withAuth(handleRequest(putPipeSetup))).Methods("PUT")
@@ -28,6 +29,15 @@ func init() {
 	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/setup", withAuth(handleRequest(deletePipeSetup))).Methods("DELETE")
 	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/log", withService(withAuth(handleRequest(getServicePipeLog)))).Methods("GET")
 	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/clear_connections", withService(withAuth(handleRequest(postServicePipeClearConnections)))).Methods("POST")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/connections", withService(withAuth(handleRequest(getServicePipeConnections)))).Methods("GET")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/connections", withService(withAuth(handleRequest(postServicePipeConnection)))).Methods("POST")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/connections/{foreign_id}", withService(withAuth(handleRequest(deleteServicePipeConnection)))).Methods("DELETE")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/connections/{foreign_id}/never_sync", withService(withAuth(handleRequest(putServicePipeNeverSync)))).Methods("PUT")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/connections/{foreign_id}/never_sync", withService(withAuth(handleRequest(deleteServicePipeNeverSync)))).Methods("DELETE")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/suggestions", withService(withAuth(handleRequest(getServicePipeSuggestions)))).Methods("GET")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/suggestions", withService(withAuth(handleRequest(postServicePipeSuggestions)))).Methods("POST")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/runs", withService(withAuth(handleRequest(getServicePipeRuns)))).Methods("GET")
+	v1.HandleFunc("/integrations/{service}/pipes/{pipe}/runs/{run}/revert", withService(withAuth(handleRequest(postServicePipeRunRevert)))).Methods("POST")
 
 	v1.HandleFunc("/integrations/{service}/accounts", withAuth(handleRequest(getServiceAccounts))).Methods("GET")
 	v1.HandleFunc("/integrations/{service}/auth_url", withAuth(handleRequest(getAuthURL))).Methods("GET")
@@ -40,6 +50,14 @@ func init() {
 	http.Handle("/", routes)
 }
 
+// metricsHandler serves process wide metrics on the internal listener,
+// they cover all workspaces so they aren't served to API users
+func metricsHandler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/metrics", handleRequest(getMetrics)).Methods("GET")
+	return context.ClearHandler(router)
+}
+
 func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer context.Clear(r)
 
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Revert modes decide what happens to Toggl objects created by a reverted run
+const (
+	revertModeArchive = "archive"
+	revertModeDelete  = "delete"
+)
+
+var errRunAlreadyReverted = errors.New("Run has already been reverted")
+
+type (
+	// SyncRun records Toggl objects a pipe run created or modified,
+	// so that the run can be reverted. Users invited by a run are not recorded.
+	SyncRun struct {
+		ID         int         `json:"id"`
+		StartedAt  time.Time   `json:"started_at"`
+		RevertedAt *time.Time  `json:"reverted_at,omitempty"`
+		Changes    []RunChange `json:"changes"`
+
+		workspaceID int
+		key         string
+		// previous keeps Toggl objects by type and ID as they were before the run,
+		// listed types hold all objects, other types only objects looked up
+		previous map[string]map[int]TogglObject
+		listed   map[string]bool
+		lookedUp map[string]map[int]bool
+	}
+
+	// RunChange is a Toggl object created, modified or linked by a run,
+	// previous values are set for objects which existed before the run
+	RunChange struct {
+		ObjectType     string `json:"object_type"`
+		ConnectionID   string `json:"connection_id"`
+		ForeignID      string `json:"foreign_id"`
+		TogglID        int    `json:"toggl_id"`
+		ProjectID      int    `json:"project_id,omitempty"`
+		Name           string `json:"name"`
+		Created        bool   `json:"created"`
+		Linked         bool   `json:"linked"`
+		Modified       bool   `json:"modified"`
+		PreviousName   string `json:"previous_name,omitempty"`
+		PreviousActive bool   `json:"previous_active,omitempty"`
+	}
+)
+
+func newSyncRun(p *Pipe) *SyncRun {
+	return &SyncRun{
+		StartedAt:   time.Now(),
+		workspaceID: p.workspaceID,
+		key:         p.key,
+		previous:    make(map[string]map[int]TogglObject),
+		listed:      make(map[string]bool),
+		lookedUp:    make(map[string]map[int]bool),
+	}
+}
+
+func loadSyncRun(workspaceID int, serviceID, pipeID string, id int) (*SyncRun, error) {
+	return store.LoadSyncRun(workspaceID, pipesKey(serviceID, pipeID), id)
+}
+
+func loadSyncRuns(workspaceID int, serviceID, pipeID string) ([]*SyncRun, error) {
+	return store.LoadSyncRuns(workspaceID, pipesKey(serviceID, pipeID))
+}
+
+func decodeSyncRun(workspaceID int, key string, id int, b []byte) (*SyncRun, error) {
+	var run SyncRun
+	if err := json.Unmarshal(b, &run); err != nil {
+		return nil, err
+	}
+	run.ID = id
+	run.workspaceID = workspaceID
+	run.key = key
+	return &run, nil
+}
+
+func (r *SyncRun) save() error {
+	return store.SaveSyncRun(r)
+}
+
+// maxSnapshotLookups is how many objects of a type a run looks up one by one,
+// listing the type is cheaper than looking up more of them
+const maxSnapshotLookups = 20
+
+// snapshot remembers how objects about to be posted are in Toggl before the run
+// changes them. Linked objects are looked up by ID, objects without Toggl ID
+// make the whole type listed once, as Toggl links them to existing objects
+// with the same name. Tags can't be looked up by ID and are always listed.
+// It does nothing when no run is recorded.
+func (r *SyncRun) snapshot(p *Pipe, objectType string, objects []TogglObject) error {
+	if r == nil || r.listed[objectType] {
+		return nil
+	}
+	if r.previous[objectType] == nil {
+		r.previous[objectType] = make(map[int]TogglObject)
+		r.lookedUp[objectType] = make(map[int]bool)
+	}
+	var lookups []TogglObject
+	list := objectType == tagsPipeID
+	for _, object := range objects {
+		if object.ID == 0 {
+			list = true
+		} else if !r.lookedUp[objectType][object.ID] {
+			lookups = append(lookups, object)
+		}
+	}
+	if list || len(lookups) > maxSnapshotLookups {
+		return r.list(p, objectType)
+	}
+	for _, object := range lookups {
+		previous, err := togglClient.GetObject(p.authorization.WorkspaceToken, p.workspaceID, objectType, object.ID, object.ProjectID)
+		if err != nil && !isTogglNotFound(err) {
+			return err
+		}
+		if previous != nil {
+			r.previous[objectType][object.ID] = *previous
+		}
+		r.lookedUp[objectType][object.ID] = true
+	}
+	return nil
+}
+
+func (r *SyncRun) list(p *Pipe, objectType string) error {
+	objects, err := togglClient.GetObjects(p.authorization.WorkspaceToken, p.workspaceID, objectType)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int]TogglObject, len(objects))
+	for _, object := range objects {
+		byID[object.ID] = object
+	}
+	r.previous[objectType] = byID
+	r.listed[objectType] = true
+	return nil
+}
+
+// record notes an object returned by Toggl, previousID is the Toggl ID
+// the foreign object was linked to before it was posted. Objects missing
+// from the snapshot are recorded as created, so the object must have been
+// looked up or its type listed.
+func (r *SyncRun) record(objectType, connectionID, foreignID string, previousID int, object TogglObject) error {
+	if r == nil || object.ID == 0 {
+		return nil
+	}
+	if !r.listed[objectType] && !r.lookedUp[objectType][object.ID] {
+		return fmt.Errorf("no snapshot of %s %d was taken before recording the run", objectType, object.ID)
+	}
+	change := RunChange{
+		ObjectType:   objectType,
+		ConnectionID: connectionID,
+		ForeignID:    foreignID,
+		TogglID:      object.ID,
+		ProjectID:    object.ProjectID,
+		Name:         object.Name,
+	}
+	previous, known := r.previous[objectType][object.ID]
+	change.Linked = previousID != object.ID
+	change.Created = change.Linked && !known
+	change.Modified = known && (previous.Name != object.Name || previous.Active != object.Active)
+	if change.Modified {
+		change.PreviousName = previous.Name
+		change.PreviousActive = previous.Active
+	}
+	if change.Linked || change.Modified {
+		r.Changes = append(r.Changes, change)
+	}
+	return nil
+}
+
+func clientObjects(clients []*Client) []TogglObject {
+	objects := make([]TogglObject, len(clients))
+	for i, client := range clients {
+		objects[i] = TogglObject{ID: client.ID}
+	}
+	return objects
+}
+
+func projectObjects(projects []*Project) []TogglObject {
+	objects := make([]TogglObject, len(projects))
+	for i, project := range projects {
+		objects[i] = TogglObject{ID: project.ID}
+	}
+	return objects
+}
+
+func taskObjects(tasks []*Task) []TogglObject {
+	objects := make([]TogglObject, len(tasks))
+	for i, task := range tasks {
+		objects[i] = TogglObject{ID: task.ID, ProjectID: task.ProjectID}
+	}
+	return objects
+}
+
+func tagObjects(tags []*Tag) []TogglObject {
+	objects := make([]TogglObject, len(tags))
+	for i, tag := range tags {
+		objects[i] = TogglObject{ID: tag.ID}
+	}
+	return objects
+}
+
+// startSyncRun starts recording changes of the pipe
+func (p *Pipe) startSyncRun() {
+	p.syncRun = newSyncRun(p)
+}
+
+// saveSyncRun saves the run if it changed anything and refers to it from the status
+func (p *Pipe) saveSyncRun() error {
+	if p.syncRun == nil || len(p.syncRun.Changes) == 0 {
+		return nil
+	}
+	if err := p.syncRun.save(); err != nil {
+		return err
+	}
+	p.PipeStatus.RunID = p.syncRun.ID
+	return nil
+}
+
+// revert undoes changes of the run, created objects are archived or deleted
+// depending on mode, modified objects get their previous name and state back
+// and links made by the run are removed. Tasks are reverted before
+// projects and projects before clients, tags are reverted last.
+func (r *SyncRun) revert(p *Pipe, mode string) ([]string, error) {
+	if r.RevertedAt != nil {
+		return nil, errRunAlreadyReverted
+	}
+	s, err := p.Service()
+	if err != nil {
+		return nil, err
+	}
+	var notifications []string
+	connections := make(map[string]*Connection)
+	for _, objectType := range []string{"tasks", "projects", "clients", "tags"} {
+		for _, change := range r.Changes {
+			if change.ObjectType != objectType {
+				continue
+			}
+			notification, err := revertChange(p, change, mode)
+			if err != nil && !isTogglNotFound(err) {
+				return nil, err
+			}
+			if notification != "" {
+				notifications = append(notifications, notification)
+			}
+			if !change.Linked {
+				continue
+			}
+			connection, loaded := connections[change.ConnectionID]
+			if !loaded {
+				if connection, err = loadConnection(s, change.ConnectionID); err != nil {
+					return nil, err
+				}
+				connections[change.ConnectionID] = connection
+			}
+			if connection.Data[change.ForeignID] == change.TogglID {
+				delete(connection.Data, change.ForeignID)
+			}
+		}
+	}
+	for _, connection := range connections {
+		if err := connection.save(); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	r.RevertedAt = &now
+	return notifications, r.save()
+}
+
+func revertChange(p *Pipe, change RunChange, mode string) (string, error) {
+	token := p.authorization.WorkspaceToken
+	switch {
+	case !change.Created && !change.Modified:
+		return "", nil
+	case !change.Created:
+		return fmt.Sprintf("%s '%s' was restored as '%s'", objectTitle(change.ObjectType), change.Name, change.PreviousName),
+			postRevertedObject(token, change, change.PreviousName, change.PreviousActive)
+	case mode == revertModeDelete && change.ObjectType == "tasks":
+		return fmt.Sprintf("Task '%s' was deleted", change.Name),
+			togglClient.DeleteTask(token, p.workspaceID, change.ProjectID, change.TogglID)
+	case mode == revertModeDelete && change.ObjectType == "projects":
+		return fmt.Sprintf("Project '%s' was deleted", change.Name),
+			togglClient.DeleteProject(token, p.workspaceID, change.TogglID)
+	case mode == revertModeDelete && change.ObjectType == "clients":
+		return fmt.Sprintf("Client '%s' was deleted", change.Name),
+			togglClient.DeleteClient(token, p.workspaceID, change.TogglID)
+	case mode == revertModeDelete && change.ObjectType == "tags":
+		return fmt.Sprintf("Tag '%s' was deleted", change.Name),
+			togglClient.DeleteTag(token, p.workspaceID, change.TogglID)
+	case change.ObjectType == "clients":
+		// clients can't be archived through the pipes API
+		return fmt.Sprintf("Client '%s' was kept", change.Name), nil
+	case change.ObjectType == "tags":
+		// tags have no state to archive
+		return fmt.Sprintf("Tag '%s' was kept", change.Name), nil
+	default:
+		return fmt.Sprintf("%s '%s' was archived", objectTitle(change.ObjectType), change.Name),
+			postRevertedObject(token, change, change.Name, false)
+	}
+}
+
+func objectTitle(objectType string) string {
+	switch objectType {
+	case "clients":
+		return "Client"
+	case "projects":
+		return "Project"
+	case "tags":
+		return "Tag"
+	default:
+		return "Task"
+	}
+}
+
+// postRevertedObject updates name and state of the Toggl object through the pipes API
+func postRevertedObject(token string, change RunChange, name string, active bool) error {
+	var err error
+	switch change.ObjectType {
+	case "clients":
+		client := &Client{ID: change.TogglID, Name: name, ForeignID: change.ForeignID}
+		_, err = togglClient.PostPipesAPI(token, clientsPipeID, clientRequest{Clients: []*Client{client}})
+	case "tags":
+		tag := &Tag{ID: change.TogglID, Name: name, ForeignID: change.ForeignID}
+		_, err = togglClient.PostPipesAPI(token, tagsPipeID, tagRequest{Tags: []*Tag{tag}})
+	case "projects":
+		project := &Project{ID: change.TogglID, Name: name, Active: active, ForeignID: change.ForeignID}
+		_, err = togglClient.PostPipesAPI(token, projectsPipeID, projectRequest{Projects: []*Project{project}})
+	default:
+		task := &Task{ID: change.TogglID, Name: name, Active: active, ProjectID: change.ProjectID, ForeignID: change.ForeignID}
+		_, err = togglClient.PostPipesAPI(token, tasksPipeId, taskRequest{Tasks: []*Task{task}})
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package main
+
+import "testing"
+
+func TestRevertRun(t *testing.T) {
+	p := NewPipe(46, TestServiceName, projectsPipeID)
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := NewConnection(s, projectsPipeID)
+	connection.Data["b"] = 12
+	if err := connection.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveObject(p, projectsPipeID, ProjectsResponse{Projects: []*Project{
+		{ForeignID: "a", Name: "Created", Active: true},
+		{ID: 12, ForeignID: "b", Name: "Renamed", Active: true},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeTogglAPI{
+		objects: map[string][]TogglObject{
+			projectsPipeID: {{ID: 12, Name: "Original", Active: true}},
+		},
+		responses: map[string][]byte{
+			projectsPipeID: []byte(`{"projects":[{"id":11,"foreign_id":"a","name":"Created","active":true},{"id":12,"foreign_id":"b","name":"Renamed","active":true}]}`),
+		},
+	}
+	defer withFakeTogglAPI(fake)()
+	p.startSyncRun()
+	if err := postProjects(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.saveSyncRun(); err != nil {
+		t.Fatal(err)
+	}
+	if p.PipeStatus.RunID == 0 || len(p.syncRun.Changes) != 2 {
+		t.Fatalf("expected run with 2 changes, got %+v", p.syncRun)
+	}
+
+	run, err := loadSyncRun(p.workspaceID, p.serviceID, p.ID, p.PipeStatus.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications, err := run.revert(p, revertModeDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 2 {
+		t.Errorf("expected 2 notifications, got %v", notifications)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "project:11" {
+		t.Errorf("expected created project to be deleted, got %v", fake.deleted)
+	}
+	if string(fake.payloads[projectsPipeID]) != `{"projects":[{"id":12,"name":"Original","active":true,"foreign_id":"b"}],"supports_client":false}` {
+		t.Errorf("expected renamed project to be restored, got %s", fake.payloads[projectsPipeID])
+	}
+	connection, err = loadConnection(s, projectsPipeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, linked := connection.Data["a"]; linked || connection.Data["b"] != 12 {
+		t.Errorf("expected only the created project to be unlinked, got %v", connection.Data)
+	}
+	if _, err := run.revert(p, revertModeDelete); err != errRunAlreadyReverted {
+		t.Errorf("expected second revert to fail, got %v", err)
+	}
+}
+
+func TestRecordRequiresSnapshot(t *testing.T) {
+	run := newSyncRun(NewPipe(46, TestServiceName, projectsPipeID))
+	if err := run.record(projectsPipeID, projectsPipeID, "a", 0, TogglObject{ID: 11, Name: "a"}); err == nil {
+		t.Error("expected recording without a snapshot to fail")
+	}
+	if len(run.Changes) != 0 {
+		t.Errorf("expected no changes to be recorded, got %+v", run.Changes)
+	}
+
+	run.previous[projectsPipeID] = map[int]TogglObject{12: {ID: 12, Name: "b", Active: true}}
+	run.lookedUp[projectsPipeID] = map[int]bool{12: true}
+	if err := run.record(projectsPipeID, projectsPipeID, "b", 0, TogglObject{ID: 12, Name: "b", Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Changes) != 1 || run.Changes[0].Created || !run.Changes[0].Linked {
+		t.Errorf("expected existing project to be linked and not created, got %+v", run.Changes)
+	}
+}
+
+func TestSnapshotLooksUpLinkedObjects(t *testing.T) {
+	p := NewPipe(61, TestServiceName, projectsPipeID)
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := NewConnection(s, projectsPipeID)
+	connection.Data["a"] = 11
+	connection.Data["b"] = 12
+	if err := connection.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveObject(p, projectsPipeID, ProjectsResponse{Projects: []*Project{
+		{ID: 11, ForeignID: "a", Name: "Kept", Active: true},
+		{ID: 12, ForeignID: "b", Name: "Renamed", Active: true},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeTogglAPI{
+		objects: map[string][]TogglObject{
+			projectsPipeID: {{ID: 11, Name: "Kept", Active: true}, {ID: 12, Name: "Original", Active: true}, {ID: 13, Name: "Other"}},
+		},
+		responses: map[string][]byte{
+			projectsPipeID: []byte(`{"projects":[{"id":11,"foreign_id":"a","name":"Kept","active":true},{"id":12,"foreign_id":"b","name":"Renamed","active":true}]}`),
+		},
+	}
+	defer withFakeTogglAPI(fake)()
+	p.startSyncRun()
+	if err := postProjects(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.fetched) != 2 || fake.fetched[0] != "projects:11" || fake.fetched[1] != "projects:12" {
+		t.Errorf("expected only posted projects to be looked up, got %v", fake.fetched)
+	}
+	changes := p.syncRun.Changes
+	if len(changes) != 1 || changes[0].TogglID != 12 || !changes[0].Modified || changes[0].PreviousName != "Original" {
+		t.Errorf("expected renamed project to be recorded, got %+v", changes)
+	}
+}
+
+func TestRevertTagsRun(t *testing.T) {
+	p := NewPipe(52, TestServiceName, tagsPipeID)
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := NewConnection(s, tagsPipeID)
+	connection.Data["b"] = 22
+	if err := connection.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveObject(p, tagsPipeID, TagsResponse{Tags: []*Tag{
+		{ForeignID: "a", Name: "created"},
+		{ID: 22, ForeignID: "b", Name: "renamed"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeTogglAPI{
+		objects: map[string][]TogglObject{
+			tagsPipeID: {{ID: 22, Name: "original"}},
+		},
+		responses: map[string][]byte{
+			tagsPipeID: []byte(`{"tags":[{"id":21,"foreign_id":"a","name":"created"},{"id":22,"foreign_id":"b","name":"renamed"}]}`),
+		},
+	}
+	defer withFakeTogglAPI(fake)()
+	p.startSyncRun()
+	if err := postTags(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.saveSyncRun(); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := loadSyncRun(p.workspaceID, p.serviceID, p.ID, p.PipeStatus.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications, err := run.revert(p, revertModeDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 2 {
+		t.Errorf("expected 2 notifications, got %v", notifications)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "tag:21" {
+		t.Errorf("expected created tag to be deleted, got %v", fake.deleted)
+	}
+	if string(fake.payloads[tagsPipeID]) != `{"tags":[{"id":22,"name":"original","foreign_id":"b"}]}` {
+		t.Errorf("expected renamed tag to be restored, got %s", fake.payloads[tagsPipeID])
+	}
+	connection, err = loadConnection(s, tagsPipeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, linked := connection.Data["a"]; linked || connection.Data["b"] != 22 {
+		t.Errorf("expected only the created tag to be unlinked, got %v", connection.Data)
+	}
+}
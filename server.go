@@ -46,8 +46,26 @@ func main() {
 		// more configuration options
 	})
 
-	db = connectDB(dbConnString)
-	defer db.Close()
+	var err error
+	if store, err = openStorage(storageType, dbConnString); err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	if command != "" && command != "migrate" {
+		log.Fatalf("unknown command %q", command)
+	}
+	if command == "migrate" || migrateOnStart {
+		if err := store.Migrate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if command == "migrate" {
+		return
+	}
+
+	togglClient = NewTogglClient(togglAPITimeout, togglAPIRetries, togglAPIMaxResponseSize)
+	workspaceIDCache = newWorkspaceCache(workspaceCacheTTL, workspaceCacheNegativeTTL, workspaceCacheDB)
 
 	loadIntegrations()
 
@@ -86,6 +104,15 @@ func main() {
 		go autoSyncRunnerStub()
 	}
 	go autoSyncQueuer()
+	if importsCompactInterval > 0 {
+		go importsCompactor()
+	}
+
+	if metricsAddress != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(metricsAddress, metricsHandler()))
+		}()
+	}
 
 	listenAddress := fmt.Sprintf(":%d", port)
 	log.Printf(
@@ -108,7 +135,7 @@ func loadIntegrations() {
 		ids = append(ids, availableIntegrations[i].ID)
 	}
 	serviceType = regexp.MustCompile(strings.Join(ids, "|"))
-	pipeType = regexp.MustCompile("users|projects|todolists|todos|tasks|timeentries")
+	pipeType = regexp.MustCompile("users|projects|todolists|todos|tasks|timeentries|tags")
 }
 
 func isWhiteListedCorsOrigin(r *http.Request) (string, bool) {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"time"
 )
@@ -56,6 +58,20 @@ type (
 		// https://github.com/toggl/pipes-api/blob/master/model.go#L38-45
 		TodoLists() ([]*Task, error)
 
+		// Tags maps foreign labels to Tag models
+		Tags() ([]*Tag, error)
+
+		// Groups maps foreign teams to Group models,
+		// foreign IDs of the members must be set
+		Groups() ([]*Group, error)
+
+		// ProjectMembers maps foreign project IDs to foreign IDs of their members
+		ProjectMembers() (map[string][]string, error)
+
+		// TimeEntries maps foreign time entries to TimeEntry models,
+		// foreign IDs of the entry and its user, project and task must be set
+		TimeEntries() ([]*TimeEntry, error)
+
 		// Exports time entry model to foreign service
 		// should return foreign id of saved time entry
 		// https://github.com/toggl/pipes-api/blob/master/model.go#L47-L61
@@ -77,6 +93,14 @@ func getService(serviceID string, workspaceID int) Service {
 		return Service(&AsanaService{workspaceID: workspaceID})
 	case "github":
 		return Service(&GithubService{workspaceID: workspaceID})
+	case "gitlab":
+		return Service(&GitlabService{workspaceID: workspaceID})
+	case "harvest":
+		return Service(&HarvestService{workspaceID: workspaceID})
+	case "linear":
+		return Service(&LinearService{workspaceID: workspaceID})
+	case "trello":
+		return Service(&TrelloService{workspaceID: workspaceID})
 	case TestServiceName:
 		return Service(&TestService{workspaceID: workspaceID})
 	default:
@@ -84,12 +108,36 @@ func getService(serviceID string, workspaceID int) Service {
 	}
 }
 
+const (
+	// maxKeyLength is the size of key columns in the database
+	maxKeyLength = 50
+	// maxAccountKeyLength leaves room for the object type
+	// and suffixes like _archived after the account
+	maxAccountKeyLength = 12
+)
+
+// accountKey returns the account ID to use in keys,
+// longer IDs are replaced by a prefix of their hash
+func accountKey(accountID string) string {
+	if len(accountID) <= maxAccountKeyLength {
+		return accountID
+	}
+	sum := sha256.Sum256([]byte(accountID))
+	return hex.EncodeToString(sum[:])[:maxAccountKeyLength]
+}
+
 func (s *emptyService) setSince(*time.Time)                     {}
 func (s *emptyService) setParams([]byte) error                  { return nil }
 func (s *emptyService) Users() ([]*User, error)                 { return nil, nil }
 func (s *emptyService) Tasks() ([]*Task, error)                 { return nil, nil }
 func (s *emptyService) Clients() ([]*Client, error)             { return nil, fmt.Errorf("%w clients", ErrNotSupported) }
 func (s *emptyService) TodoLists() ([]*Task, error)             { return nil, nil }
+func (s *emptyService) TimeEntries() ([]*TimeEntry, error)      { return nil, fmt.Errorf("%w time entries", ErrNotSupported) }
+func (s *emptyService) Tags() ([]*Tag, error)                   { return nil, fmt.Errorf("%w tags", ErrNotSupported) }
+func (s *emptyService) Groups() ([]*Group, error)               { return nil, fmt.Errorf("%w groups", ErrNotSupported) }
+func (s *emptyService) ProjectMembers() (map[string][]string, error) {
+	return nil, fmt.Errorf("%w project members", ErrNotSupported)
+}
 func (s *emptyService) Projects() ([]*Project, error)           { return nil, nil }
 func (s *emptyService) Accounts() ([]*Account, error)           { return nil, nil }
 func (s *emptyService) ExportTimeEntry(*TimeEntry) (int, error) { return 0, nil }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Storage persists pipes, their statuses, connections, imports,
+// authorizations and the queue of pipes waiting for sync.
+// Methods loading a single object return nil when it doesn't exist.
+type Storage interface {
+	IsDown() bool
+	Close() error
+	// Migrate brings the schema up to date
+	Migrate() error
+
+	LoadPipe(workspaceID int, key string) (*Pipe, error)
+	LoadPipes(workspaceID int) (map[string]*Pipe, error)
+	SavePipe(p *Pipe) error
+	// DeletePipe removes the pipe together with its status
+	DeletePipe(workspaceID int, key string) error
+	DeleteServicePipes(workspaceID int, serviceID string) error
+
+	LoadPipeStatus(workspaceID int, key string) (*PipeStatus, error)
+	LoadPipeStatuses(workspaceID int) (map[string]*PipeStatus, error)
+	SavePipeStatus(p *PipeStatus) error
+	LoadLastSync(workspaceID int, key string) (*time.Time, error)
+
+	// LoadConnection returns foreign ID to Toggl ID mappings
+	LoadConnection(workspaceID int, key string) (map[string]int, error)
+	// LoadReversedConnection returns Toggl ID to foreign ID mappings
+	LoadReversedConnection(workspaceID int, key string) (map[int]string, error)
+	// SaveConnection upserts and deletes individual mappings,
+	// mappings not mentioned are left untouched
+	SaveConnection(workspaceID int, key string, upserts map[string]int, deletes []string) error
+	// ClearConnection removes the connection and status of the pipe
+	ClearConnection(workspaceID int, connectionKey, pipeKey string) error
+
+	LoadImport(workspaceID int, key string) ([]byte, error)
+	// SaveImport returns false when the latest import had the same hash
+	// and only its timestamp was refreshed
+	SaveImport(workspaceID int, key string, data []byte, hash string) (bool, error)
+	DeleteImports(workspaceID int, key string) error
+	// CompactImports deletes imports beyond the newest keepLast per key
+	// and older than maxAge, zero disables either rule.
+	// The newest import of a key is never deleted.
+	CompactImports(keepLast int, maxAge time.Duration) (int64, error)
+	LoadImportStats(workspaceID int) ([]ImportStats, error)
+
+	LoadSyncRun(workspaceID int, key string, id int) (*SyncRun, error)
+	// LoadSyncRuns returns runs of the pipe, newest first
+	LoadSyncRuns(workspaceID int, key string) ([]*SyncRun, error)
+	// SaveSyncRun inserts a new run setting its ID or updates an existing one
+	SaveSyncRun(r *SyncRun) error
+
+	LoadAuthorization(workspaceID int, serviceID string) (*Authorization, error)
+	LoadAuthorizedServices(workspaceID int) (map[string]bool, error)
+	SaveAuthorization(a *Authorization) error
+	DeleteAuthorization(workspaceID int, serviceID string) error
+
+	QueueAutomaticPipes() error
+	QueuePipeAsFirst(workspaceID int, key string) error
+	// LoadQueuedPipes locks and returns at most one pipe per workspace
+	LoadQueuedPipes() ([]*Pipe, error)
+	SetQueuedPipeSynced(p *Pipe) error
+
+	LoadWorkspaceToken(hash string) (int, bool, error)
+	SaveWorkspaceToken(hash string, workspaceID int, expiresAt time.Time) error
+	DeleteWorkspaceToken(hash string) error
+}
+
+var store Storage
+
+func openStorage(storageType, connString string) (Storage, error) {
+	switch storageType {
+	case "postgres":
+		return NewPostgresStorage(connString)
+	case "memory":
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", storageType)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryStorage keeps everything in process memory,
+	// it's meant for tests and local development without Postgres.
+	// Objects are stored JSON encoded just like in Postgres,
+	// so callers never share memory with the storage.
+	MemoryStorage struct {
+		sync.Mutex
+		pipes           map[memoryKey][]byte
+		statuses        map[memoryKey][]byte
+		connections     map[memoryKey]map[string]int
+		imports         map[memoryKey][]memoryImport
+		syncRuns        map[int]memorySyncRun
+		authorizations  map[memoryKey]Authorization
+		queue           []*memoryQueuedPipe
+		workspaceTokens map[string]memoryWorkspaceToken
+	}
+
+	memoryKey struct {
+		workspaceID int
+		key         string
+	}
+
+	memoryQueuedPipe struct {
+		memoryKey
+		priority  int
+		createdAt time.Time
+		lockedAt  *time.Time
+		syncedAt  *time.Time
+	}
+
+	memoryImport struct {
+		data      []byte
+		hash      string
+		createdAt time.Time
+	}
+
+	memorySyncRun struct {
+		memoryKey
+		data []byte
+	}
+
+	memoryWorkspaceToken struct {
+		workspaceID int
+		expiresAt   time.Time
+	}
+)
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		pipes:           make(map[memoryKey][]byte),
+		statuses:        make(map[memoryKey][]byte),
+		connections:     make(map[memoryKey]map[string]int),
+		imports:         make(map[memoryKey][]memoryImport),
+		syncRuns:        make(map[int]memorySyncRun),
+		authorizations:  make(map[memoryKey]Authorization),
+		workspaceTokens: make(map[string]memoryWorkspaceToken),
+	}
+}
+
+func (s *MemoryStorage) IsDown() bool { return false }
+func (s *MemoryStorage) Close() error { return nil }
+
+func (s *MemoryStorage) LoadPipe(workspaceID int, key string) (*Pipe, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, found := s.pipes[memoryKey{workspaceID, key}]
+	if !found {
+		return nil, nil
+	}
+	return decodePipe(workspaceID, key, b)
+}
+
+func (s *MemoryStorage) LoadPipes(workspaceID int) (map[string]*Pipe, error) {
+	s.Lock()
+	defer s.Unlock()
+	pipes := make(map[string]*Pipe)
+	for k, b := range s.pipes {
+		if k.workspaceID != workspaceID {
+			continue
+		}
+		pipe, err := decodePipe(k.workspaceID, k.key, b)
+		if err != nil {
+			return nil, err
+		}
+		pipes[k.key] = pipe
+	}
+	return pipes, nil
+}
+
+func (s *MemoryStorage) SavePipe(p *Pipe) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.pipes[memoryKey{p.workspaceID, p.key}] = b
+	s.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) DeletePipe(workspaceID int, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.deletePipe(memoryKey{workspaceID, key})
+	delete(s.statuses, memoryKey{workspaceID, key})
+	return nil
+}
+
+func (s *MemoryStorage) DeleteServicePipes(workspaceID int, serviceID string) error {
+	s.Lock()
+	defer s.Unlock()
+	for k := range s.pipes {
+		if k.workspaceID == workspaceID && strings.HasPrefix(k.key, serviceID+":") {
+			s.deletePipe(k)
+		}
+	}
+	return nil
+}
+
+// deletePipe removes queued pipes too, like the foreign key in Postgres does
+func (s *MemoryStorage) deletePipe(k memoryKey) {
+	delete(s.pipes, k)
+	queue := s.queue[:0]
+	for _, queued := range s.queue {
+		if queued.memoryKey != k {
+			queue = append(queue, queued)
+		}
+	}
+	s.queue = queue
+}
+
+func (s *MemoryStorage) LoadPipeStatus(workspaceID int, key string) (*PipeStatus, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, found := s.statuses[memoryKey{workspaceID, key}]
+	if !found {
+		return nil, nil
+	}
+	return decodePipeStatus(workspaceID, key, b)
+}
+
+func (s *MemoryStorage) LoadPipeStatuses(workspaceID int) (map[string]*PipeStatus, error) {
+	s.Lock()
+	defer s.Unlock()
+	pipeStatuses := make(map[string]*PipeStatus)
+	for k, b := range s.statuses {
+		if k.workspaceID != workspaceID {
+			continue
+		}
+		pipeStatus, err := decodePipeStatus(k.workspaceID, k.key, b)
+		if err != nil {
+			return nil, err
+		}
+		pipeStatuses[k.key] = pipeStatus
+	}
+	return pipeStatuses, nil
+}
+
+func (s *MemoryStorage) SavePipeStatus(p *PipeStatus) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.statuses[memoryKey{p.workspaceID, p.key}] = b
+	s.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) LoadLastSync(workspaceID int, key string) (*time.Time, error) {
+	pipeStatus, err := s.LoadPipeStatus(workspaceID, key)
+	if err != nil || pipeStatus == nil || pipeStatus.SyncDate == "" {
+		return nil, err
+	}
+	lastSync, err := time.Parse(time.RFC3339, pipeStatus.SyncDate)
+	if err != nil {
+		return nil, err
+	}
+	return &lastSync, nil
+}
+
+func (s *MemoryStorage) LoadConnection(workspaceID int, key string) (map[string]int, error) {
+	s.Lock()
+	defer s.Unlock()
+	data := make(map[string]int)
+	for foreignID, togglID := range s.connections[memoryKey{workspaceID, key}] {
+		data[foreignID] = togglID
+	}
+	return data, nil
+}
+
+func (s *MemoryStorage) LoadReversedConnection(workspaceID int, key string) (map[int]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	data := make(map[int]string)
+	for foreignID, togglID := range s.connections[memoryKey{workspaceID, key}] {
+		data[togglID] = foreignID
+	}
+	return data, nil
+}
+
+func (s *MemoryStorage) SaveConnection(workspaceID int, key string, upserts map[string]int, deletes []string) error {
+	s.Lock()
+	defer s.Unlock()
+	k := memoryKey{workspaceID, key}
+	if s.connections[k] == nil {
+		s.connections[k] = make(map[string]int)
+	}
+	for foreignID, togglID := range upserts {
+		s.connections[k][foreignID] = togglID
+	}
+	for _, foreignID := range deletes {
+		delete(s.connections[k], foreignID)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ClearConnection(workspaceID int, connectionKey, pipeKey string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.connections, memoryKey{workspaceID, connectionKey})
+	delete(s.statuses, memoryKey{workspaceID, pipeKey})
+	return nil
+}
+
+func (s *MemoryStorage) LoadImport(workspaceID int, key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	imports := s.imports[memoryKey{workspaceID, key}]
+	if len(imports) == 0 {
+		return nil, nil
+	}
+	return imports[len(imports)-1].data, nil
+}
+
+func (s *MemoryStorage) SaveImport(workspaceID int, key string, data []byte, hash string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	k := memoryKey{workspaceID, key}
+	imports := s.imports[k]
+	if len(imports) > 0 && imports[len(imports)-1].hash == hash {
+		imports[len(imports)-1].createdAt = time.Now()
+		return false, nil
+	}
+	s.imports[k] = append(imports, memoryImport{data, hash, time.Now()})
+	return true, nil
+}
+
+func (s *MemoryStorage) CompactImports(keepLast int, maxAge time.Duration) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	var deleted int64
+	now := time.Now()
+	for k, imports := range s.imports {
+		kept := make([]memoryImport, 0, len(imports))
+		for i, imp := range imports {
+			position := len(imports) - i
+			expired := (keepLast > 0 && position > keepLast) || (maxAge > 0 && now.Sub(imp.createdAt) > maxAge)
+			if position > 1 && expired {
+				deleted++
+				continue
+			}
+			kept = append(kept, imp)
+		}
+		s.imports[k] = kept
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStorage) LoadImportStats(workspaceID int) ([]ImportStats, error) {
+	s.Lock()
+	defer s.Unlock()
+	var stats []ImportStats
+	for k, imports := range s.imports {
+		if k.workspaceID != workspaceID || len(imports) == 0 {
+			continue
+		}
+		stat := ImportStats{Key: k.key, Count: len(imports), LatestAt: imports[len(imports)-1].createdAt}
+		for _, imp := range imports {
+			stat.Bytes += int64(len(imp.data))
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats, nil
+}
+
+func (s *MemoryStorage) DeleteImports(workspaceID int, key string) error {
+	s.Lock()
+	delete(s.imports, memoryKey{workspaceID, key})
+	s.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) LoadSyncRun(workspaceID int, key string, id int) (*SyncRun, error) {
+	s.Lock()
+	defer s.Unlock()
+	run, found := s.syncRuns[id]
+	if !found || run.memoryKey != (memoryKey{workspaceID, key}) {
+		return nil, nil
+	}
+	return decodeSyncRun(workspaceID, key, id, run.data)
+}
+
+func (s *MemoryStorage) LoadSyncRuns(workspaceID int, key string) ([]*SyncRun, error) {
+	s.Lock()
+	defer s.Unlock()
+	var runs []*SyncRun
+	for id, run := range s.syncRuns {
+		if run.memoryKey != (memoryKey{workspaceID, key}) {
+			continue
+		}
+		decoded, err := decodeSyncRun(workspaceID, key, id, run.data)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, decoded)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs, nil
+}
+
+func (s *MemoryStorage) SaveSyncRun(r *SyncRun) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if r.ID == 0 {
+		r.ID = len(s.syncRuns) + 1
+	}
+	s.syncRuns[r.ID] = memorySyncRun{memoryKey{r.workspaceID, r.key}, b}
+	return nil
+}
+
+func (s *MemoryStorage) LoadAuthorization(workspaceID int, serviceID string) (*Authorization, error) {
+	s.Lock()
+	defer s.Unlock()
+	a, found := s.authorizations[memoryKey{workspaceID, serviceID}]
+	if !found {
+		return nil, nil
+	}
+	return &a, nil
+}
+
+func (s *MemoryStorage) LoadAuthorizedServices(workspaceID int) (map[string]bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	authorizations := make(map[string]bool)
+	for k := range s.authorizations {
+		if k.workspaceID == workspaceID {
+			authorizations[k.key] = true
+		}
+	}
+	return authorizations, nil
+}
+
+func (s *MemoryStorage) SaveAuthorization(a *Authorization) error {
+	s.Lock()
+	s.authorizations[memoryKey{a.WorkspaceID, a.ServiceID}] = *a
+	s.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) DeleteAuthorization(workspaceID int, serviceID string) error {
+	s.Lock()
+	delete(s.authorizations, memoryKey{workspaceID, serviceID})
+	s.Unlock()
+	return nil
+}
+
+// hasPending must be called with the lock held
+func (s *MemoryStorage) hasPending(k memoryKey) bool {
+	for _, queued := range s.queue {
+		if queued.memoryKey == k && queued.syncedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStorage) QueueAutomaticPipes() error {
+	s.Lock()
+	defer s.Unlock()
+	for k, b := range s.pipes {
+		pipe, err := decodePipe(k.workspaceID, k.key, b)
+		if err != nil {
+			return err
+		}
+		if pipe.Automatic && !s.hasPending(k) {
+			s.queue = append(s.queue, &memoryQueuedPipe{memoryKey: k, createdAt: time.Now()})
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) QueuePipeAsFirst(workspaceID int, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	k := memoryKey{workspaceID, key}
+	priority := 0
+	for _, queued := range s.queue {
+		if queued.lockedAt == nil && queued.syncedAt == nil && queued.priority > priority {
+			priority = queued.priority
+		}
+	}
+	priority++
+
+	for _, queued := range s.queue {
+		if queued.memoryKey == k && queued.lockedAt == nil && queued.syncedAt == nil {
+			queued.priority = priority
+			return nil
+		}
+	}
+	if !s.hasPending(k) {
+		s.queue = append(s.queue, &memoryQueuedPipe{memoryKey: k, priority: priority, createdAt: time.Now()})
+	}
+	return nil
+}
+
+func (s *MemoryStorage) LoadQueuedPipes() ([]*Pipe, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	perWorkspace := make(map[int]*memoryQueuedPipe)
+	for _, queued := range s.queue {
+		if queued.lockedAt != nil || queued.syncedAt != nil {
+			continue
+		}
+		current, exists := perWorkspace[queued.workspaceID]
+		if !exists || queued.priority > current.priority {
+			perWorkspace[queued.workspaceID] = queued
+		}
+	}
+	pending := make([]*memoryQueuedPipe, 0, len(perWorkspace))
+	for _, queued := range perWorkspace {
+		pending = append(pending, queued)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].priority != pending[j].priority {
+			return pending[i].priority > pending[j].priority
+		}
+		return pending[i].createdAt.Before(pending[j].createdAt)
+	})
+	if len(pending) > 10 {
+		pending = pending[:10]
+	}
+
+	var pipes []*Pipe
+	now := time.Now()
+	for _, queued := range pending {
+		queued.lockedAt = &now
+		b, found := s.pipes[queued.memoryKey]
+		if !found {
+			continue
+		}
+		pipe, err := decodePipe(queued.workspaceID, queued.key, b)
+		if err != nil {
+			return nil, err
+		}
+		pipes = append(pipes, pipe)
+	}
+	return pipes, nil
+}
+
+func (s *MemoryStorage) SetQueuedPipeSynced(p *Pipe) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, queued := range s.queue {
+		if queued.memoryKey == (memoryKey{p.workspaceID, p.key}) && queued.lockedAt != nil && queued.syncedAt == nil {
+			queued.syncedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) LoadWorkspaceToken(hash string) (int, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	token, found := s.workspaceTokens[hash]
+	if !found || time.Now().After(token.expiresAt) {
+		return 0, false, nil
+	}
+	return token.workspaceID, true, nil
+}
+
+func (s *MemoryStorage) SaveWorkspaceToken(hash string, workspaceID int, expiresAt time.Time) error {
+	s.Lock()
+	s.workspaceTokens[hash] = memoryWorkspaceToken{workspaceID, expiresAt}
+	s.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) DeleteWorkspaceToken(hash string) error {
+	s.Lock()
+	delete(s.workspaceTokens, hash)
+	s.Unlock()
+	return nil
+}
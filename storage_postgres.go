@@ -0,0 +1,642 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	selectPipesSQL = `SELECT workspace_id, key, data
+    FROM pipes WHERE workspace_id = $1
+  `
+	singlePipesSQL = `SELECT workspace_id, key, data
+    FROM pipes WHERE workspace_id = $1
+    AND key = $2 LIMIT 1
+  `
+	deletePipeSQL = `DELETE FROM pipes
+    WHERE workspace_id = $1
+    AND key LIKE $2
+  `
+	insertPipesSQL = `
+    WITH existing_pipe AS (
+      UPDATE pipes SET data = $3
+      WHERE workspace_id = $1 AND key = $2
+      RETURNING key
+    ),
+    inserted_pipe AS (
+      INSERT INTO pipes(workspace_id, key, data)
+      SELECT $1, $2, $3
+      WHERE NOT EXISTS (SELECT 1 FROM existing_pipe)
+      RETURNING key
+    )
+    SELECT * FROM inserted_pipe
+    UNION
+    SELECT * FROM existing_pipe
+  `
+	deletePipeConnectionsSQL = `DELETE FROM connection_items
+    WHERE workspace_id = $1
+    AND key = $2
+  `
+	selectPipesFromQueueSQL = `SELECT workspace_id, key
+	FROM get_queued_pipes()`
+
+	queueAutomaticPipesSQL = `SELECT queue_automatic_pipes()`
+
+	queuePipeAsFirstSQL = `SELECT queue_pipe_as_first($1, $2)`
+
+	setQueuedPipeSyncedSQL = `UPDATE queued_pipes
+	SET synced_at = now()
+	WHERE workspace_id = $1
+	AND key = $2
+	AND locked_at IS NOT NULL
+	AND synced_at IS NULL`
+
+	selectPipeStatusSQL = `SELECT key, data
+    FROM pipes_status
+    WHERE workspace_id = $1
+  `
+	singlePipeStatusSQL = `SELECT data
+    FROM pipes_status
+    WHERE workspace_id = $1
+    AND key = $2 LIMIT 1
+  `
+	deletePipeStatusSQL = `DELETE FROM pipes_status
+		WHERE workspace_id = $1
+		AND key LIKE $2
+  `
+	lastSyncSQL = `SELECT (data->>'sync_date')::timestamp with time zone
+    FROM pipes_status
+    WHERE workspace_id = $1
+    AND key = $2
+  `
+	insertPipeStatusSQL = `INSERT INTO pipes_status(workspace_id, key, data)
+    VALUES($1, $2, $3)
+    ON CONFLICT (workspace_id, key) DO UPDATE SET data = EXCLUDED.data
+  `
+
+	selectConnectionSQL = `SELECT foreign_id, toggl_id
+    FROM connection_items
+    WHERE workspace_id = $1
+    AND key = $2
+  `
+	upsertConnectionItemsSQL = `INSERT INTO connection_items(workspace_id, key, foreign_id, toggl_id)
+    SELECT $1, $2, unnest($3::text[]), unnest($4::integer[])
+    ON CONFLICT (workspace_id, key, foreign_id)
+    DO UPDATE SET toggl_id = EXCLUDED.toggl_id, updated_at = NOW()
+  `
+	deleteConnectionItemsSQL = `DELETE FROM connection_items
+    WHERE workspace_id = $1
+    AND key = $2
+    AND foreign_id = ANY($3::text[])
+  `
+
+	selectImportSQL = `SELECT data FROM imports
+		WHERE workspace_id = $1 AND key = $2
+		ORDER by created_at DESC
+		LIMIT 1
+	`
+	insertImportSQL = `
+    WITH latest_import AS (
+      SELECT ctid, content_hash FROM imports
+      WHERE workspace_id = $1 AND key = $2
+      ORDER BY created_at DESC
+      LIMIT 1
+    ),
+    refreshed_import AS (
+      UPDATE imports SET created_at = NOW()
+      FROM latest_import
+      WHERE imports.ctid = latest_import.ctid
+      AND latest_import.content_hash = $4
+      RETURNING 1
+    )
+    INSERT INTO imports(workspace_id, key, data, created_at, content_hash, size)
+    SELECT $1, $2, $3, NOW(), $4, $5
+    WHERE NOT EXISTS (SELECT 1 FROM refreshed_import)
+	`
+	compactImportsSQL = `DELETE FROM imports WHERE ctid IN (
+      SELECT ctid FROM (
+        SELECT ctid, created_at,
+        row_number() OVER (PARTITION BY workspace_id, key ORDER BY created_at DESC) AS position
+        FROM imports
+      ) AS ranked
+      WHERE position > 1
+      AND (
+        ($1 > 0 AND position > $1)
+        OR ($2 > 0 AND created_at < NOW() - make_interval(secs => $2))
+      )
+    )
+	`
+	selectImportStatsSQL = `SELECT key, count(*),
+    coalesce(sum(coalesce(size, octet_length(data::text))), 0), max(created_at)
+    FROM imports
+    WHERE workspace_id = $1
+    GROUP BY key
+    ORDER BY key
+	`
+	deleteImportsSQL = `DELETE FROM imports
+	    WHERE workspace_id = $1 AND key = $2
+	`
+
+	selectSyncRunSQL = `SELECT id, data FROM sync_runs
+	    WHERE workspace_id = $1 AND key = $2 AND id = $3
+	`
+	selectSyncRunsSQL = `SELECT id, data FROM sync_runs
+	    WHERE workspace_id = $1 AND key = $2
+	    ORDER BY id DESC
+	`
+	insertSyncRunSQL = `INSERT INTO sync_runs(workspace_id, key, data)
+	    VALUES($1, $2, $3)
+	    RETURNING id
+	`
+	updateSyncRunSQL = `UPDATE sync_runs SET data = $4
+	    WHERE workspace_id = $1 AND key = $2 AND id = $3
+	`
+
+	selectAuthorizationSQL = `SELECT
+		workspace_id, service, workspace_token, data
+		FROM authorizations
+		WHERE workspace_id = $1
+		AND service = $2
+		LIMIT 1
+  `
+	selectAuthorizedServicesSQL = `SELECT service FROM authorizations
+    WHERE workspace_id = $1
+  `
+	insertAuthorizationSQL = `INSERT INTO
+		authorizations(workspace_id, service, workspace_token, data)
+		VALUES($1, $2, $3, $4)
+		ON CONFLICT (workspace_id, service)
+		DO UPDATE SET workspace_token = EXCLUDED.workspace_token, data = EXCLUDED.data
+  `
+	deleteAuthorizationSQL = `DELETE FROM authorizations
+		WHERE workspace_id = $1
+		AND service = $2
+	`
+
+	selectWorkspaceTokenSQL = `SELECT workspace_id
+    FROM workspace_tokens
+    WHERE token_hash = $1
+    AND expires_at > NOW()
+    LIMIT 1
+  `
+	insertWorkspaceTokenSQL = `
+    WITH existing_token AS (
+      UPDATE workspace_tokens SET workspace_id = $2, expires_at = $3
+      WHERE token_hash = $1
+      RETURNING token_hash
+    ),
+    inserted_token AS (
+      INSERT INTO workspace_tokens(token_hash, workspace_id, expires_at)
+      SELECT $1, $2, $3
+      WHERE NOT EXISTS (SELECT 1 FROM existing_token)
+      RETURNING token_hash
+    )
+    SELECT * FROM inserted_token
+    UNION
+    SELECT * FROM existing_token
+  `
+	deleteWorkspaceTokenSQL = `DELETE FROM workspace_tokens
+    WHERE token_hash = $1
+  `
+)
+
+// PostgresStorage is the production Storage
+type PostgresStorage struct {
+	db *sql.DB
+}
+
+func NewPostgresStorage(connString string) (*PostgresStorage, error) {
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStorage{db: db}, nil
+}
+
+func (s *PostgresStorage) IsDown() bool {
+	if _, err := s.db.Exec("SELECT 1"); err != nil {
+		return true
+	}
+	return false
+}
+
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *PostgresStorage) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) LoadPipe(workspaceID int, key string) (*Pipe, error) {
+	rows, err := s.db.Query(singlePipesSQL, workspaceID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanPipe(rows)
+}
+
+func (s *PostgresStorage) LoadPipes(workspaceID int) (map[string]*Pipe, error) {
+	pipes := make(map[string]*Pipe)
+	rows, err := s.db.Query(selectPipesSQL, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		pipe, err := scanPipe(rows)
+		if err != nil {
+			return nil, err
+		}
+		pipes[pipe.key] = pipe
+	}
+	return pipes, rows.Err()
+}
+
+func scanPipe(rows *sql.Rows) (*Pipe, error) {
+	var wid int
+	var b []byte
+	var key string
+	if err := rows.Scan(&wid, &key, &b); err != nil {
+		return nil, err
+	}
+	return decodePipe(wid, key, b)
+}
+
+func (s *PostgresStorage) SavePipe(p *Pipe) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(insertPipesSQL, p.workspaceID, p.key, b)
+	return err
+}
+
+func (s *PostgresStorage) DeletePipe(workspaceID int, key string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deletePipeSQL, workspaceID, key); err != nil {
+			return err
+		}
+		_, err := tx.Exec(deletePipeStatusSQL, workspaceID, key)
+		return err
+	})
+}
+
+// likeEscaper keeps LIKE wildcards in service IDs from matching other services
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *PostgresStorage) DeleteServicePipes(workspaceID int, serviceID string) error {
+	_, err := s.db.Exec(deletePipeSQL, workspaceID, likeEscaper.Replace(serviceID)+":%")
+	return err
+}
+
+func (s *PostgresStorage) LoadPipeStatus(workspaceID int, key string) (*PipeStatus, error) {
+	rows, err := s.db.Query(singlePipeStatusSQL, workspaceID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var b []byte
+	if err := rows.Scan(&b); err != nil {
+		return nil, err
+	}
+	return decodePipeStatus(workspaceID, key, b)
+}
+
+func (s *PostgresStorage) LoadPipeStatuses(workspaceID int) (map[string]*PipeStatus, error) {
+	pipeStatuses := make(map[string]*PipeStatus)
+	rows, err := s.db.Query(selectPipeStatusSQL, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b []byte
+		var key string
+		if err := rows.Scan(&key, &b); err != nil {
+			return nil, err
+		}
+		pipeStatus, err := decodePipeStatus(workspaceID, key, b)
+		if err != nil {
+			return nil, err
+		}
+		pipeStatuses[key] = pipeStatus
+	}
+	return pipeStatuses, rows.Err()
+}
+
+func (s *PostgresStorage) SavePipeStatus(p *PipeStatus) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(insertPipeStatusSQL, p.workspaceID, p.key, b)
+	return err
+}
+
+func (s *PostgresStorage) LoadLastSync(workspaceID int, key string) (*time.Time, error) {
+	var lastSync *time.Time
+	err := s.db.QueryRow(lastSyncSQL, workspaceID, key).Scan(&lastSync)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return lastSync, err
+}
+
+func (s *PostgresStorage) LoadConnection(workspaceID int, key string) (map[string]int, error) {
+	data := make(map[string]int)
+	err := s.eachConnectionItem(workspaceID, key, func(foreignID string, togglID int) {
+		data[foreignID] = togglID
+	})
+	return data, err
+}
+
+func (s *PostgresStorage) LoadReversedConnection(workspaceID int, key string) (map[int]string, error) {
+	data := make(map[int]string)
+	err := s.eachConnectionItem(workspaceID, key, func(foreignID string, togglID int) {
+		data[togglID] = foreignID
+	})
+	return data, err
+}
+
+func (s *PostgresStorage) eachConnectionItem(workspaceID int, key string, fn func(foreignID string, togglID int)) error {
+	rows, err := s.db.Query(selectConnectionSQL, workspaceID, key)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var foreignID string
+		var togglID int
+		if err := rows.Scan(&foreignID, &togglID); err != nil {
+			return err
+		}
+		fn(foreignID, togglID)
+	}
+	return rows.Err()
+}
+
+func (s *PostgresStorage) SaveConnection(workspaceID int, key string, upserts map[string]int, deletes []string) error {
+	foreignIDs := make([]string, 0, len(upserts))
+	togglIDs := make([]int64, 0, len(upserts))
+	for foreignID, togglID := range upserts {
+		foreignIDs = append(foreignIDs, foreignID)
+		togglIDs = append(togglIDs, int64(togglID))
+	}
+	return s.inTx(func(tx *sql.Tx) error {
+		if len(foreignIDs) > 0 {
+			_, err := tx.Exec(upsertConnectionItemsSQL, workspaceID, key, pq.Array(foreignIDs), pq.Array(togglIDs))
+			if err != nil {
+				return err
+			}
+		}
+		if len(deletes) > 0 {
+			_, err := tx.Exec(deleteConnectionItemsSQL, workspaceID, key, pq.Array(deletes))
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStorage) ClearConnection(workspaceID int, connectionKey, pipeKey string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deletePipeConnectionsSQL, workspaceID, connectionKey); err != nil {
+			return err
+		}
+		_, err := tx.Exec(deletePipeStatusSQL, workspaceID, pipeKey)
+		return err
+	})
+}
+
+func (s *PostgresStorage) LoadImport(workspaceID int, key string) ([]byte, error) {
+	var result []byte
+	rows, err := s.db.Query(selectImportSQL, workspaceID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	if err := rows.Scan(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *PostgresStorage) SaveImport(workspaceID int, key string, data []byte, hash string) (bool, error) {
+	res, err := s.db.Exec(insertImportSQL, workspaceID, key, data, hash, len(data))
+	if err != nil {
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	return inserted > 0, err
+}
+
+func (s *PostgresStorage) CompactImports(keepLast int, maxAge time.Duration) (int64, error) {
+	res, err := s.db.Exec(compactImportsSQL, keepLast, maxAge.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *PostgresStorage) LoadImportStats(workspaceID int) ([]ImportStats, error) {
+	rows, err := s.db.Query(selectImportStatsSQL, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []ImportStats
+	for rows.Next() {
+		var stat ImportStats
+		if err := rows.Scan(&stat.Key, &stat.Count, &stat.Bytes, &stat.LatestAt); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+func (s *PostgresStorage) DeleteImports(workspaceID int, key string) error {
+	_, err := s.db.Exec(deleteImportsSQL, workspaceID, key)
+	return err
+}
+
+func (s *PostgresStorage) LoadSyncRun(workspaceID int, key string, id int) (*SyncRun, error) {
+	runs, err := s.loadSyncRuns(workspaceID, key, selectSyncRunSQL, workspaceID, key, id)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return runs[0], nil
+}
+
+func (s *PostgresStorage) LoadSyncRuns(workspaceID int, key string) ([]*SyncRun, error) {
+	return s.loadSyncRuns(workspaceID, key, selectSyncRunsSQL, workspaceID, key)
+}
+
+func (s *PostgresStorage) loadSyncRuns(workspaceID int, key, query string, args ...interface{}) ([]*SyncRun, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []*SyncRun
+	for rows.Next() {
+		var id int
+		var b []byte
+		if err := rows.Scan(&id, &b); err != nil {
+			return nil, err
+		}
+		run, err := decodeSyncRun(workspaceID, key, id, b)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+func (s *PostgresStorage) SaveSyncRun(r *SyncRun) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if r.ID > 0 {
+		_, err = s.db.Exec(updateSyncRunSQL, r.workspaceID, r.key, r.ID, b)
+		return err
+	}
+	return s.db.QueryRow(insertSyncRunSQL, r.workspaceID, r.key, b).Scan(&r.ID)
+}
+
+func (s *PostgresStorage) LoadAuthorization(workspaceID int, serviceID string) (*Authorization, error) {
+	rows, err := s.db.Query(selectAuthorizationSQL, workspaceID, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	var a Authorization
+	if err := rows.Scan(&a.WorkspaceID, &a.ServiceID, &a.WorkspaceToken, &a.Data); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *PostgresStorage) LoadAuthorizedServices(workspaceID int) (map[string]bool, error) {
+	authorizations := make(map[string]bool)
+	rows, err := s.db.Query(selectAuthorizedServicesSQL, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var service string
+		if err := rows.Scan(&service); err != nil {
+			return nil, err
+		}
+		authorizations[service] = true
+	}
+	return authorizations, rows.Err()
+}
+
+func (s *PostgresStorage) SaveAuthorization(a *Authorization) error {
+	_, err := s.db.Exec(insertAuthorizationSQL,
+		a.WorkspaceID, a.ServiceID, a.WorkspaceToken, a.Data)
+	return err
+}
+
+func (s *PostgresStorage) DeleteAuthorization(workspaceID int, serviceID string) error {
+	_, err := s.db.Exec(deleteAuthorizationSQL, workspaceID, serviceID)
+	return err
+}
+
+func (s *PostgresStorage) QueueAutomaticPipes() error {
+	_, err := s.db.Exec(queueAutomaticPipesSQL)
+	return err
+}
+
+func (s *PostgresStorage) QueuePipeAsFirst(workspaceID int, key string) error {
+	_, err := s.db.Exec(queuePipeAsFirstSQL, workspaceID, key)
+	return err
+}
+
+func (s *PostgresStorage) LoadQueuedPipes() ([]*Pipe, error) {
+	var pipes []*Pipe
+	rows, err := s.db.Query(selectPipesFromQueueSQL)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workspaceID int
+		var key string
+		err := rows.Scan(&workspaceID, &key)
+		if err != nil {
+			return nil, err
+		}
+
+		if workspaceID > 0 && len(key) > 0 {
+			pipe, err := s.LoadPipe(workspaceID, key)
+			if err != nil {
+				return nil, err
+			}
+			pipes = append(pipes, pipe)
+		}
+	}
+	return pipes, rows.Err()
+}
+
+func (s *PostgresStorage) SetQueuedPipeSynced(p *Pipe) error {
+	_, err := s.db.Exec(setQueuedPipeSyncedSQL, p.workspaceID, p.key)
+	return err
+}
+
+func (s *PostgresStorage) LoadWorkspaceToken(hash string) (int, bool, error) {
+	var workspaceID int
+	err := s.db.QueryRow(selectWorkspaceTokenSQL, hash).Scan(&workspaceID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return workspaceID, true, nil
+}
+
+func (s *PostgresStorage) SaveWorkspaceToken(hash string, workspaceID int, expiresAt time.Time) error {
+	_, err := s.db.Exec(insertWorkspaceTokenSQL, hash, workspaceID, expiresAt)
+	return err
+}
+
+func (s *PostgresStorage) DeleteWorkspaceToken(hash string) error {
+	_, err := s.db.Exec(deleteWorkspaceTokenSQL, hash)
+	return err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// tagsPipeID imports labels of the service as Toggl tags, its connection
+// keeps the tag of a label when the label is renamed
+const tagsPipeID = "tags"
+
+type (
+	tagRequest struct {
+		Tags []*Tag `json:"tags"`
+	}
+
+	TagsImport struct {
+		Tags          []*Tag   `json:"tags"`
+		Notifications []string `json:"notifications"`
+	}
+)
+
+func (p *TagsImport) Count() int {
+	return len(p.Tags)
+}
+
+func getTags(s Service) (*TagsResponse, error) {
+	b, err := getObject(s, tagsPipeID)
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	var tagsResponse TagsResponse
+	err = json.Unmarshal(b, &tagsResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &tagsResponse, nil
+}
+
+func fetchTags(p *Pipe) error {
+	response := TagsResponse{}
+	defer func() { saveObject(p, tagsPipeID, response) }()
+
+	service, err := p.Service()
+	if err != nil {
+		return err
+	}
+	tags, err := service.Tags()
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	connection, err := loadConnection(service, tagsPipeID)
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	neverSync, err := loadNeverSync(service, tagsPipeID)
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	response.Tags = make([]*Tag, 0, len(tags))
+	for _, tag := range tags {
+		if _, excluded := neverSync.Data[tag.ForeignID]; excluded {
+			continue
+		}
+		tag.ID = connection.Data[tag.ForeignID]
+		response.Tags = append(response.Tags, tag)
+	}
+	return nil
+}
+
+// postTags posts tags with the Toggl IDs they are linked to,
+// so Toggl renames the tag of a renamed label
+func postTags(p *Pipe) error {
+	s, err := p.Service()
+	if err != nil {
+		return err
+	}
+	tagsResponse, err := getTags(s)
+	if err != nil {
+		return errors.New("unable to get tags from DB")
+	}
+	if tagsResponse == nil {
+		return errors.New("service tags not found")
+	}
+	connection, err := loadConnection(s, tagsPipeID)
+	if err != nil {
+		return err
+	}
+	tags := tagsResponse.Tags
+	repair := &linkRepair{
+		post: func(from, to int) ([]byte, error) {
+			if err := p.syncRun.snapshot(p, tagsPipeID, tagObjects(tags[from:to])); err != nil {
+				return nil, err
+			}
+			return togglClient.PostPipesAPI(p.authorization.WorkspaceToken, tagsPipeID, tagRequest{Tags: tags[from:to]})
+		},
+		handle: func(b []byte) ([]string, int, error) {
+			var tagsImport TagsImport
+			if err := json.Unmarshal(b, &tagsImport); err != nil {
+				return nil, 0, err
+			}
+			for _, tag := range tagsImport.Tags {
+				if err := p.syncRun.record(tagsPipeID, tagsPipeID, tag.ForeignID, connection.Data[tag.ForeignID],
+					TogglObject{ID: tag.ID, Name: tag.Name}); err != nil {
+					return nil, 0, err
+				}
+				connection.Data[tag.ForeignID] = tag.ID
+			}
+			return tagsImport.Notifications, tagsImport.Count(), nil
+		},
+		unlink: func(i int) func() {
+			return unlinkObject(connection, tags[i].ForeignID, &tags[i].ID)
+		},
+		describe: func(i int) string {
+			return fmt.Sprintf("Tag '%s'", tags[i].Name)
+		},
+	}
+	if err := repair.run(len(tags)); err != nil {
+		return err
+	}
+	if err := connection.save(); err != nil {
+		return err
+	}
+	p.PipeStatus.RepairedLinks += repair.repairedCount()
+	p.PipeStatus.complete(tagsPipeID, repair.notifications(), repair.count)
+	return nil
+}
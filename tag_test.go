@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestRenamedTagsKeepTheirLink(t *testing.T) {
+	p := NewPipe(49, TestServiceName, tagsPipeID)
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := NewConnection(s, tagsPipeID)
+	connection.Data["1"] = 55
+	if err := connection.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fetchTags(p); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeTogglAPI{responses: map[string][]byte{
+		tagsPipeID: []byte(`{"tags":[{"id":55,"name":"bug","foreign_id":"1"},{"id":56,"name":"feature","foreign_id":"2"}]}`),
+	}}
+	defer withFakeTogglAPI(fake)()
+	if err := postTags(p); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.payloads[tagsPipeID]) != `{"tags":[{"id":55,"name":"bug","foreign_id":"1"},{"name":"feature","foreign_id":"2"}]}` {
+		t.Errorf("expected linked tag to be posted with its Toggl ID, got %s", fake.payloads[tagsPipeID])
+	}
+	if connection, err = loadConnection(s, tagsPipeID); err != nil {
+		t.Fatal(err)
+	}
+	if connection.Data["1"] != 55 || connection.Data["2"] != 56 {
+		t.Errorf("unexpected connection %v", connection.Data)
+	}
+}
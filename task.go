@@ -1,8 +1,17 @@
 package main
 
+// Optional task fields, they are overwritten in Toggl for services
+// which have values for them
+const (
+	taskFieldEstimate = "estimated_seconds"
+	taskFieldUser     = "uid"
+)
+
 type (
 	taskRequest struct {
 		Tasks []*Task `json:"tasks"`
+		// Fields are overwritten in Toggl even when the task has no value
+		Fields []string `json:"fields,omitempty"`
 	}
 	TasksImport struct {
 		Tasks         []*Task  `json:"tasks"`
@@ -13,3 +22,56 @@ type (
 func (p *TasksImport) Count() int {
 	return len(p.Tasks)
 }
+
+// availableTaskFields returns optional fields the service has values for,
+// assignees count even when they aren't linked to Toggl users, see requests
+func availableTaskFields(tasks []*Task) []string {
+	var estimates, users bool
+	for _, task := range tasks {
+		estimates = estimates || task.EstimatedSeconds > 0
+		users = users || task.foreignUserID != ""
+	}
+	var fields []string
+	if estimates {
+		fields = append(fields, taskFieldEstimate)
+	}
+	if users {
+		fields = append(fields, taskFieldUser)
+	}
+	return fields
+}
+
+// requests splits tasks into requests, tasks with unlinked assignees
+// are posted without the user field so their Toggl user is kept
+func (r *TasksResponse) requests(tasks []*Task) ([]*taskRequest, error) {
+	if len(r.UnlinkedAssignees) == 0 {
+		return adjustRequestSize(tasks, r.Fields, 1)
+	}
+	unlinked := make(map[string]bool, len(r.UnlinkedAssignees))
+	for _, foreignID := range r.UnlinkedAssignees {
+		unlinked[foreignID] = true
+	}
+	var linkedTasks, unlinkedTasks []*Task
+	for _, task := range tasks {
+		if unlinked[task.ForeignID] {
+			unlinkedTasks = append(unlinkedTasks, task)
+		} else {
+			linkedTasks = append(linkedTasks, task)
+		}
+	}
+	var fields []string
+	for _, field := range r.Fields {
+		if field != taskFieldUser {
+			fields = append(fields, field)
+		}
+	}
+	trs, err := adjustRequestSize(linkedTasks, r.Fields, 1)
+	if err != nil {
+		return nil, err
+	}
+	unlinkedRequests, err := adjustRequestSize(unlinkedTasks, fields, 1)
+	if err != nil {
+		return nil, err
+	}
+	return append(trs, unlinkedRequests...), nil
+}
@@ -4,12 +4,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"strconv"
 
 	"code.google.com/p/goauth2/oauth"
 	"github.com/toggl/go-teamweek"
 )
 
+// teamweekAPIURL is replaced in tests
+var teamweekAPIURL = "https://teamweek.com/api/v4/"
+
+// teamweekProject adds the color go-teamweek projects lack
+type teamweekProject struct {
+	teamweek.Project
+	Color string `json:"color"`
+}
+
 type TeamweekService struct {
 	emptyService
 	workspaceID int
@@ -54,10 +65,34 @@ func (s *TeamweekService) setAuthData(b []byte) error {
 }
 
 func (s *TeamweekService) client() *teamweek.Client {
-	t := &oauth.Transport{Token: &s.token}
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
 	return teamweek.NewClient(t.Client())
 }
 
+// get requests path of the API and decodes the response into v
+func (s *TeamweekService) get(path string, v interface{}) error {
+	t := &oauth.Transport{
+		Token:     &s.token,
+		Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID()),
+	}
+	resp, err := t.Client().Get(teamweekAPIURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("teamweek: %s failed with status code %d", path, resp.StatusCode)
+	}
+	return json.Unmarshal(b, v)
+}
+
 // Map Teamweek accounts to local accounts
 func (s *TeamweekService) Accounts() ([]*Account, error) {
 	foreignObject, err := s.client().GetUserProfile()
@@ -96,10 +131,54 @@ func (s *TeamweekService) Users() ([]*User, error) {
 	return users, nil
 }
 
+// Map Teamweek groups to groups
+func (s *TeamweekService) Groups() ([]*Group, error) {
+	var foreignObjects []teamweek.Group
+	if err := s.get(fmt.Sprintf("%d/groups", s.AccountID), &foreignObjects); err != nil {
+		return nil, err
+	}
+	var groups []*Group
+	for _, object := range foreignObjects {
+		group := Group{
+			ForeignID: strconv.FormatInt(object.ID, 10),
+			Name:      object.Name,
+		}
+		for _, membership := range object.Memberships {
+			group.ForeignUserIDs = append(group.ForeignUserIDs, strconv.FormatInt(membership.UserID, 10))
+		}
+		groups = append(groups, &group)
+	}
+	return groups, nil
+}
+
+// Map people with tasks in Teamweek projects to project members,
+// Teamweek projects have no members of their own
+func (s *TeamweekService) ProjectMembers() (map[string][]string, error) {
+	var foreignObjects []teamweek.Task
+	if err := s.get(fmt.Sprintf("%d/tasks", s.AccountID), &foreignObjects); err != nil {
+		return nil, err
+	}
+	members := make(map[string][]string)
+	seen := map[string]bool{}
+	for _, object := range foreignObjects {
+		if object.ProjectID == 0 || object.UserID == 0 {
+			continue
+		}
+		projectID := strconv.FormatInt(object.ProjectID, 10)
+		userID := strconv.FormatInt(object.UserID, 10)
+		if seen[projectID+":"+userID] {
+			continue
+		}
+		seen[projectID+":"+userID] = true
+		members[projectID] = append(members[projectID], userID)
+	}
+	return members, nil
+}
+
 // Map Teamweek projects to projects
 func (s *TeamweekService) Projects() ([]*Project, error) {
-	foreignObjects, err := s.client().ListWorkspaceProjects(int64(s.AccountID))
-	if err != nil {
+	var foreignObjects []teamweekProject
+	if err := s.get(fmt.Sprintf("%d/projects", s.AccountID), &foreignObjects); err != nil {
 		return nil, err
 	}
 	var projects []*Project
@@ -108,6 +187,7 @@ func (s *TeamweekService) Projects() ([]*Project, error) {
 			ForeignID: strconv.FormatInt(object.ID, 10),
 			Name:      object.Name,
 			Active:    true,
+			Color:     object.Color,
 		}
 		projects = append(projects, &project)
 	}
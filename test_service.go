@@ -48,3 +48,31 @@ func (s *TestService) Projects() ([]*Project, error) {
 	ps = append(ps, &Project{Name: p5Name})
 	return ps, nil
 }
+
+func (s *TestService) Tags() ([]*Tag, error) {
+	return []*Tag{
+		{ForeignID: "1", Name: "bug"},
+		{ForeignID: "2", Name: "feature"},
+	}, nil
+}
+
+func (s *TestService) Users() ([]*User, error) {
+	return []*User{
+		{ForeignID: "1", Name: "Alice", Email: "alice@example.com"},
+		{ForeignID: "2", Name: "Bob", Email: "bob@example.com"},
+	}, nil
+}
+
+func (s *TestService) Groups() ([]*Group, error) {
+	return []*Group{
+		{ForeignID: "7", Name: "Design", ForeignUserIDs: []string{"1", "2", "4"}},
+	}, nil
+}
+
+func (s *TestService) TimeEntries() ([]*TimeEntry, error) {
+	return []*TimeEntry{
+		{Start: "2026-10-01T09:30:00Z", DurationInSeconds: 3600, foreignID: "901", foreignUserID: "1", foreignProjectID: "p1"},
+		{Start: "2026-10-02T09:30:00Z", DurationInSeconds: 3600, foreignID: "902", foreignUserID: "1", foreignProjectID: "p2"},
+		{Start: "2026-10-03T09:30:00Z", DurationInSeconds: 3600, foreignID: "903", foreignUserID: "2", foreignProjectID: "p1"},
+	}, nil
+}
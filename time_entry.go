@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// importedTimeEntriesPipeID imports time entries of the service to Toggl,
+// the timeentries pipe exports Toggl time entries to the service
+const importedTimeEntriesPipeID = "timeentries_import"
+
+// timeEntriesConnectionID maps Toggl time entry IDs to foreign IDs,
+// it is shared by both directions so entries aren't copied back
+const timeEntriesConnectionID = "time_entries"
+
+const timeEntriesPerRequest = 200
+
+type (
+	timeEntryRequest struct {
+		TimeEntries []*ImportedTimeEntry `json:"time_entries"`
+	}
+
+	TimeEntriesImport struct {
+		TimeEntries   []*ImportedTimeEntry `json:"time_entries"`
+		Notifications []string             `json:"notifications"`
+	}
+)
+
+func (p *TimeEntriesImport) Count() int {
+	return len(p.TimeEntries)
+}
+
+func getImportedTimeEntries(s Service) (*TimeEntriesResponse, error) {
+	b, err := getObject(s, importedTimeEntriesPipeID)
+	if err != nil || b == nil {
+		return nil, err
+	}
+
+	var timeEntriesResponse TimeEntriesResponse
+	err = json.Unmarshal(b, &timeEntriesResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &timeEntriesResponse, nil
+}
+
+// fetchImportedTimeEntries skips time entries of users and projects
+// which aren't linked by the users and projects pipes, unlinked projects
+// are reported so they can be imported first
+func fetchImportedTimeEntries(p *Pipe) error {
+	response := TimeEntriesResponse{}
+	defer func() { saveObject(p, importedTimeEntriesPipeID, response) }()
+
+	service, err := p.Service()
+	if err != nil {
+		return err
+	}
+	service.setSince(p.fetchSince(importedTimeEntriesPipeID))
+	timeEntries, err := service.TimeEntries()
+	if err != nil {
+		response.Error = err.Error()
+		return err
+	}
+
+	var userConnections, projectConnections, taskConnections *Connection
+	var entryConnections *ReversedConnection
+	if userConnections, err = loadConnection(service, usersPipeID); err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	if projectConnections, err = loadConnection(service, projectsPipeID); err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	if taskConnections, err = loadConnection(service, tasksPipeId); err != nil {
+		response.Error = err.Error()
+		return err
+	}
+	if entryConnections, err = loadConnectionRev(service, timeEntriesConnectionID); err != nil {
+		response.Error = err.Error()
+		return err
+	}
+
+	unmapped := make(map[string]bool)
+	response.TimeEntries = make([]*ImportedTimeEntry, 0, len(timeEntries))
+	for _, entry := range timeEntries {
+		entry.UserID = userConnections.Data[entry.foreignUserID]
+		if entry.UserID == 0 {
+			continue
+		}
+		entry.ProjectID = projectConnections.Data[entry.foreignProjectID]
+		if entry.ProjectID == 0 && entry.foreignProjectID != "" {
+			unmapped[entry.foreignProjectID] = true
+			continue
+		}
+		entry.ID, _ = strconv.Atoi(entryConnections.Data[numberStrToInt(entry.foreignID)])
+		entry.TaskID = taskConnections.Data[entry.foreignTaskID]
+		response.TimeEntries = append(response.TimeEntries, &ImportedTimeEntry{
+			TimeEntry: *entry,
+			ForeignID: entry.foreignID,
+		})
+	}
+	response.UnmappedProjects = projectNames(service, unmapped)
+	return nil
+}
+
+// projectNames returns names of the foreign projects as last fetched
+// by the projects pipe, foreign IDs are used for projects never fetched
+func projectNames(s Service, foreignIDs map[string]bool) []string {
+	if len(foreignIDs) == 0 {
+		return nil
+	}
+	names := make(map[string]string)
+	if projectsResponse, err := getProjects(s); err == nil && projectsResponse != nil {
+		for _, project := range projectsResponse.Projects {
+			names[project.ForeignID] = project.Name
+		}
+	}
+	result := make([]string, 0, len(foreignIDs))
+	for foreignID := range foreignIDs {
+		if name, exists := names[foreignID]; exists {
+			result = append(result, name)
+		} else {
+			result = append(result, foreignID)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func postImportedTimeEntries(p *Pipe) error {
+	s, err := p.Service()
+	if err != nil {
+		return err
+	}
+	timeEntriesResponse, err := getImportedTimeEntries(s)
+	if err != nil {
+		return errors.New("unable to get time entries from DB")
+	}
+	if timeEntriesResponse == nil {
+		return errors.New("service time entries not found")
+	}
+	connection, err := loadConnection(s, timeEntriesConnectionID)
+	if err != nil {
+		return err
+	}
+	timeEntries := timeEntriesResponse.TimeEntries
+	repair := &linkRepair{
+		post: func(from, to int) ([]byte, error) {
+			return togglClient.PostPipesAPI(p.authorization.WorkspaceToken, timeEntriesConnectionID, timeEntryRequest{TimeEntries: timeEntries[from:to]})
+		},
+		handle: func(b []byte) ([]string, int, error) {
+			var timeEntriesImport TimeEntriesImport
+			if err := json.Unmarshal(b, &timeEntriesImport); err != nil {
+				return nil, 0, err
+			}
+			for _, entry := range timeEntriesImport.TimeEntries {
+				connection.Data[strconv.Itoa(entry.ID)] = numberStrToInt(entry.ForeignID)
+			}
+			return timeEntriesImport.Notifications, timeEntriesImport.Count(), nil
+		},
+		unlink: func(i int) func() {
+			return unlinkObject(connection, strconv.Itoa(timeEntries[i].ID), &timeEntries[i].ID)
+		},
+		describe: func(i int) string {
+			return fmt.Sprintf("Time entry '%s' of %s", timeEntries[i].Description, timeEntries[i].Start)
+		},
+	}
+	for from := 0; from < len(timeEntries); from += timeEntriesPerRequest {
+		to := from + timeEntriesPerRequest
+		if to > len(timeEntries) {
+			to = len(timeEntries)
+		}
+		if err := repair.postRange(from, to, 0); err != nil {
+			return err
+		}
+	}
+	if err := connection.save(); err != nil {
+		return err
+	}
+	notifications := repair.notifications()
+	if len(timeEntriesResponse.UnmappedProjects) > 0 {
+		notifications = append(notifications, fmt.Sprintf("Time entries of projects which aren't imported yet were skipped, sync projects first: %s",
+			strings.Join(timeEntriesResponse.UnmappedProjects, ", ")))
+	}
+	p.PipeStatus.RepairedLinks += repair.repairedCount()
+	p.PipeStatus.complete("time entries", notifications, repair.count)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestPostImportedTimeEntries(t *testing.T) {
+	p := NewPipe(47, TestServiceName, importedTimeEntriesPipeID)
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveObject(p, importedTimeEntriesPipeID, TimeEntriesResponse{TimeEntries: []*ImportedTimeEntry{
+		{TimeEntry: TimeEntry{UserID: 3, Start: "2026-10-01T09:30:00Z", DurationInSeconds: 5400}, ForeignID: "900"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeTogglAPI{
+		responses: map[string][]byte{
+			timeEntriesConnectionID: []byte(`{"time_entries":[{"id":77,"uid":3,"start":"2026-10-01T09:30:00Z","duration":5400,"foreign_id":"900"}]}`),
+		},
+	}
+	defer withFakeTogglAPI(fake)()
+	if err := postImportedTimeEntries(p); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.payloads[timeEntriesConnectionID]) != `{"time_entries":[{"id":0,"uid":3,"billable":false,"start":"2026-10-01T09:30:00Z","duration":5400,"foreign_id":"900"}]}` {
+		t.Errorf("unexpected payload %s", fake.payloads[timeEntriesConnectionID])
+	}
+
+	// the export shares the connection, so imported entries aren't exported again
+	connection, err := loadConnection(s, timeEntriesConnectionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connection.Data["77"] != 900 {
+		t.Errorf("expected imported entry to be linked, got %v", connection.Data)
+	}
+}
+
+func TestFetchImportedTimeEntriesSkipsUnmappedProjects(t *testing.T) {
+	p := NewPipe(55, TestServiceName, importedTimeEntriesPipeID)
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewConnection(s, usersPipeID)
+	users.Data["1"] = 3
+	projects := NewConnection(s, projectsPipeID)
+	projects.Data["p1"] = 11
+	for _, connection := range []*Connection{users, projects} {
+		if err := connection.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saveObject(p, projectsPipeID, ProjectsResponse{Projects: []*Project{{ForeignID: "p2", Name: "Website"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeTogglAPI{}
+	defer withFakeTogglAPI(fake)()
+	if err := fetchImportedTimeEntries(p); err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls != 0 {
+		t.Errorf("expected fetching not to call Toggl, got %d calls", fake.calls)
+	}
+	response, err := getImportedTimeEntries(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.TimeEntries) != 1 || response.TimeEntries[0].ForeignID != "901" || response.TimeEntries[0].ProjectID != 11 {
+		t.Errorf("expected only the entry of a linked user and project, got %+v", response.TimeEntries)
+	}
+	if len(response.UnmappedProjects) != 1 || response.UnmappedProjects[0] != "Website" {
+		t.Errorf("expected unlinked project to be reported, got %v", response.UnmappedProjects)
+	}
+
+	fake.responses = map[string][]byte{
+		timeEntriesConnectionID: []byte(`{"time_entries":[{"id":78,"uid":3,"pid":11,"foreign_id":"901"}]}`),
+	}
+	if err := postImportedTimeEntries(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.PipeStatus.Notifications) != 1 {
+		t.Errorf("expected skipped entries to be reported, got %v", p.PipeStatus.Notifications)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -12,26 +14,129 @@ import (
 	"time"
 )
 
-var togglAPIPingClient = &http.Client{
-	Timeout: 3 * time.Second,
+var (
+	// ErrInvalidAPIToken is returned when Toggl API rejects the API token
+	ErrInvalidAPIToken = errors.New("invalid API token")
+	// ErrTogglValidation is returned when Toggl API refuses the request payload
+	ErrTogglValidation = errors.New("toggl api validation failed")
+	// ErrTogglNotFound is returned when Toggl API can't find requested object
+	ErrTogglNotFound = errors.New("toggl api object not found")
+	// ErrTogglServer is returned when Toggl API fails to handle the request
+	ErrTogglServer = errors.New("toggl api server error")
+	// ErrTogglResponseTooLarge is returned when the response exceeds the size limit
+	ErrTogglResponseTooLarge = errors.New("toggl api response too large")
+)
+
+type (
+	// TogglAPI is used by pipes to talk to Toggl API,
+	// tests can replace togglClient with a fake implementation.
+	TogglAPI interface {
+		Ping() error
+		GetWorkspaceID(APIToken string) (int, error)
+		GetTimeEntries(APIToken string, lastSync time.Time, userIDs, projectsIDs []int) ([]TimeEntry, error)
+		PostPipesAPI(APIToken, pipeID string, payload interface{}) ([]byte, error)
+		DeleteProject(APIToken string, workspaceID, projectID int) error
+		// GetObjectNames returns names of workspace objects of the type by ID,
+		// objectType is users, clients, projects or tasks
+		GetObjectNames(APIToken string, workspaceID int, objectType string) (map[int]string, error)
+		// GetObjects returns workspace objects of the type, see GetObjectNames
+		GetObjects(APIToken string, workspaceID int, objectType string) ([]TogglObject, error)
+		// GetObject returns a single client, project or task,
+		// projectID is only used for tasks
+		GetObject(APIToken string, workspaceID int, objectType string, id, projectID int) (*TogglObject, error)
+		DeleteTask(APIToken string, workspaceID, projectID, taskID int) error
+		DeleteClient(APIToken string, workspaceID, clientID int) error
+		DeleteTag(APIToken string, workspaceID, tagID int) error
+	}
+
+	// TogglClient is the HTTP implementation of TogglAPI
+	TogglClient struct {
+		// URL of Toggl API, defaults to toggl_api_host of current environment
+		URL             string
+		Timeout         time.Duration
+		PingTimeout     time.Duration
+		MaxRetries      int
+		MaxResponseSize int64
+
+		client     *http.Client
+		pingClient *http.Client
+	}
+
+	// TogglError describes failed Toggl API request
+	TogglError struct {
+		Method     string
+		URL        string
+		StatusCode int
+		Body       []byte
+	}
+
+	workspaceResponse struct {
+		Workspace *Workspace `json:"data"`
+	}
+
+	// TogglObject is a workspace object as returned by Toggl API,
+	// ClientID is set for projects and ProjectID for tasks
+	TogglObject struct {
+		ID        int    `json:"id"`
+		Name      string `json:"name"`
+		FullName  string `json:"fullname,omitempty"`
+		ClientID  int    `json:"client_id,omitempty"`
+		ProjectID int    `json:"project_id,omitempty"`
+		Active    bool   `json:"active"`
+	}
+)
+
+var togglClient TogglAPI = NewTogglClient(30*time.Second, 2, 50*1000*1000)
+
+func NewTogglClient(timeout time.Duration, maxRetries int, maxResponseSize int64) *TogglClient {
+	return &TogglClient{
+		Timeout:         timeout,
+		PingTimeout:     3 * time.Second,
+		MaxRetries:      maxRetries,
+		MaxResponseSize: maxResponseSize,
+		client:          &http.Client{Timeout: timeout},
+		pingClient:      &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+func (e *TogglError) Error() string {
+	return fmt.Sprintf("%s %s failed with status code %d", e.Method, e.URL, e.StatusCode)
+}
+
+func (e *TogglError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrInvalidAPIToken
+	case e.StatusCode == http.StatusNotFound:
+		return ErrTogglNotFound
+	case e.StatusCode >= 500:
+		return ErrTogglServer
+	case e.StatusCode >= 400:
+		return ErrTogglValidation
+	}
+	return nil
+}
+
+func (c *TogglClient) host() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	return urls.TogglAPIHost[environment]
 }
 
-func pingTogglAPI() error {
-	url := fmt.Sprintf("%s/api/v9/status", urls.TogglAPIHost[environment])
-	resp, err := togglAPIPingClient.Get(url)
+func (c *TogglClient) Ping() error {
+	url := fmt.Sprintf("%s/api/v9/status", c.host())
+	resp, err := c.pingClient.Get(url)
 	if err != nil {
 		return fmt.Errorf("error checking toggl api: %s", err.Error())
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("toggl api is not healthy, got status code: %d", resp.StatusCode)
 	}
 	return nil
 }
 
-type workspaceResponse struct {
-	Workspace *Workspace `json:"data"`
-}
-
 func stringify(values []int) string {
 	s := make([]string, 0, len(values))
 	for _, value := range values {
@@ -40,96 +145,192 @@ func stringify(values []int) string {
 	return strings.Join(s, ",")
 }
 
-func getTogglTimeEntries(APIToken string, lastSync time.Time, userIDs, projectsIDs []int) ([]TimeEntry, error) {
+func (c *TogglClient) GetTimeEntries(APIToken string, lastSync time.Time, userIDs, projectsIDs []int) ([]TimeEntry, error) {
 	url := fmt.Sprintf("%s/api/pipes/time_entries?since=%d&user_ids=%s&project_ids=%s",
-		urls.TogglAPIHost[environment], lastSync.Unix(), stringify(userIDs), stringify(projectsIDs))
+		c.host(), lastSync.Unix(), stringify(userIDs), stringify(projectsIDs))
 
-	req, err := http.NewRequest("GET", url, nil)
+	b, err := c.do(APIToken, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "toggl-pipes")
-	req.SetBasicAuth(APIToken, "api_token")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
+	var timeEntries []TimeEntry
+	if err := json.Unmarshal(b, &timeEntries); err != nil {
 		return nil, err
 	}
-	var b []byte
-	defer resp.Body.Close()
-	b, err = ioutil.ReadAll(resp.Body)
+	return timeEntries, nil
+}
+
+func (c *TogglClient) GetWorkspaceID(APIToken string) (int, error) {
+	url := fmt.Sprintf("%s/api/pipes/workspace", c.host())
+	b, err := c.do(APIToken, "GET", url, nil)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	if http.StatusOK != resp.StatusCode {
-		return nil, fmt.Errorf("GET time_entries failed %d", resp.StatusCode)
+	var response workspaceResponse
+	if err := json.Unmarshal(b, &response); err != nil {
+		return 0, err
 	}
-	var timeEntries []TimeEntry
-	if err := json.Unmarshal(b, &timeEntries); err != nil {
-		return nil, err
+	if response.Workspace == nil {
+		return 0, errors.New("toggl api returned no workspace")
 	}
-	return timeEntries, nil
+	return response.Workspace.ID, nil
 }
 
-func getTogglWorkspaceID(APIToken string) (int, error) {
-	var workspaceID int
-	url := fmt.Sprintf("%s/api/pipes/workspace", urls.TogglAPIHost[environment])
-	req, err := http.NewRequest("GET", url, nil)
+func (c *TogglClient) PostPipesAPI(APIToken, pipeID string, payload interface{}) ([]byte, error) {
+	start := time.Now()
+	url := fmt.Sprintf("%s/api/pipes/%s", c.host(), pipeID)
+	b, err := json.Marshal(payload)
 	if err != nil {
-		return workspaceID, err
+		return nil, err
 	}
-	req.Header.Set("User-Agent", "toggl-pipes")
-	req.SetBasicAuth(APIToken, "api_token")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	b, err = c.do(APIToken, "POST", url, b)
 	if err != nil {
-		return workspaceID, err
+		return b, err
 	}
-	var b []byte
-	defer resp.Body.Close()
-	b, err = ioutil.ReadAll(resp.Body)
+	log.Println("Toggl request", url, "time", time.Since(start))
+	return b, nil
+}
+
+func (c *TogglClient) DeleteProject(APIToken string, workspaceID, projectID int) error {
+	url := fmt.Sprintf("%s/api/v9/workspaces/%d/projects/%d", c.host(), workspaceID, projectID)
+	_, err := c.do(APIToken, "DELETE", url, nil)
+	return err
+}
+
+func (c *TogglClient) DeleteTask(APIToken string, workspaceID, projectID, taskID int) error {
+	url := fmt.Sprintf("%s/api/v9/workspaces/%d/projects/%d/tasks/%d", c.host(), workspaceID, projectID, taskID)
+	_, err := c.do(APIToken, "DELETE", url, nil)
+	return err
+}
+
+func (c *TogglClient) DeleteClient(APIToken string, workspaceID, clientID int) error {
+	url := fmt.Sprintf("%s/api/v9/workspaces/%d/clients/%d", c.host(), workspaceID, clientID)
+	_, err := c.do(APIToken, "DELETE", url, nil)
+	return err
+}
+
+func (c *TogglClient) DeleteTag(APIToken string, workspaceID, tagID int) error {
+	url := fmt.Sprintf("%s/api/v9/workspaces/%d/tags/%d", c.host(), workspaceID, tagID)
+	_, err := c.do(APIToken, "DELETE", url, nil)
+	return err
+}
+
+const objectNamesPerPage = 200
+
+func (c *TogglClient) GetObjectNames(APIToken string, workspaceID int, objectType string) (map[int]string, error) {
+	objects, err := c.GetObjects(APIToken, workspaceID, objectType)
 	if err != nil {
-		return workspaceID, err
+		return nil, err
 	}
-	if http.StatusOK != resp.StatusCode {
-		return workspaceID, fmt.Errorf("GET workspace failed %d", resp.StatusCode)
+	names := make(map[int]string, len(objects))
+	for _, object := range objects {
+		names[object.ID] = object.Name
 	}
+	return names, nil
+}
 
-	var response workspaceResponse
-	if err := json.Unmarshal(b, &response); err != nil {
-		return workspaceID, err
+func (c *TogglClient) GetObjects(APIToken string, workspaceID int, objectType string) ([]TogglObject, error) {
+	var result []TogglObject
+	seen := make(map[int]bool)
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/api/v9/workspaces/%d/%s?page=%d&per_page=%d",
+			c.host(), workspaceID, objectType, page, objectNamesPerPage)
+		b, err := c.do(APIToken, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		var objects []TogglObject
+		if err := json.Unmarshal(b, &objects); err != nil {
+			var paged struct {
+				Data []TogglObject `json:"data"`
+			}
+			if err := json.Unmarshal(b, &paged); err != nil {
+				return nil, err
+			}
+			objects = paged.Data
+		}
+		var added int
+		for _, object := range objects {
+			if seen[object.ID] {
+				continue
+			}
+			added++
+			seen[object.ID] = true
+			if object.Name == "" {
+				object.Name = object.FullName
+			}
+			result = append(result, object)
+		}
+		// endpoints without paging return everything on every page
+		if len(objects) < objectNamesPerPage || added == 0 {
+			return result, nil
+		}
 	}
-
-	return response.Workspace.ID, nil
 }
 
-func postPipesAPI(APIToken, pipeID string, payload interface{}) ([]byte, error) {
-	start := time.Now()
-	url := fmt.Sprintf("%s/api/pipes/%s", urls.TogglAPIHost[environment], pipeID)
-	b, err := json.Marshal(payload)
+func (c *TogglClient) GetObject(APIToken string, workspaceID int, objectType string, id, projectID int) (*TogglObject, error) {
+	url := fmt.Sprintf("%s/api/v9/workspaces/%d/%s/%d", c.host(), workspaceID, objectType, id)
+	if objectType == "tasks" {
+		url = fmt.Sprintf("%s/api/v9/workspaces/%d/projects/%d/tasks/%d", c.host(), workspaceID, projectID, id)
+	}
+	b, err := c.do(APIToken, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	buf := bytes.NewBuffer(b)
-	req, err := http.NewRequest("POST", url, buf)
-	if err != nil {
+	var object TogglObject
+	if err := json.Unmarshal(b, &object); err != nil {
 		return nil, err
 	}
+	return &object, nil
+}
+
+// do makes the request, retrying GET requests on network and server
+// failures and all requests which were rejected for being too frequent.
+func (c *TogglClient) do(APIToken, method, url string, payload []byte) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		b, retry, err := c.doOnce(APIToken, method, url, payload)
+		if err == nil || !retry || attempt >= c.MaxRetries {
+			return b, err
+		}
+		time.Sleep(backoffDelay(attempt))
+	}
+}
+
+func (c *TogglClient) doOnce(APIToken, method, url string, payload []byte) ([]byte, bool, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, false, err
+	}
 	req.Header.Set("User-Agent", "toggl-pipes")
 	req.SetBasicAuth(APIToken, "api_token")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, method == "GET", err
 	}
 	defer resp.Body.Close()
-	b, err = ioutil.ReadAll(resp.Body)
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.MaxResponseSize+1))
 	if err != nil {
-		return nil, err
+		return nil, method == "GET", err
 	}
-	if 200 != resp.StatusCode {
-		return b, fmt.Errorf("%s failed with status code %d", url, resp.StatusCode)
+	if int64(len(b)) > c.MaxResponseSize {
+		return nil, false, fmt.Errorf("%w: %s %s", ErrTogglResponseTooLarge, method, url)
 	}
-	log.Println("Toggl request", url, "time", time.Since(start))
-	return b, nil
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		workspaceIDCache.invalidate(APIToken)
+	}
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusTooManyRequests ||
+			(method == "GET" && resp.StatusCode >= 500)
+		return b, retry, &TogglError{Method: method, URL: url, StatusCode: resp.StatusCode, Body: b}
+	}
+	return b, false, nil
 }
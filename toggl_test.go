@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTogglAPI replaces togglClient in tests
+type fakeTogglAPI struct {
+	workspaces map[string]int
+	names      map[string]map[int]string
+	objects    map[string][]TogglObject
+	responses  map[string][]byte
+	payloads   map[string][]byte
+	deleted    []string
+	fetched    []string
+	calls      int
+}
+
+func (f *fakeTogglAPI) Ping() error { return nil }
+
+func (f *fakeTogglAPI) GetWorkspaceID(APIToken string) (int, error) {
+	f.calls++
+	workspaceID, found := f.workspaces[APIToken]
+	if !found {
+		return 0, &TogglError{Method: "GET", URL: "/api/pipes/workspace", StatusCode: http.StatusUnauthorized}
+	}
+	return workspaceID, nil
+}
+
+func (f *fakeTogglAPI) GetTimeEntries(string, time.Time, []int, []int) ([]TimeEntry, error) {
+	f.calls++
+	return nil, nil
+}
+
+func (f *fakeTogglAPI) PostPipesAPI(APIToken, pipeID string, payload interface{}) ([]byte, error) {
+	f.calls++
+	if f.payloads == nil {
+		f.payloads = make(map[string][]byte)
+	}
+	f.payloads[pipeID], _ = json.Marshal(payload)
+	return f.responses[pipeID], nil
+}
+
+func (f *fakeTogglAPI) DeleteProject(APIToken string, workspaceID, projectID int) error {
+	f.calls++
+	f.deleted = append(f.deleted, fmt.Sprintf("project:%d", projectID))
+	return nil
+}
+
+func (f *fakeTogglAPI) GetObjectNames(APIToken string, workspaceID int, objectType string) (map[int]string, error) {
+	f.calls++
+	return f.names[objectType], nil
+}
+
+func (f *fakeTogglAPI) GetObjects(APIToken string, workspaceID int, objectType string) ([]TogglObject, error) {
+	f.calls++
+	f.fetched = append(f.fetched, objectType)
+	return f.objects[objectType], nil
+}
+
+func (f *fakeTogglAPI) GetObject(APIToken string, workspaceID int, objectType string, id, projectID int) (*TogglObject, error) {
+	f.calls++
+	f.fetched = append(f.fetched, fmt.Sprintf("%s:%d", objectType, id))
+	for _, object := range f.objects[objectType] {
+		if object.ID == id {
+			return &object, nil
+		}
+	}
+	return nil, &TogglError{Method: "GET", URL: "/api/v9/" + objectType, StatusCode: http.StatusNotFound, Body: []byte("Not Found")}
+}
+
+func (f *fakeTogglAPI) DeleteTask(APIToken string, workspaceID, projectID, taskID int) error {
+	f.calls++
+	f.deleted = append(f.deleted, fmt.Sprintf("task:%d", taskID))
+	return nil
+}
+
+func (f *fakeTogglAPI) DeleteClient(APIToken string, workspaceID, clientID int) error {
+	f.calls++
+	f.deleted = append(f.deleted, fmt.Sprintf("client:%d", clientID))
+	return nil
+}
+
+func (f *fakeTogglAPI) DeleteTag(APIToken string, workspaceID, tagID int) error {
+	f.calls++
+	f.deleted = append(f.deleted, fmt.Sprintf("tag:%d", tagID))
+	return nil
+}
+
+func withFakeTogglAPI(f *fakeTogglAPI) func() {
+	old := togglClient
+	togglClient = f
+	return func() { togglClient = old }
+}
+
+func TestTogglClientErrors(t *testing.T) {
+	defer func(base time.Duration) { serviceRetryBase = base }(serviceRetryBase)
+	serviceRetryBase = time.Millisecond
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/workspace"):
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasSuffix(r.URL.Path, "/projects"):
+			w.WriteHeader(http.StatusBadRequest)
+		case strings.HasSuffix(r.URL.Path, "/tasks"):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewTogglClient(time.Second, 2, 1000)
+	c.URL = ts.URL
+
+	if _, err := c.GetWorkspaceID("token"); !errors.Is(err, ErrInvalidAPIToken) {
+		t.Errorf("Expected ErrInvalidAPIToken but got %v", err)
+	}
+	if _, err := c.PostPipesAPI("token", "projects", nil); !errors.Is(err, ErrTogglValidation) {
+		t.Errorf("Expected ErrTogglValidation but got %v", err)
+	}
+
+	calls = 0
+	if _, err := c.PostPipesAPI("token", "tasks", nil); !errors.Is(err, ErrTogglServer) {
+		t.Errorf("Expected ErrTogglServer but got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected POST not to be retried, got %d calls", calls)
+	}
+
+	calls = 0
+	if _, err := c.GetTimeEntries("token", time.Now(), nil, nil); !errors.Is(err, ErrTogglServer) {
+		t.Errorf("Expected ErrTogglServer but got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected GET to be retried twice, got %d calls", calls)
+	}
+}
+
+func TestTogglClientResponseSizeLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 101)))
+	}))
+	defer ts.Close()
+
+	c := NewTogglClient(time.Second, 0, 100)
+	c.URL = ts.URL
+	if _, err := c.PostPipesAPI("token", "projects", nil); !errors.Is(err, ErrTogglResponseTooLarge) {
+		t.Errorf("Expected ErrTogglResponseTooLarge but got %v", err)
+	}
+}
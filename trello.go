@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// trelloAPIURL is replaced in tests
+var trelloAPIURL = "https://api.trello.com/1"
+
+// trelloCardsPerPage is the largest page Trello returns cards in
+var trelloCardsPerPage = 1000
+
+type TrelloService struct {
+	emptyService
+	workspaceID int
+	*TrelloParams
+	auth apiKeyAuth
+}
+
+// TrelloParams selects a Trello workspace, called organization in Trello API
+type TrelloParams struct {
+	AccountID string `json:"account_id"`
+}
+
+type (
+	trelloOrganization struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"displayName"`
+	}
+
+	trelloMember struct {
+		ID       string `json:"id"`
+		FullName string `json:"fullName"`
+		Email    string `json:"email"`
+	}
+
+	trelloBoard struct {
+		ID     string       `json:"id"`
+		Name   string       `json:"name"`
+		Closed bool         `json:"closed"`
+		Lists  []trelloList `json:"lists"`
+	}
+
+	trelloList struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Closed  bool   `json:"closed"`
+		IDBoard string `json:"idBoard"`
+	}
+
+	trelloCard struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Closed  bool   `json:"closed"`
+		IDBoard string `json:"idBoard"`
+		IDList  string `json:"idList"`
+	}
+)
+
+func (s *TrelloService) Name() string {
+	return "trello"
+}
+
+func (s *TrelloService) WorkspaceID() int {
+	return s.workspaceID
+}
+
+func (s *TrelloService) keyFor(objectType string) string {
+	if s.TrelloParams == nil {
+		return fmt.Sprintf("trello:account:%s", objectType)
+	}
+	return fmt.Sprintf("trello:account:%s:%s", accountKey(s.AccountID), objectType)
+}
+
+func (s *TrelloService) setParams(b []byte) error {
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s.TrelloParams == nil || s.AccountID == "" {
+		return errors.New("account_id must be present")
+	}
+	return nil
+}
+
+func (s *TrelloService) setAuthData(b []byte) error {
+	return json.Unmarshal(b, &s.auth)
+}
+
+// get requests path from Trello API and decodes the response into v
+func (s *TrelloService) get(path string, params url.Values, v interface{}) error {
+	req, err := http.NewRequest("GET", trelloAPIURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(`OAuth oauth_consumer_key="%s", oauth_token="%s"`, s.auth.APIKey, s.auth.Token))
+	client := &http.Client{Transport: newRateLimitedTransport(s.Name(), s.WorkspaceID())}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("trello: GET %s failed with status code %d: %s", path, resp.StatusCode, b)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *TrelloService) boards() ([]trelloBoard, error) {
+	var boards []trelloBoard
+	params := url.Values{"filter": {"all"}, "fields": {"id,name,closed"}, "lists": {"all"}}
+	err := s.get(fmt.Sprintf("/organizations/%s/boards", s.AccountID), params, &boards)
+	return boards, err
+}
+
+// cards returns all cards of the board, pages are requested
+// with cards created before the oldest card of the previous page
+func (s *TrelloService) cards(boardID string) ([]trelloCard, error) {
+	var cards []trelloCard
+	params := url.Values{
+		"filter": {"all"},
+		"fields": {"id,name,closed,idBoard,idList"},
+		"limit":  {fmt.Sprint(trelloCardsPerPage)},
+	}
+	for {
+		var page []trelloCard
+		if err := s.get(fmt.Sprintf("/boards/%s/cards", boardID), params, &page); err != nil {
+			return nil, err
+		}
+		cards = append(cards, page...)
+		if len(page) < trelloCardsPerPage {
+			return cards, nil
+		}
+		oldest := page[0].ID
+		for _, card := range page {
+			if card.ID < oldest {
+				oldest = card.ID
+			}
+		}
+		params.Set("before", oldest)
+	}
+}
+
+// Map Trello workspaces to local accounts
+func (s *TrelloService) Accounts() ([]*Account, error) {
+	var organizations []trelloOrganization
+	params := url.Values{"fields": {"id,displayName"}}
+	if err := s.get("/members/me/organizations", params, &organizations); err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	for _, object := range organizations {
+		accounts = append(accounts, &Account{
+			ForeignID: object.ID,
+			Name:      object.DisplayName,
+		})
+	}
+	return accounts, nil
+}
+
+// Map Trello members to local users, Trello returns e-mails
+// only of members who allow it
+func (s *TrelloService) Users() ([]*User, error) {
+	var members []trelloMember
+	params := url.Values{"fields": {"id,fullName,email"}}
+	if err := s.get(fmt.Sprintf("/organizations/%s/members", s.AccountID), params, &members); err != nil {
+		return nil, err
+	}
+	var users []*User
+	for _, object := range members {
+		users = append(users, &User{
+			ForeignID: object.ID,
+			Name:      object.FullName,
+			Email:     object.Email,
+		})
+	}
+	return users, nil
+}
+
+// Map Trello boards to projects
+func (s *TrelloService) Projects() ([]*Project, error) {
+	boards, err := s.boards()
+	if err != nil {
+		return nil, err
+	}
+	var projects []*Project
+	for _, object := range boards {
+		projects = append(projects, &Project{
+			ForeignID: object.ID,
+			Name:      object.Name,
+			Active:    !object.Closed,
+		})
+	}
+	return projects, nil
+}
+
+// Map Trello lists to tasks
+func (s *TrelloService) TodoLists() ([]*Task, error) {
+	boards, err := s.boards()
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	for _, board := range boards {
+		for _, object := range board.Lists {
+			tasks = append(tasks, &Task{
+				ForeignID:        object.ID,
+				Name:             object.Name,
+				Active:           !object.Closed && !board.Closed,
+				foreignProjectID: board.ID,
+			})
+		}
+	}
+	return tasks, nil
+}
+
+// Map Trello cards to tasks, cards in closed lists are inactive too
+func (s *TrelloService) Tasks() ([]*Task, error) {
+	boards, err := s.boards()
+	if err != nil {
+		return nil, err
+	}
+	var tasks []*Task
+	for _, board := range boards {
+		closedLists := make(map[string]bool)
+		for _, list := range board.Lists {
+			closedLists[list.ID] = list.Closed
+		}
+		cards, err := s.cards(board.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range cards {
+			tasks = append(tasks, &Task{
+				ForeignID:        object.ID,
+				Name:             object.Name,
+				Active:           !object.Closed && !closedLists[object.IDList] && !board.Closed,
+				foreignProjectID: board.ID,
+			})
+		}
+	}
+	return tasks, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTrelloTestServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != `OAuth oauth_consumer_key="key", oauth_token="token"` {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path + "?" + r.URL.Query().Get("before") {
+		case "/members/me/organizations?":
+			w.Write([]byte(`[{"id":"5abbe4b7ddc1b351ef961414","displayName":"Design"}]`))
+		case "/organizations/5abbe4b7ddc1b351ef961414/boards?":
+			w.Write([]byte(`[
+				{"id":"b1","name":"Website","closed":false,"lists":[
+					{"id":"l1","name":"Doing","closed":false,"idBoard":"b1"},
+					{"id":"l2","name":"Old","closed":true,"idBoard":"b1"}
+				]},
+				{"id":"b2","name":"Archived board","closed":true,"lists":[]}
+			]`))
+		case "/boards/b1/cards?":
+			w.Write([]byte(`[
+				{"id":"c3","name":"Header","closed":false,"idBoard":"b1","idList":"l1"},
+				{"id":"c2","name":"Footer","closed":true,"idBoard":"b1","idList":"l1"}
+			]`))
+		case "/boards/b1/cards?c2":
+			w.Write([]byte(`[{"id":"c1","name":"Logo","closed":false,"idBoard":"b1","idList":"l2"}]`))
+		case "/boards/b2/cards?":
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestTrelloService(t *testing.T) {
+	ts := newTrelloTestServer(t)
+	defer ts.Close()
+	defer func(apiURL string, perPage int) {
+		trelloAPIURL, trelloCardsPerPage = apiURL, perPage
+	}(trelloAPIURL, trelloCardsPerPage)
+	trelloAPIURL, trelloCardsPerPage = ts.URL, 2
+
+	s := getService("trello", 1)
+	if err := s.setAuthData([]byte(`{"api_key":"key","token":"token"}`)); err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := s.Accounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].ForeignID != "5abbe4b7ddc1b351ef961414" || accounts[0].Name != "Design" {
+		t.Fatalf("unexpected accounts %+v", accounts)
+	}
+	if err := s.setParams([]byte(`{"account_id":"5abbe4b7ddc1b351ef961414"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	projects, err := s.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || !projects[0].Active || projects[1].Active {
+		t.Errorf("expected closed board to be inactive, got %+v", projects)
+	}
+
+	tasks, err := s.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := map[string]bool{}
+	for _, task := range tasks {
+		active[task.Name] = task.Active
+		if task.foreignProjectID != "b1" {
+			t.Errorf("expected card %s to belong to board b1", task.Name)
+		}
+	}
+	want := map[string]bool{"Header": true, "Footer": false, "Logo": false}
+	if len(active) != len(want) {
+		t.Fatalf("expected cards of all pages, got %v", active)
+	}
+	for name, isActive := range want {
+		if active[name] != isActive {
+			t.Errorf("expected card %s active=%v", name, isActive)
+		}
+	}
+}
+
+func TestTrelloConnectionKeys(t *testing.T) {
+	s := getService("trello", 53)
+	if err := s.setParams([]byte(`{"account_id":"5abbe4b7ddc1b351ef961414"}`)); err != nil {
+		t.Fatal(err)
+	}
+	testConnectionKeys(t, s)
+	if s.keyFor(projectsPipeID) == getService("trello", 53).keyFor(projectsPipeID) {
+		t.Error("expected keys to contain the account")
+	}
+}
@@ -1,5 +1,15 @@
 package main
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// groupsConnectionID links foreign teams and groups to Toggl groups
+const groupsConnectionID = "groups"
+
 type (
 	usersRequest struct {
 		Users []*User `json:"users"`
@@ -9,8 +19,142 @@ type (
 		WorkspaceUsers []*User  `json:"users"`
 		Notifications  []string `json:"notifications"`
 	}
+
+	groupRequest struct {
+		Groups []*Group `json:"groups"`
+	}
+
+	GroupsImport struct {
+		Groups        []*Group `json:"groups"`
+		Notifications []string `json:"notifications"`
+	}
 )
 
 func (p *UsersImport) Count() int {
 	return len(p.WorkspaceUsers)
 }
+
+// removedUsers returns connected users which the service doesn't return anymore,
+// nobody is removed when the service returns no users at all
+func removedUsers(s Service, users []*User) ([]*User, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+	connection, err := loadConnection(s, usersPipeID)
+	if err != nil {
+		return nil, err
+	}
+	fetched := make(map[string]bool, len(users))
+	for _, user := range users {
+		fetched[user.ForeignID] = true
+	}
+	previous := make(map[string]*User)
+	if previousResponse, err := getUsers(s); err == nil && previousResponse != nil {
+		for _, user := range append(previousResponse.Users, previousResponse.Deactivated...) {
+			previous[user.ForeignID] = user
+		}
+	}
+	var removed []*User
+	for foreignID, togglID := range connection.Data {
+		if fetched[foreignID] || togglID == 0 {
+			continue
+		}
+		user := User{ForeignID: foreignID}
+		if u, exists := previous[foreignID]; exists {
+			user = *u
+		}
+		user.ID = togglID
+		user.Inactive = true
+		user.SendInvitation = false
+		removed = append(removed, &user)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].ForeignID < removed[j].ForeignID })
+	return removed, nil
+}
+
+// fetchGroups returns groups of the service linked to Toggl groups and users,
+// services without groups return none
+func fetchGroups(s Service) ([]*Group, error) {
+	groups, err := s.Groups()
+	if errors.Is(err, ErrNotSupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	groupConnection, err := loadConnection(s, groupsConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	userConnection, err := loadConnection(s, usersPipeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		group.ID = groupConnection.Data[group.ForeignID]
+		group.linkUsers(userConnection)
+	}
+	return groups, nil
+}
+
+// linkUsers sets Toggl IDs of the members, members who are not
+// Toggl users yet are left out and their count is returned
+func (g *Group) linkUsers(connection *Connection) int {
+	g.UserIDs = make([]int, 0, len(g.ForeignUserIDs))
+	for _, foreignID := range g.ForeignUserIDs {
+		if userID := connection.Data[foreignID]; userID > 0 {
+			g.UserIDs = append(g.UserIDs, userID)
+		}
+	}
+	return len(g.ForeignUserIDs) - len(g.UserIDs)
+}
+
+// syncUsersLifecycle deactivates removed users and syncs groups
+// as they were previewed in the users response
+func syncUsersLifecycle(p *Pipe, s Service, response *UsersResponse, connection *Connection) ([]string, error) {
+	var notifications []string
+	if len(response.Deactivated) > 0 {
+		b, err := togglClient.PostPipesAPI(p.authorization.WorkspaceToken, usersPipeID, usersRequest{Users: response.Deactivated})
+		if err != nil {
+			return nil, err
+		}
+		var usersImport UsersImport
+		if err := json.Unmarshal(b, &usersImport); err != nil {
+			return nil, err
+		}
+		for _, user := range response.Deactivated {
+			delete(connection.Data, user.ForeignID)
+			notifications = append(notifications,
+				fmt.Sprintf("User '%s' was deactivated, the user was removed from %s", user.Name, s.Name()))
+		}
+		notifications = append(notifications, usersImport.Notifications...)
+	}
+	if len(response.Groups) == 0 {
+		return notifications, nil
+	}
+	groupConnection, err := loadConnection(s, groupsConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range response.Groups {
+		if missing := group.linkUsers(connection); missing > 0 {
+			notifications = append(notifications,
+				fmt.Sprintf("%d members of group '%s' are not Toggl users yet", missing, group.Name))
+		}
+	}
+	b, err := togglClient.PostPipesAPI(p.authorization.WorkspaceToken, groupsConnectionID, groupRequest{Groups: response.Groups})
+	if err != nil {
+		return nil, err
+	}
+	var groupsImport GroupsImport
+	if err := json.Unmarshal(b, &groupsImport); err != nil {
+		return nil, err
+	}
+	for _, group := range groupsImport.Groups {
+		groupConnection.Data[group.ForeignID] = group.ID
+	}
+	if err := groupConnection.save(); err != nil {
+		return nil, err
+	}
+	return append(notifications, groupsImport.Notifications...), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestUsersLifecycle(t *testing.T) {
+	p := NewPipe(50, TestServiceName, usersPipeID)
+	p.authorization = &Authorization{WorkspaceToken: "token"}
+	p.UsersLifecycle = true
+	if err := p.NewStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if msg := p.validatePayload(nil); msg != "" {
+		t.Fatalf("expected automatic run without selection, got %s", msg)
+	}
+	s, err := p.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := NewConnection(s, usersPipeID)
+	connection.Data = map[string]int{"1": 11, "2": 12, "3": 13}
+	if err := connection.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewConnection(s, groupsConnectionID).save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fetchUsers(p); err != nil {
+		t.Fatal(err)
+	}
+	preview, err := getUsers(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Deactivated) != 1 || preview.Deactivated[0].ID != 13 || !preview.Deactivated[0].Inactive {
+		t.Errorf("expected removed user to be previewed as deactivated, got %+v", preview.Deactivated)
+	}
+	if len(preview.Groups) != 1 || len(preview.Groups[0].UserIDs) != 2 {
+		t.Errorf("expected group with linked members in preview, got %+v", preview.Groups)
+	}
+
+	fake := &fakeTogglAPI{responses: map[string][]byte{
+		usersPipeID:        []byte(`{"users":[]}`),
+		groupsConnectionID: []byte(`{"groups":[{"id":70,"name":"Design","foreign_id":"7"}]}`),
+	}}
+	defer withFakeTogglAPI(fake)()
+	if err := postUsers(p); err != nil {
+		t.Fatal(err)
+	}
+	if string(fake.payloads[usersPipeID]) != `{"users":[{"id":13,"email":"","name":"","inactive":true,"foreign_id":"3"}]}` {
+		t.Errorf("expected only the removed user to be posted, got %s", fake.payloads[usersPipeID])
+	}
+	if string(fake.payloads[groupsConnectionID]) != `{"groups":[{"name":"Design","user_ids":[11,12],"foreign_id":"7","foreign_user_ids":["1","2","4"]}]}` {
+		t.Errorf("unexpected groups payload %s", fake.payloads[groupsConnectionID])
+	}
+	if connection, err = loadConnection(s, usersPipeID); err != nil {
+		t.Fatal(err)
+	}
+	if _, linked := connection.Data["3"]; linked || len(connection.Data) != 2 {
+		t.Errorf("expected deactivated user to be unlinked, got %v", connection.Data)
+	}
+	groups, err := loadConnection(s, groupsConnectionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups.Data["7"] != 70 {
+		t.Errorf("expected group to be linked, got %v", groups.Data)
+	}
+}
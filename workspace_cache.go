@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	workspaceCacheEntry struct {
+		workspaceID int
+		invalid     bool
+		expiresAt   time.Time
+	}
+
+	// workspaceCache maps hashed Toggl API tokens to workspace IDs,
+	// so withAuth doesn't have to ask Toggl API on every request.
+	// Tokens rejected by Toggl are cached as invalid for a shorter time.
+	workspaceCache struct {
+		sync.Mutex
+		entries     map[string]workspaceCacheEntry
+		ttl         time.Duration
+		negativeTTL time.Duration
+		persistent  bool
+		prunedAt    time.Time
+	}
+)
+
+const workspaceCacheMaxEntries = 10000
+
+var workspaceIDCache = newWorkspaceCache(5*time.Minute, time.Minute, false)
+
+func init() {
+	metrics.registerGauge("workspace_cache_hit_rate", func() interface{} {
+		hits := metrics.value("workspace_cache_hits")
+		total := hits + metrics.value("workspace_cache_misses")
+		if total == 0 {
+			return 0.0
+		}
+		return float64(hits) / float64(total)
+	})
+}
+
+func newWorkspaceCache(ttl, negativeTTL time.Duration, persistent bool) *workspaceCache {
+	return &workspaceCache{
+		entries:     make(map[string]workspaceCacheEntry),
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		persistent:  persistent,
+	}
+}
+
+func hashAPIToken(APIToken string) string {
+	sum := sha256.Sum256([]byte(APIToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// workspaceID returns workspace ID for the token, resolving it
+// through Toggl API only when the cache has no fresh entry for it.
+func (c *workspaceCache) workspaceID(APIToken string) (int, error) {
+	hash := hashAPIToken(APIToken)
+	if entry, found := c.get(hash); found {
+		metrics.inc("workspace_cache_hits")
+		if entry.invalid {
+			metrics.inc("workspace_cache_negative_hits")
+			return 0, ErrInvalidAPIToken
+		}
+		return entry.workspaceID, nil
+	}
+	metrics.inc("workspace_cache_misses")
+
+	workspaceID, err := togglClient.GetWorkspaceID(APIToken)
+	if errors.Is(err, ErrInvalidAPIToken) {
+		c.set(hash, workspaceCacheEntry{invalid: true, expiresAt: time.Now().Add(c.negativeTTL)})
+		return 0, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.set(hash, workspaceCacheEntry{workspaceID: workspaceID, expiresAt: time.Now().Add(c.ttl)})
+	return workspaceID, nil
+}
+
+// invalidate drops cached workspace for the token,
+// used when Toggl API stops accepting it.
+func (c *workspaceCache) invalidate(APIToken string) {
+	hash := hashAPIToken(APIToken)
+	c.Lock()
+	delete(c.entries, hash)
+	c.Unlock()
+	metrics.inc("workspace_cache_invalidations")
+
+	if c.persistent {
+		if err := store.DeleteWorkspaceToken(hash); err != nil {
+			metrics.inc("workspace_cache_db_errors")
+		}
+	}
+}
+
+func (c *workspaceCache) get(hash string) (workspaceCacheEntry, bool) {
+	c.Lock()
+	entry, found := c.entries[hash]
+	if found && time.Now().After(entry.expiresAt) {
+		delete(c.entries, hash)
+		found = false
+	}
+	c.Unlock()
+	if found || !c.persistent {
+		return entry, found
+	}
+
+	workspaceID, found, err := store.LoadWorkspaceToken(hash)
+	if err != nil {
+		metrics.inc("workspace_cache_db_errors")
+	}
+	if !found {
+		return entry, false
+	}
+	entry = workspaceCacheEntry{workspaceID: workspaceID, expiresAt: time.Now().Add(c.ttl)}
+	c.Lock()
+	c.entries[hash] = entry
+	c.Unlock()
+	return entry, true
+}
+
+func (c *workspaceCache) set(hash string, entry workspaceCacheEntry) {
+	c.Lock()
+	// Tokens which are never looked up again would stay until the cache
+	// is full, so expired entries are also pruned every negativeTTL
+	if len(c.entries) >= workspaceCacheMaxEntries || time.Since(c.prunedAt) > c.negativeTTL {
+		c.pruneExpired()
+	}
+	c.entries[hash] = entry
+	c.Unlock()
+
+	// Invalid tokens are kept only in memory
+	if !c.persistent || entry.invalid {
+		return
+	}
+	if err := store.SaveWorkspaceToken(hash, entry.workspaceID, entry.expiresAt); err != nil {
+		metrics.inc("workspace_cache_db_errors")
+	}
+}
+
+// pruneExpired must be called with the lock held
+func (c *workspaceCache) pruneExpired() {
+	now := time.Now()
+	c.prunedAt = now
+	for hash, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, hash)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkspaceCache(t *testing.T) {
+	fake := &fakeTogglAPI{workspaces: map[string]int{"valid": 42}}
+	defer withFakeTogglAPI(fake)()
+
+	c := newWorkspaceCache(time.Minute, time.Minute, false)
+	for i := 0; i < 3; i++ {
+		workspaceID, err := c.workspaceID("valid")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if workspaceID != 42 {
+			t.Errorf("Expected workspace 42 but got %d", workspaceID)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.workspaceID("invalid"); !errors.Is(err, ErrInvalidAPIToken) {
+			t.Errorf("Expected ErrInvalidAPIToken but got %v", err)
+		}
+	}
+	if fake.calls != 2 {
+		t.Errorf("Expected 2 calls to Toggl API but got %d", fake.calls)
+	}
+
+	c.invalidate("valid")
+	if _, err := c.workspaceID("valid"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if fake.calls != 3 {
+		t.Errorf("Expected invalidated token to be resolved again, got %d calls", fake.calls)
+	}
+}
+
+func TestWorkspaceCachePrunesExpiredEntries(t *testing.T) {
+	fake := &fakeTogglAPI{workspaces: map[string]int{"first": 1, "second": 2}}
+	defer withFakeTogglAPI(fake)()
+
+	c := newWorkspaceCache(time.Millisecond, time.Millisecond, false)
+	if _, err := c.workspaceID("first"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.workspaceID("second"); err != nil {
+		t.Fatal(err)
+	}
+	c.Lock()
+	defer c.Unlock()
+	if _, found := c.entries[hashAPIToken("first")]; found || len(c.entries) != 1 {
+		t.Errorf("expected expired entry to be pruned, got %d entries", len(c.entries))
+	}
+}